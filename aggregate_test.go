@@ -1,15 +1,37 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"io/ioutil"
 	"testing"
+	"testing/fstest"
 
+	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
 	req "github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -17,6 +39,8 @@ import (
 
 const LinesPerChunk = 4000
 
+var TimedLogStart = time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
 var AllTestSuites []suite.TestingSuite
 
 func TestAllSuites(t *testing.T) {
@@ -86,6 +110,1934 @@ func (s *AggregateSuite) TestChunkData() {
 	req.Equal(s.T(), 10, s.CountInputFiles("out"), "Input files were not left as requested")
 }
 
+func (s *AggregateSuite) TestParallelGroups() {
+	s.GenerateLog("out", 10)
+	s.GenerateLog("other", 10)
+
+	options := &Options{
+		Input:     "tempTest",
+		MaxChunks: 5,
+		Parallel:  2,
+	}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Equal(s.T(), 5, options.MaxChunks, "Options were modified during the merge")
+
+	s.CheckChunkedLogOutput("out", LinesPerChunk*2, 5)
+	s.CheckChunkedLogOutput("other", LinesPerChunk*2, 5)
+}
+
+func (s *AggregateSuite) TestMemoryBudget() {
+	s.GenerateLog("out", 10)
+
+	// each part is about 50KB, so only some of them can be buffered
+	result := MainRoutine(&Options{
+		Input:       "tempTest",
+		MaxMemory:   100 * 1024,
+		WriteBuffer: 4096,
+		ReadBuffer:  1000,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	s.CheckLogOutput("out", 10)
+}
+
+func (s *AggregateSuite) TestParseByteSize() {
+	for value, expected := range map[string]ByteSize{
+		"1024":  1024,
+		"512MB": 512 << 20,
+		"1.5k":  1536,
+		"2 GiB": 2 << 30,
+		"100B":  100,
+	} {
+		size, err := ParseByteSize(value)
+		req.NoErrorf(s.T(), err, "Failed parsing %s", value)
+		req.Equalf(s.T(), expected, size, "Wrong size for %s", value)
+	}
+
+	_, err := ParseByteSize("lots")
+	req.Error(s.T(), err, "Invalid size was accepted")
+}
+
+func (s *AggregateSuite) TestDiskSpacePreflight() {
+	s.GenerateLog("out", 2)
+
+	// no filesystem can hold a trillion times the input
+	result := MainRoutine(&Options{
+		Input:       "tempTest",
+		SpaceFactor: 1e12,
+	})
+	req.Equalf(s.T(), 1, result, "Merge was not aborted for lack of space")
+
+	_, err := os.Stat("tempTest/out.full.log")
+	req.True(s.T(), os.IsNotExist(err), "Output was created despite the failed check")
+
+	// the unchanged groups are not merged, they need no room
+	options := &Options{Input: "tempTest", SkipUnchanged: true}
+	req.Equal(s.T(), 0, MainRoutine(options))
+	options.SpaceFactor = 1e12
+	req.Equalf(s.T(), 0, MainRoutine(options), "Space was required for the unchanged groups")
+}
+
+func (s *AggregateSuite) TestBandwidthLimit() {
+	s.GenerateLog("out", 4)
+
+	var rate Rate
+	req.NoError(s.T(), rate.UnmarshalFlag("1MB/s"))
+	req.Equal(s.T(), Rate(1<<20), rate)
+
+	// four parts of about 50KB are read at 1MB/s, minus the initial burst
+	start := time.Now()
+	result := MainRoutine(&Options{
+		Input:   "tempTest",
+		BwLimit: rate,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.True(s.T(), time.Since(start) >= 50*time.Millisecond, "Throughput was not limited")
+
+	s.CheckLogOutput("out", 4)
+}
+
+func (s *AggregateSuite) TestBenchData() {
+	_ = os.Mkdir("tempTest", 0777)
+	total, err := GenerateBenchData("tempTest", "bench", 1<<20, 3, 100)
+	req.NoError(s.T(), err)
+	req.True(s.T(), total >= 1<<20, "Generated less data than requested")
+
+	result := MainRoutine(&Options{
+		Input: "tempTest",
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	info, err := os.Stat("tempTest/bench.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), total, info.Size(), "Merged output size does not match the input")
+}
+
+func (s *AggregateSuite) TestBenchOptions() {
+	user := &Options{Input: "/var/log", Logrotate: "/etc/logrotate.d/app", Tee: []string{"tcp://sink:514"},
+		ReplayRate: 100, Delete: true, DeleteEmpty: true, Trash: true, InteractiveDelete: true, MaxChunks: 4,
+		Estimate: true, Combine: []string{"a=>b"}, FromDate: "2021-03-01", MinSize: 1, MaxAge: time.Hour,
+		SkipUnchanged: true, Sign: "key.pem", Interactive: true, WriteBuffer: 4096}
+	bench := benchOptions(user, "tempTest")
+	req.Equal(s.T(), Options{Input: "tempTest", MaxChunks: 4, WriteBuffer: 4096}, bench, "Options not affecting the throughput were kept")
+	req.Equal(s.T(), flags.Filename("/var/log"), user.Input, "Options of the user were changed")
+}
+
+func (s *AggregateSuite) TestCat() {
+	s.GenerateLog("out", 3)
+	s.GenerateLog("other", 2)
+
+	var buf bytes.Buffer
+	err := CatLogs(&buf, &Options{Input: "tempTest"}, "out")
+	req.NoError(s.T(), err)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	req.Len(s.T(), lines, LinesPerChunk*3, "Wrong number of lines printed")
+	for idx, line := range lines {
+		req.Equal(s.T(), fmt.Sprintf("[Line %d]", idx), line, "Lines printed out of order")
+	}
+	req.Equal(s.T(), 0, len(s.findOutputs()), "Cat created output files")
+
+	err = CatLogs(&bytes.Buffer{}, &Options{Input: "tempTest"}, "missing")
+	req.Error(s.T(), err, "Unknown base name was accepted")
+
+	// the content options apply as in the merge
+	options := &Options{Input: "tempTest", Contains: []string{"Line 1"}, Tag: []string{"host=web-01"}, Combine: []string{"out, other=>all"}}
+	buf.Reset()
+	req.NoError(s.T(), CatLogs(&buf, options, "all"))
+	req.Equal(s.T(), 0, MainRoutine(options))
+	merged, err := ioutil.ReadFile("tempTest/all.full.log")
+	req.NoError(s.T(), err)
+	req.NotEmpty(s.T(), merged)
+	req.Equal(s.T(), string(merged), buf.String(), "Cat differs from the merge")
+
+	buf.Reset()
+	req.NoError(s.T(), CatLogs(&buf, &Options{Input: "tempTest", Header: true}, "out"))
+	req.True(s.T(), strings.HasPrefix(buf.String(), headerPrefix), "Header was not printed")
+	req.Contains(s.T(), buf.String(), headerPrefix+"Part: out.3.log\n")
+}
+
+func (s *AggregateSuite) TestTee() {
+	s.GenerateLog("out", 3)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(s.T(), err)
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		data, _ := ioutil.ReadAll(conn)
+		_ = conn.Close()
+		received <- data
+	}()
+
+	result := MainRoutine(&Options{
+		Input: "tempTest",
+		Tee:   []string{"tempTest/tee.txt", "tcp://" + listener.Addr().String()},
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 3)
+
+	merged, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	teed, err := ioutil.ReadFile("tempTest/tee.txt")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), merged, teed, "File sink differs from the output")
+	req.Equal(s.T(), merged, <-received, "Remote sink differs from the output")
+
+	result = MainRoutine(&Options{
+		Input: "tempTest",
+		Tee:   []string{"tempTest/missing/tee.txt"},
+	})
+	req.Equalf(s.T(), 1, result, "Unwritable sink was accepted")
+}
+
+func (s *AggregateSuite) TestRemoteSinkSpool() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(s.T(), err)
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	// the sink reads nothing until the writes are done
+	release := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		<-release
+		data, _ := ioutil.ReadAll(conn)
+		_ = conn.Close()
+		received <- data
+	}()
+
+	sink, err := newRemoteSink(listener.Addr().String())
+	req.NoError(s.T(), err)
+	var sent bytes.Buffer
+	for idx := 0; sent.Len() < 32<<20; idx++ {
+		line := []byte(fmt.Sprintf("[Line %d]\n", idx))
+		sent.Write(line)
+		_, err := sink.Write(line)
+		req.NoError(s.T(), err)
+	}
+	sink.mu.Lock()
+	spooled := sink.spool != nil
+	sink.mu.Unlock()
+	close(release)
+	req.True(s.T(), spooled, "Batches over the queue were not spooled")
+	spool := sink.spool.Name()
+	req.NoError(s.T(), sink.Close())
+	req.NoFileExists(s.T(), spool, "Spool was left behind")
+	req.True(s.T(), bytes.Equal(sent.Bytes(), <-received), "Remote sink lost or reordered data")
+}
+
+func (s *AggregateSuite) TestReplayPacing() {
+	var rate LineRate
+	req.NoError(s.T(), rate.UnmarshalFlag("1000/s"))
+	req.EqualValues(s.T(), 1000, rate)
+	req.NoError(s.T(), rate.UnmarshalFlag("600/m"))
+	req.EqualValues(s.T(), 10, rate)
+	req.Error(s.T(), rate.UnmarshalFlag("fast"))
+
+	// the lines are spaced like their timestamps, going back in time or
+	// without a timestamp they are sent at once
+	var sent []time.Duration
+	start := time.Now()
+	record := func(line []byte) { sent = append(sent, time.Since(start)) }
+	pacer := newReplayPacer(0, true, '\n')
+	pacer.pace([]byte("2021-03-01T00:00:00.000Z a\n2021-03-01T00:00:00.200Z b\nno time\n"), record)
+	pacer.pace([]byte("2021-03-01T00:00:00.100Z c\n2021-03-01T00:00:00.300Z d\npartial"), record)
+	req.Len(s.T(), sent, 5)
+	req.GreaterOrEqual(s.T(), int64(sent[1]), int64(180*time.Millisecond), "Timestamp spacing was not kept")
+	req.Less(s.T(), int64(sent[3]-sent[2]), int64(50*time.Millisecond), "Line going back in time was held")
+	req.GreaterOrEqual(s.T(), int64(sent[4]), int64(280*time.Millisecond), "Timestamp spacing was not kept")
+	pacer.flush(record)
+	req.Len(s.T(), sent, 6, "Open line was not flushed")
+
+	var lines strings.Builder
+	for idx := 0; idx < 40; idx++ {
+		fmt.Fprintf(&lines, "[Line %d]\n", idx)
+	}
+	req.NoError(s.T(), os.MkdirAll("tempTest", 0755))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log", []byte(lines.String()), 0644))
+	start = time.Now()
+	result := MainRoutine(&Options{Input: "tempTest", Tee: []string{"tempTest/tee.txt"}, ReplayRate: 100})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.GreaterOrEqual(s.T(), int64(time.Since(start)), int64(250*time.Millisecond), "Replay rate was not applied")
+	teed, err := ioutil.ReadFile("tempTest/tee.txt")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), lines.String(), string(teed))
+
+	result = MainRoutine(&Options{Input: "tempTest", ReplayRealtime: true})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Replay without a sink was accepted")
+}
+
+func (s *AggregateSuite) TestSign() {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(s.T(), err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	req.NoError(s.T(), err)
+
+	for _, key := range []crypto.Signer{ecKey, edKey} {
+		s.DeleteLogDir()
+		s.GenerateLog("out", 3)
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		req.NoError(s.T(), err)
+		keyFile := "tempTest/key.pem"
+		req.NoError(s.T(), ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+		result := MainRoutine(&Options{Input: "tempTest", Sign: flags.Filename(keyFile)})
+		req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+		data, err := ioutil.ReadFile("tempTest/out.full.log")
+		req.NoError(s.T(), err)
+		signature, err := ioutil.ReadFile("tempTest/out.full.log" + signatureSuffix)
+		req.NoError(s.T(), err, "Signature was not written")
+		req.NoError(s.T(), VerifySignature(key.Public(), data, signature), "%T signature does not verify", key)
+
+		data[0] ^= 1
+		req.Error(s.T(), VerifySignature(key.Public(), data, signature), "Tampered output verified")
+	}
+
+	result := MainRoutine(&Options{Input: "tempTest", Sign: "tempTest/out.1.log"})
+	req.Equalf(s.T(), 1, result, "Invalid key was accepted")
+}
+
+func (s *AggregateSuite) TestAnonymizeIPs() {
+	var buf bytes.Buffer
+	anonymizer := newTransformWriter(&buf, []lineTransform{newIPAnonymizer("").anonymize}, '\n')
+	for _, piece := range []string{"from 192.168.", "10.42 and 2001:db8:85a3::8a2e:370:7334\n", "at 10:00:00 Foo::bar ", "1.2.3.4"} {
+		_, _ = anonymizer.Write([]byte(piece))
+	}
+	req.NoError(s.T(), anonymizer.Flush())
+	req.Equal(s.T(), "from 192.168.10.0 and 2001:db8:85a3::\nat 10:00:00 Foo::bar 1.2.3.0", buf.String())
+
+	s.GenerateLog("out", 2)
+	f, _ := os.OpenFile("tempTest/out.1.log", os.O_APPEND|os.O_WRONLY, 0)
+	_, _ = f.WriteString("request from 10.0.0.1\nrequest from 10.0.0.2\n")
+	_ = f.Close()
+
+	result := MainRoutine(&Options{Input: "tempTest", AnonymizeIPs: true, AnonymizeSalt: "secret"})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	data, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	req.NotContains(s.T(), string(data), "10.0.0.", "Addresses were not anonymized")
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	req.Len(s.T(), lines, LinesPerChunk*2+2)
+	req.Regexp(s.T(), `^request from ip-[0-9a-f]{16}$`, lines[len(lines)-1])
+	req.NotEqual(s.T(), lines[len(lines)-2], lines[len(lines)-1], "Different addresses hashed the same")
+}
+
+func (s *AggregateSuite) TestFieldFilter() {
+	drop := newFieldFilter([]string{"password,authorization", "cookie"}, nil, '\n')
+	for line, expected := range map[string]string{
+		`{"user":"bob","password":"x","nested":{"password":"y"},"n":1}` + "\n": `{"user":"bob","nested":{"password":"y"},"n":1}` + "\n",
+		`{"user":"bob"}` + "\r\n":                                   `{"user":"bob"}` + "\r\n",
+		"2021-03-01 INFO login user=bob password=\"a b\" ok=true\n": "2021-03-01 INFO login user=bob ok=true\n",
+		"cookie=abc user=bob\n":                                     "user=bob\n",
+		"{not json password=x\n":                                    "{not json password=x\n",
+		"plain text line\n":                                         "plain text line\n",
+	} {
+		req.Equalf(s.T(), expected, string(drop.filter([]byte(line))), "Wrong filtering of %q", line)
+	}
+
+	keep := newFieldFilter([]string{"user"}, []string{"level,msg"}, '\n')
+	req.Equal(s.T(), `{"level":"info","msg":"<ok>"}`, string(keep.filter([]byte(`{"level":"info","user":"bob","msg":"<ok>"}`))))
+	req.Equal(s.T(), "ts level=info msg=\"hello world\"", string(keep.filter([]byte("ts level=info user=bob msg=\"hello world\" id=3"))))
+
+	// the records end with the delimiter of the run, not with a newline
+	nul := newFieldFilter([]string{"password"}, nil, 0)
+	req.Equal(s.T(), "{\"user\":\"bob\",\"n\":1}\x00", string(nul.filter([]byte("{\"user\":\"bob\",\n\"password\":\"x\",\n\"n\":1}\x00"))))
+	req.Equal(s.T(), "user=bob\nok=true\x00", string(nul.filter([]byte("user=bob password=x\nok=true\x00"))))
+	req.Equal(s.T(), "user=bob\nok=true\x00", string(nul.filter([]byte("user=bob\npassword=x ok=true\x00"))))
+}
+
+func (s *AggregateSuite) TestContainsFilter() {
+	var buf bytes.Buffer
+	matcher := newTransformWriter(&buf, []lineTransform{newLineSelector(newLineMatcher([]string{"error", "(fatal)"}, false, false), '\n').filter}, '\n')
+	long := strings.Repeat("x", transformLineLimit)
+	for _, piece := range []string{"an error\nno", "thing\n(fatal) stop\n", "error " + long, long + "\n", long + "\nlast error"} {
+		_, _ = matcher.Write([]byte(piece))
+	}
+	req.NoError(s.T(), matcher.Flush())
+	req.Equal(s.T(), "an error\n(fatal) stop\nerror "+long+long+"\nlast error", buf.String())
+
+	modified := newLineMatcher([]string{"Error", "disk"}, true, true)
+	for line, expected := range map[string]bool{
+		"an ERROR occurred\n":  true,
+		"errors: none\n":       false,
+		"disk_usage high\n":    false,
+		"[disk] full\n":        true,
+		"no disks, no error\n": true,
+	} {
+		req.Equalf(s.T(), expected, modified.matches([]byte(line)), "Wrong match of %q", line)
+	}
+
+	s.GenerateLog("out", 2)
+	// the texts are not regexps, [ is matched as is, and a line matching
+	// several of them is written once
+	result := MainRoutine(&Options{Input: "tempTest", Contains: []string{"[Line 10", "[Line 100", "Line 1", "[Line 7999]"}})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	data, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	// 1, 10 to 19, 100 to 199, 1000 to 1999 and 7999
+	req.Len(s.T(), lines, 1112)
+	req.Equal(s.T(), "[Line 1]", lines[0])
+	req.Equal(s.T(), "[Line 10]", lines[1])
+	req.Equal(s.T(), "[Line 7999]", lines[len(lines)-1])
+}
+
+func (s *AggregateSuite) TestCountMatches() {
+	s.GenerateLog("out", 2)
+	options := &Options{Input: "tempTest", Contains: []string{"Line 1", "[Line 7999]", "line 2"}}
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	counts := CountMatches(nil, "tempTest", allFiles, options)
+	req.Len(s.T(), counts, 1)
+	out := counts[0]
+	req.NoError(s.T(), out.Err)
+	req.Len(s.T(), out.Parts, 2)
+	req.Equal(s.T(), int64(8000), out.Total.Lines)
+	// 1, 10 to 19, 100 to 199, 1000 to 1999 and 7999, line 2 differs in case
+	req.Equal(s.T(), int64(1112), out.Total.Kept)
+	req.Equal(s.T(), []int64{1111, 1, 0}, out.Total.Texts)
+	req.Equal(s.T(), out.Total.Lines, out.Parts[0].Lines+out.Parts[1].Lines)
+
+	options.IgnoreCase = true
+	counts = CountMatches(nil, "tempTest", allFiles, options)
+	req.Equal(s.T(), int64(1111), counts[0].Total.Texts[2])
+
+	var buf bytes.Buffer
+	req.NoError(s.T(), WriteMatchCounts(&buf, counts, options.Contains))
+	req.Contains(s.T(), buf.String(), `"[Line 7999]"`)
+	req.Contains(s.T(), buf.String(), "  out.1.log")
+
+	result := MainRoutine(&Options{Input: "tempTest", Count: true})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Count without --contains was accepted")
+	result = MainRoutine(&Options{Input: "tempTest", Count: true, Contains: []string{"Line 1"}})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Empty(s.T(), s.findOutputs(), "Count wrote outputs")
+}
+
+func (s *AggregateSuite) TestPreviewFilter() {
+	s.GenerateLog("out", 2)
+	options := &Options{Input: "tempTest", Contains: []string{"Line 1", "[Line 7999]"}}
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	previews := PreviewFilter(nil, "tempTest", allFiles, options, 3)
+	req.Len(s.T(), previews, 1)
+	out := previews[0]
+	req.NoError(s.T(), out.Err)
+	// the parts are read in merge order, the last line is in the second one
+	req.Equal(s.T(), []PreviewLine{{"out.2.log", "[Line 1]"}, {"out.2.log", "[Line 10]"}, {"out.2.log", "[Line 11]"}}, out.Matched[0])
+	req.Equal(s.T(), []PreviewLine{{"out.1.log", "[Line 7999]"}}, out.Matched[1])
+	req.Equal(s.T(), []PreviewLine{{"out.2.log", "[Line 0]"}, {"out.2.log", "[Line 2]"}, {"out.2.log", "[Line 3]"}}, out.Rejected)
+
+	var buf bytes.Buffer
+	req.NoError(s.T(), WritePreview(&buf, previews, options.Contains))
+	req.Contains(s.T(), buf.String(), "Containing \"[Line 7999]\", 1 lines:\n    out.1.log: [Line 7999]\n")
+	req.Contains(s.T(), buf.String(), "Dropped, 3 lines:\n    out.2.log: [Line 0]\n")
+
+	result := MainRoutine(&Options{Input: "tempTest", Preview: 3})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Preview without --contains was accepted")
+	result = MainRoutine(&Options{Input: "tempTest", Preview: 3, Contains: []string{"Line 1"}})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Empty(s.T(), s.findOutputs(), "Preview wrote outputs")
+}
+
+func (s *AggregateSuite) TestHighlight() {
+	h := newHighlighter(&Options{Contains: []string{"disk"}, IgnoreCase: true, WordRegexp: true})
+	req.Equal(s.T(), "t0 \x1b[31mERROR\x1b[0m \x1b[1;35mDisk\x1b[0m full, disks: 2 \x1b[1;35mdisk\x1b[0m\n",
+		string(h.highlight([]byte("t0 ERROR Disk full, disks: 2 disk\n"))))
+	req.Equal(s.T(), "t0 \x1b[33mwarning\x1b[0m\n", string(h.highlight([]byte("t0 warning\n"))))
+	req.Equal(s.T(), "no match\n", string(h.highlight([]byte("no match\n"))))
+
+	// the colors are written to the terminals only
+	_ = os.Mkdir("tempTest", 0777)
+	f, err := os.Create("tempTest/plain")
+	req.NoError(s.T(), err)
+	defer f.Close()
+	req.False(s.T(), isTerminal(f))
+}
+
+func (s *AggregateSuite) TestTagInjection() {
+	_, err := newTagInjector([]string{"host"}, false)
+	req.Error(s.T(), err, "Tag without value was accepted")
+
+	tags, err := newTagInjector([]string{"host=web-01", "env=prod eu"}, true)
+	req.NoError(s.T(), err)
+	for line, expected := range map[string]string{
+		`{"msg":"ok"}` + "\n": `{"host":"web-01","env":"prod eu","msg":"ok"}` + "\n",
+		"  { }\n":             `  {"host":"web-01","env":"prod eu" }` + "\n",
+		"plain line\n":        "host=web-01 env=\"prod eu\" plain line\n",
+	} {
+		req.Equalf(s.T(), expected, string(tags.inject([]byte(line))), "Wrong tagging of %q", line)
+	}
+
+	s.GenerateLog("out", 2)
+	f, _ := os.OpenFile("tempTest/out.1.log", os.O_APPEND|os.O_WRONLY, 0)
+	_, _ = f.WriteString(`{"level":"info","host":"app"}` + "\n")
+	_ = f.Close()
+	// the tags are not removed by the field filter
+	result := MainRoutine(&Options{Input: "tempTest", Tag: []string{"host=web-01"}, DropFields: []string{"host"}})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	data, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	req.Equal(s.T(), "[Line 0]", lines[0], "Text line was prefixed without --tag-prefix")
+	req.Equal(s.T(), `{"host":"web-01","level":"info"}`, lines[len(lines)-1])
+}
+
+// breakPart replaces a part with a dangling symlink, found by the scan but
+// impossible to read even for root
+func (s *AggregateSuite) breakPart(name string) {
+	req.NoError(s.T(), os.Remove(name))
+	req.NoError(s.T(), os.Symlink("missing.log", name))
+}
+
+func (s *AggregateSuite) TestFailFast() {
+	s.GenerateLog("out", 3)
+	s.breakPart("tempTest/out.2.log")
+
+	result := MainRoutine(&Options{Input: "tempTest", Delete: true})
+	req.Equalf(s.T(), exitPartUnreadable, result, "Unreadable part was not reported")
+	req.Equal(s.T(), 3, s.CountInputFiles("out"), "Parts were deleted after a failed merge")
+
+	result = MainRoutine(&Options{Input: "tempTest", SkipErrors: true, Strict: true})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Conflicting options were accepted")
+}
+
+func (s *AggregateSuite) TestSkipErrors() {
+	for _, maxMemory := range []ByteSize{0, 1} {
+		s.DeleteLogDir()
+		s.GenerateLog("out", 3)
+		s.breakPart("tempTest/out.2.log")
+
+		result := MainRoutine(&Options{Input: "tempTest", Delete: true, SkipErrors: true, MaxMemory: maxMemory})
+		req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+		data, err := ioutil.ReadFile("tempTest/out.full.log")
+		req.NoError(s.T(), err)
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		req.Len(s.T(), lines, LinesPerChunk*2, "Skipped part was merged")
+		req.Equal(s.T(), fmt.Sprintf("[Line %d]", LinesPerChunk-1), lines[LinesPerChunk-1])
+		req.Equal(s.T(), fmt.Sprintf("[Line %d]", LinesPerChunk*2), lines[LinesPerChunk])
+
+		_, err = os.Lstat("tempTest/out.2.log")
+		req.NoError(s.T(), err, "Skipped part was deleted")
+		req.Equal(s.T(), 1, s.CountInputFiles("out"), "Merged parts were not deleted")
+	}
+}
+
+// flakyWriter fails the first writes after accepting part of the data
+type flakyWriter struct {
+	failures int
+	buf      bytes.Buffer
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.failures > 0 {
+		w.failures--
+		n := len(p) / 2
+		w.buf.Write(p[:n])
+		return n, errors.New("temporary failure")
+	}
+	return w.buf.Write(p)
+}
+
+func (s *AggregateSuite) TestRetry() {
+	retry := newRetryPolicy(3, time.Millisecond)
+
+	calls := 0
+	err := retry.do("test", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), 3, calls)
+
+	calls = 0
+	_, err = os.Open("tempTest/missing.log")
+	req.Error(s.T(), retry.do("test", func() error {
+		calls++
+		return err
+	}))
+	req.Equal(s.T(), 1, calls, "Missing file was retried")
+
+	calls = 0
+	req.Error(s.T(), retry.do("test", func() error {
+		calls++
+		return errors.New("persistent failure")
+	}))
+	req.Equal(s.T(), 4, calls, "Wrong number of retries")
+
+	flaky := &flakyWriter{failures: 2}
+	n, err := newRetryWriter(flaky, "test", retry).Write([]byte("0123456789"))
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), 10, n)
+	req.Equal(s.T(), "0123456789", flaky.buf.String(), "Retried write did not resume")
+
+	s.GenerateLog("out", 3)
+	result := MainRoutine(&Options{Input: "tempTest", Retries: 2, RetryBackoff: time.Millisecond})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 3)
+}
+
+func (s *AggregateSuite) TestTimeout() {
+	// a pipe never written blocks like a hung filesystem
+	hung, writer := io.Pipe()
+	defer writer.Close()
+	start := time.Now()
+	_, err := newDeadlineReader(hung, start.Add(50*time.Millisecond)).Read(make([]byte, 16))
+	req.Equal(s.T(), errTimeout, err)
+	req.Less(s.T(), int64(time.Since(start)), int64(time.Second), "Read was not abandoned")
+
+	// the reads go through the buffer of the reader, not one as large as
+	// the caller's
+	n, err := newDeadlineReader(bytes.NewReader(make([]byte, 1<<20)), time.Now().Add(time.Hour)).Read(make([]byte, 1<<20))
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), deadlineReadSize, n)
+
+	// the os pipes have deadlines of their own
+	pipe, pipeWriter, err := os.Pipe()
+	req.NoError(s.T(), err)
+	defer pipe.Close()
+	defer pipeWriter.Close()
+	start = time.Now()
+	reader := newDeadlineReader(pipe, start.Add(50*time.Millisecond))
+	req.IsType(s.T(), fileDeadlineReader{}, reader)
+	_, err = reader.Read(make([]byte, 16))
+	req.Equal(s.T(), errTimeout, err)
+	req.Less(s.T(), int64(time.Since(start)), int64(time.Second), "Read was not abandoned")
+
+	s.GenerateLog("out", 3)
+	result := MainRoutine(&Options{Input: "tempTest", Timeout: time.Nanosecond, Delete: true})
+	req.Equalf(s.T(), exitPartUnreadable, result, "Timed out parts were not reported")
+	req.Equal(s.T(), 3, s.CountInputFiles("out"), "Parts were deleted after a timeout")
+
+	result = MainRoutine(&Options{Input: "tempTest", Timeout: time.Nanosecond, Delete: true, SkipErrors: true})
+	req.Equalf(s.T(), 0, result, "Timed out parts stopped the run")
+	req.Equal(s.T(), 3, s.CountInputFiles("out"), "Timed out parts were deleted")
+
+	result = MainRoutine(&Options{Input: "tempTest", Timeout: time.Hour, FileTimeout: time.Minute})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 3)
+}
+
+func (s *AggregateSuite) TestPartialOutput() {
+	s.GenerateLog("out", 3)
+	s.breakPart("tempTest/out.2.log")
+
+	result := MainRoutine(&Options{Input: "tempTest"})
+	req.Equalf(s.T(), exitPartUnreadable, result, "Unreadable part was not reported")
+	req.NoFileExists(s.T(), "tempTest/out.full.log", "Partial output was left behind")
+
+	result = MainRoutine(&Options{Input: "tempTest", KeepPartial: true})
+	req.Equalf(s.T(), exitPartUnreadable, result, "Unreadable part was not reported")
+	req.FileExists(s.T(), "tempTest/out.full.log", "Partial output was removed with --keep-partial")
+
+	// a timed out chunk is removed even when the failures are skipped
+	s.DeleteLogDir()
+	s.GenerateLog("out", 3)
+	result = MainRoutine(&Options{Input: "tempTest", ChunkTimeout: time.Nanosecond, SkipErrors: true, Delete: true})
+	req.Equalf(s.T(), 0, result, "Timed out chunk stopped the run")
+	req.NoFileExists(s.T(), "tempTest/out.full.log", "Timed out chunk was left behind")
+	req.Equal(s.T(), 3, s.CountInputFiles("out"), "Parts of a timed out chunk were deleted")
+
+	result = MainRoutine(&Options{Input: "tempTest", ChunkTimeout: time.Hour})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 3)
+
+	// an output appended to only loses what the failed chunk wrote
+	path := "tempTest/appended.log"
+	req.NoError(s.T(), ioutil.WriteFile(path, []byte("kept\npartial"), 0644))
+	removed, err := discardPartial(path, int64(len("kept\n")))
+	req.NoError(s.T(), err)
+	req.False(s.T(), removed)
+	data, err := ioutil.ReadFile(path)
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "kept\n", string(data))
+}
+
+func (s *AggregateSuite) TestOutputsNotReingested() {
+	s.GenerateLog("out", 4)
+	s.GenerateLog("db.fulldump", 4)
+	result := MainRoutine(&Options{
+		Input:     "tempTest",
+		MaxChunks: 2,
+		Index:     true,
+		Histogram: time.Hour,
+		Tee:       []string{"tempTest/tee.1.log"},
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles, 2, "Outputs or sidecars were scanned as parts")
+	req.Len(s.T(), allFiles["out"], 4)
+	req.Len(s.T(), allFiles["db"], 4, "Parts with full in the name were ignored")
+}
+
+func (s *AggregateSuite) TestIfExists() {
+	s.GenerateLog("out", 2)
+	req.Equal(s.T(), 0, MainRoutine(&Options{Input: "tempTest", Index: true}))
+	first, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+
+	result := MainRoutine(&Options{Input: "tempTest", IfExists: "fail"})
+	req.Equalf(s.T(), exitOutputExists, result, "Existing output was not detected")
+	s.CheckLogOutput("out", 2)
+
+	result = MainRoutine(&Options{Input: "tempTest", IfExists: "rename", Index: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	backup, err := ioutil.ReadFile("tempTest/out.full.log.1")
+	req.NoError(s.T(), err, "Existing output was not renamed")
+	req.Equal(s.T(), first, backup)
+	_, err = os.Stat("tempTest/out.full.log.1" + indexFileSuffix)
+	req.NoError(s.T(), err, "Sidecar was not renamed with the output")
+	s.CheckLogOutput("out", 2)
+
+	result = MainRoutine(&Options{Input: "tempTest", IfExists: "append", Index: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	appended, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), append(append([]byte{}, first...), first...), appended)
+
+	_, err = loadIndex("tempTest/out.full.log")
+	req.NoError(s.T(), err, "Index does not cover the whole appended output")
+	var matches []SearchMatch
+	req.NoError(s.T(), SearchFile("tempTest/out.full.log", "[Line 10]", true, '\n', func(match SearchMatch) {
+		matches = append(matches, match)
+	}))
+	req.Len(s.T(), matches, 2)
+}
+
+func (s *AggregateSuite) TestTrash() {
+	s.GenerateLog("out", 3)
+	expired := filepath.Join("tempTest", defaultTrashDir, "expired")
+	req.NoError(s.T(), os.MkdirAll(expired, 0755))
+	old := time.Now().Add(-2 * time.Hour)
+	req.NoError(s.T(), os.Chtimes(expired, old, old))
+
+	result := MainRoutine(&Options{Input: "tempTest", Delete: true, Trash: true, TrashTTL: time.Hour})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 3)
+	left, _ := filepath.Glob("tempTest/out.[0-9].log")
+	req.Empty(s.T(), left, "Originals were left in the input path")
+
+	trashed, err := filepath.Glob(filepath.Join("tempTest", defaultTrashDir, "*", "out.*.log"))
+	req.NoError(s.T(), err)
+	req.Len(s.T(), trashed, 3, "Originals were not moved to the trash")
+	_, err = os.Stat(expired)
+	req.True(s.T(), os.IsNotExist(err), "Expired trash was not purged")
+
+	// a trash on another filesystem gets a copy of the file
+	trash, err := newTrashBin(filepath.Join("tempTest", defaultTrashDir), 0)
+	req.NoError(s.T(), err)
+	trash.rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/moved.log", []byte("content\n"), 0640))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	req.NoError(s.T(), os.Chtimes("tempTest/moved.log", mtime, mtime))
+	req.NoError(s.T(), trash.move("tempTest/moved.log"))
+	_, err = os.Stat("tempTest/moved.log")
+	req.True(s.T(), os.IsNotExist(err), "Copied file was not removed")
+	info, err := os.Stat(filepath.Join(trash.run, "moved.log"))
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), os.FileMode(0640), info.Mode().Perm())
+	req.True(s.T(), mtime.Equal(info.ModTime()), "Mtime was not kept")
+
+	// the other errors are not taken for a move between filesystems
+	trash.rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EACCES}
+	}
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/denied.log", nil, 0644))
+	req.Error(s.T(), trash.move("tempTest/denied.log"))
+	req.FileExists(s.T(), "tempTest/denied.log")
+}
+
+func (s *AggregateSuite) TestOnlyMergedPartsDeleted() {
+	s.GenerateLog("out", 3)
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	list := allFiles["out"]
+
+	// removed after the scan, none of its bytes are in the output
+	req.NoError(s.T(), os.Remove("tempTest/out.2.log"))
+	run := &mergeRun{writeBuffer: defaultWriteBuffer, readBuffer: defaultReadBuffer, skipErrors: true, report: newRunReport()}
+	failures, err := MergeLogList("tempTest", "out", list, &Options{}, run)
+	req.NoError(s.T(), err)
+	req.Len(s.T(), failures, 1)
+	req.Equal(s.T(), "out.2.log", failures[0].Name)
+	req.Len(s.T(), withoutFailures(list, failures), 2)
+
+	// an output that cannot be written keeps every part
+	readOnly, err := os.Open("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	failures, _ = MergeLogChunk(context.Background(), "tempTest", readOnly, list, run, nil, nil)
+	req.Len(s.T(), failures, 3, "Parts not written were reported merged")
+	req.Empty(s.T(), withoutFailures(list, failures))
+}
+
+func (s *AggregateSuite) TestSkipErrorsPartFailingPartway() {
+	// truncated after the scan, the part is streamed and its first bytes are
+	// in the output when it fails
+	mergeTruncated := func(keepPartial bool) ([]*logFile, []FileFailure, *mergeRun) {
+		s.DeleteLogDir()
+		s.GenerateLog("out", 3)
+		allFiles, err := ScanFolderForFiles("tempTest")
+		req.NoError(s.T(), err)
+		req.NoError(s.T(), os.Truncate("tempTest/out.2.log", 100))
+		run := &mergeRun{writeBuffer: defaultWriteBuffer, readBuffer: defaultReadBuffer, memory: newMemoryBudget(1),
+			skipErrors: true, keepPartial: keepPartial, report: newRunReport()}
+		failures, err := MergeLogList("tempTest", "out", allFiles["out"], &Options{}, run)
+		req.NoError(s.T(), err)
+		return allFiles["out"], failures, run
+	}
+
+	list, failures, run := mergeTruncated(false)
+	req.Len(s.T(), failures, 3, "A chunk ending a part halfway was reported merged")
+	req.Empty(s.T(), withoutFailures(list, failures))
+	req.Len(s.T(), run.report.groups["out"].Partial, 1, "Dropped output was not reported")
+	req.NoFileExists(s.T(), "tempTest/out.full.log", "Output ending a part halfway was left behind")
+
+	_, failures, _ = mergeTruncated(true)
+	req.Len(s.T(), failures, 3)
+	req.FileExists(s.T(), "tempTest/out.full.log", "Partial output was removed with --keep-partial")
+}
+
+func (s *AggregateSuite) TestDeleteEmpty() {
+	s.GenerateLog("out", 3)
+	for _, name := range []string{"tempTest/out.4.log", "tempTest/empty.1.log"} {
+		f, _ := os.Create(name)
+		_ = f.Close()
+	}
+
+	result := MainRoutine(&Options{Input: "tempTest", DeleteEmpty: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 3)
+	for _, name := range []string{"tempTest/out.4.log", "tempTest/empty.1.log", "tempTest/empty.full.log"} {
+		_, err := os.Stat(name)
+		req.Truef(s.T(), os.IsNotExist(err), "%s was not removed or was created", name)
+	}
+	req.Equal(s.T(), 3, s.CountInputFiles("out"), "Non empty parts were deleted")
+}
+
+func (s *AggregateSuite) TestSkipUnchanged() {
+	s.GenerateLog("out", 3)
+	options := &Options{Input: "tempTest", SkipUnchanged: true}
+	req.Equal(s.T(), 0, MainRoutine(options))
+	s.CheckLogOutput("out", 3)
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	req.NoError(s.T(), os.Chtimes("tempTest/out.full.log", old, old))
+	mergedAt := func() time.Time {
+		info, err := os.Stat("tempTest/out.full.log")
+		req.NoError(s.T(), err)
+		return info.ModTime()
+	}
+
+	req.Equal(s.T(), 0, MainRoutine(options))
+	req.Equal(s.T(), old, mergedAt(), "Unchanged group was merged again")
+
+	options.Index = true
+	req.Equal(s.T(), 0, MainRoutine(options))
+	req.NotEqual(s.T(), old, mergedAt(), "Changed settings did not merge again")
+
+	req.NoError(s.T(), os.Chtimes("tempTest/out.full.log", old, old))
+	f, _ := os.OpenFile("tempTest/out.1.log", os.O_APPEND|os.O_WRONLY, 0)
+	_, _ = f.WriteString("one more line\n")
+	_ = f.Close()
+	req.Equal(s.T(), 0, MainRoutine(options))
+	req.NotEqual(s.T(), old, mergedAt(), "Changed part did not merge again")
+
+	// every option changing the bytes, the names or the metadata of the
+	// outputs is part of the settings
+	base := outputSettings(&Options{})
+	for name, changed := range map[string]*Options{
+		"anonymize-salt":     {AnonymizeSalt: "pepper"},
+		"if-exists":          {IfExists: ifExistsAppend},
+		"suffix":             {Suffix: "merged"},
+		"timestamped-output": {TimestampedOutput: true},
+		"name-template":      {NameTemplate: "{{.Base}}{{.Chunk}}.merged.log"},
+		"output-mode":        {OutputMode: 0640},
+		"output-owner":       {OutputOwner: "nobody"},
+		"preserve-mtime":     {PreserveMtime: true},
+		"header":             {Header: true},
+	} {
+		req.NotEqualf(s.T(), base, outputSettings(changed), "--%s is not in the settings", name)
+	}
+	req.NotEqual(s.T(), outputSettings(&Options{AnonymizeSalt: "salt"}), outputSettings(&Options{AnonymizeSalt: "pepper"}))
+	req.NotContains(s.T(), outputSettings(&Options{AnonymizeSalt: "pepper"}), "pepper")
+}
+
+func (s *AggregateSuite) TestPlanChunks() {
+	parts := func(sizes ...int64) []*logFile {
+		list := make([]*logFile, len(sizes))
+		for idx, size := range sizes {
+			list[idx] = &logFile{index: len(sizes) - idx, name: fmt.Sprintf("out.%d.log", len(sizes)-idx), size: size}
+		}
+		return list
+	}
+	shape := func(chunks [][]*logFile) []int {
+		var result []int
+		for _, chunk := range chunks {
+			result = append(result, len(chunk))
+		}
+		return result
+	}
+
+	for _, test := range []struct {
+		sizes     []int64
+		maxChunks int
+		expected  []int
+	}{
+		{[]int64{10, 10, 10, 10, 10, 10}, 3, []int{2, 2, 2}},
+		{[]int64{10, 10, 10, 10, 10, 10, 10}, 3, []int{2, 3, 2}},
+		{[]int64{10, 10, 10}, 3, []int{1, 1, 1}},
+		{[]int64{10, 10}, 5, []int{1, 1}},
+		{[]int64{100, 1, 1, 1, 100}, 2, []int{2, 3}},
+		{[]int64{1000, 1, 1, 1}, 2, []int{1, 3}},
+		{[]int64{0, 0, 0, 0}, 2, []int{2, 2}},
+		{[]int64{10, 10, 10}, 0, []int{3}},
+	} {
+		chunks, err := planChunks(parts(test.sizes...), test.maxChunks)
+		req.NoError(s.T(), err)
+		req.Equalf(s.T(), test.expected, shape(chunks), "Wrong chunks of %v in %d", test.sizes, test.maxChunks)
+	}
+
+	s.GenerateLog("out", 7)
+	result := MainRoutine(&Options{Input: "tempTest", MaxChunks: 3})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Len(s.T(), s.findOutputs(), 3, "Not exactly the requested number of chunks")
+}
+
+func (s *AggregateSuite) TestEstimate() {
+	s.GenerateLog("out", 5)
+	s.GenerateLog("single", 1)
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+
+	estimates := EstimateMerge(allFiles, &Options{MaxChunks: 2}, nil)
+	req.Len(s.T(), estimates, 2)
+	out := estimates[0]
+	req.Equal(s.T(), "out", out.Name)
+	req.NoError(s.T(), out.Err)
+	var chunkBytes int64
+	for _, chunk := range out.Chunks {
+		chunkBytes += chunk.Bytes
+	}
+	req.Equal(s.T(), out.Bytes, chunkBytes, "Chunks do not cover the group")
+	req.Equal(s.T(), "out.full.1.log", out.Chunks[0].Name)
+	req.Len(s.T(), estimates[1].Chunks, 1, "Single part was split")
+
+	var buf bytes.Buffer
+	req.NoError(s.T(), WriteEstimate(&buf, estimates, sampleThroughput(nil, "tempTest", allFiles, 0)))
+	req.Contains(s.T(), buf.String(), "out.full.1.log")
+	req.Contains(s.T(), buf.String(), "estimated duration")
+
+	result := MainRoutine(&Options{Input: "tempTest", Estimate: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Empty(s.T(), s.findOutputs(), "Estimate wrote outputs")
+}
+
+func (s *AggregateSuite) TestSuffix() {
+	req.Equal(s.T(), "out.full.log", chunkOutputName("out", "", 0, 1))
+	req.Equal(s.T(), "out.full.3.log", chunkOutputName("out", "", 2, 9))
+	req.Equal(s.T(), "out.merged.03.log", chunkOutputName("out", "merged", 2, 12))
+	req.Equal(s.T(), "out.merged.012.log", chunkOutputName("out", "merged", 11, 100))
+	for _, suffix := range []string{"1", "log", "a/b", ".full", "full.2"} {
+		req.Error(s.T(), validateSuffix(suffix), "Suffix %q was accepted", suffix)
+	}
+
+	s.GenerateLog("out", 12)
+	options := &Options{Input: "tempTest", MaxChunks: 12, Suffix: "merged"}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	names, err := newOutputNames(options)
+	req.NoError(s.T(), err)
+	files, err := FindAggregateOutputs("tempTest", names)
+	req.NoError(s.T(), err)
+	req.Len(s.T(), files, 12)
+	req.Equal(s.T(), "out.merged.01.log", filepath.Base(files[0]))
+	req.Equal(s.T(), "out.merged.12.log", filepath.Base(files[11]))
+	req.Empty(s.T(), s.findOutputs(), "Outputs written with the default suffix")
+
+	// the outputs are not taken for parts by a run with the same suffix
+	allFiles, err := ScanFolder("tempTest", &scanOptions{names: names})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles["out"], 12)
+}
+
+func (s *AggregateSuite) TestNameTemplate() {
+	for template, valid := range map[string]bool{
+		"{{.Base}}-{{.Date}}-merged{{.Chunk}}.log": true,
+		"{{.Host}}/{{.Base}}{{.Chunk}}.log":        false,
+		"{{.Base}}.log":                            false,
+		"{{.Base}}-merged.log":                     false,
+		"{{.Base}-merged.log":                      false,
+		"{{.Missing}}.log":                         false,
+	} {
+		_, err := newOutputNames(&Options{NameTemplate: template, MaxChunks: 2})
+		req.Equalf(s.T(), valid, err == nil, "Template %q: %v", template, err)
+	}
+
+	s.GenerateLog("out", 4)
+	options := &Options{Input: "tempTest", MaxChunks: 2, NameTemplate: "{{.Base}}-{{.Date}}-merged{{.Chunk}}.log", Index: true}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	names, err := newOutputNames(options)
+	req.NoError(s.T(), err)
+	date := time.Now().Format("2006-01-02")
+	files, err := FindAggregateOutputs("tempTest", names)
+	req.NoError(s.T(), err)
+	req.Len(s.T(), files, 2)
+	req.Equal(s.T(), "out-"+date+"-merged1.log", filepath.Base(files[0]))
+	req.FileExists(s.T(), files[1]+indexFileSuffix)
+
+	// neither the outputs nor their sidecars are taken for parts
+	allFiles, err := ScanFolder("tempTest", &scanOptions{names: names})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles, 1)
+	req.Len(s.T(), allFiles["out"], 4)
+}
+
+func (s *AggregateSuite) TestTimestampedOutput() {
+	names, err := newOutputNames(&Options{TimestampedOutput: true})
+	req.NoError(s.T(), err)
+	req.Regexp(s.T(), `^out\.full\.\d{8}T\d{6}\.2\.log$`, names.chunk("out", 1, 3))
+	_, err = newOutputNames(&Options{TimestampedOutput: true, NameTemplate: "{{.Base}}-{{.Time}}.log"})
+	req.Error(s.T(), err, "Timestamp added to a name template")
+
+	// the archive of a previous run is left alone and not merged again
+	s.GenerateLog("out", 3)
+	archive := "tempTest/out.full.20200101T000000.log"
+	req.NoError(s.T(), ioutil.WriteFile(archive, []byte("archived\n"), 0644))
+
+	result := MainRoutine(&Options{Input: "tempTest", TimestampedOutput: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	outputs := s.findOutputs()
+	req.Len(s.T(), outputs, 2)
+	data, err := ioutil.ReadFile(archive)
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "archived\n", string(data), "Archive was overwritten")
+	info, err := os.Stat(outputs[1])
+	req.NoError(s.T(), err)
+	req.NotEqual(s.T(), filepath.Base(archive), filepath.Base(outputs[1]))
+	req.Greater(s.T(), info.Size(), int64(len("archived\n")))
+}
+
+func (s *AggregateSuite) TestOutputMetadata() {
+	var mode FileMode
+	req.NoError(s.T(), mode.UnmarshalFlag("0640"))
+	req.Equal(s.T(), FileMode(0640), mode)
+	req.Error(s.T(), mode.UnmarshalFlag("0999"), "Non octal mode was accepted")
+
+	s.GenerateLog("out", 3)
+	newest := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	for idx := 1; idx <= 3; idx++ {
+		stamp := newest.Add(-time.Duration(idx) * time.Hour)
+		req.NoError(s.T(), os.Chtimes(fmt.Sprintf("tempTest/out.%d.log", idx), stamp, stamp))
+	}
+	req.NoError(s.T(), os.Chtimes("tempTest/out.2.log", newest, newest))
+
+	options := &Options{Input: "tempTest", OutputMode: 0640, PreserveMtime: true, Index: true}
+	if os.Geteuid() == 0 {
+		options.OutputOwner = fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	info, err := os.Stat("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), os.FileMode(0640), info.Mode().Perm())
+	req.True(s.T(), newest.Equal(info.ModTime()), "Output mtime is %v", info.ModTime())
+	info, err = os.Stat("tempTest/out.full.log" + indexFileSuffix)
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), os.FileMode(0640), info.Mode().Perm(), "Sidecar mode was not set")
+}
+
+func (s *AggregateSuite) TestFsync() {
+	for _, policy := range []string{fsyncAlways, fsyncEnd, fsyncNever} {
+		s.DeleteLogDir()
+		s.GenerateLog("out", 5)
+
+		result := MainRoutine(&Options{Input: "tempTest", Fsync: policy, MaxMemory: 1})
+		req.Equalf(s.T(), 0, result, "Failed check correct method result with --fsync %s", policy)
+		s.CheckLogOutput("out", 5)
+	}
+}
+
+func (s *AggregateSuite) TestPreallocate() {
+	s.GenerateLog("out", 5)
+
+	result := MainRoutine(&Options{Input: "tempTest", Preallocate: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	s.CheckLogOutput("out", 5)
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	f, err := os.Create("tempTest/huge.full.log")
+	req.NoError(s.T(), err)
+	defer f.Close()
+	err = preallocateOutput(f, []*logFile{{name: "huge.log", size: 1 << 60}})
+	req.Error(s.T(), err, "Space that cannot be there was preallocated")
+}
+
+func (s *AggregateSuite) TestWorkerPool() {
+	pool := newWorkerPool(3)
+	var running, peak int32
+	wg := &sync.WaitGroup{}
+	for idx := 0; idx < 50; idx++ {
+		pool.goWait(wg, func() {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	wg.Wait()
+	req.LessOrEqual(s.T(), peak, int32(3), "Pool ran more goroutines than its size")
+}
+
+func (s *AggregateSuite) TestManyParts() {
+	const parts = 5000
+	_ = os.Mkdir("tempTest", 0777)
+	for idx := 1; idx <= parts; idx++ {
+		name := fmt.Sprintf("tempTest/many.%d.log", idx)
+		req.NoError(s.T(), ioutil.WriteFile(name, []byte(fmt.Sprintf("[Line %d]\n", idx)), 0644))
+	}
+
+	result := MainRoutine(&Options{Input: "tempTest", Delete: true, Workers: 8, MaxMemory: 1 << 20})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	data, err := ioutil.ReadFile("tempTest/many.full.log")
+	req.NoError(s.T(), err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	req.Len(s.T(), lines, parts)
+	req.Equal(s.T(), fmt.Sprintf("[Line %d]", parts), lines[0], "Parts are not in merge order")
+	req.Equal(s.T(), 0, s.CountInputFiles("many"), "Input files were not deleted")
+}
+
+func (s *AggregateSuite) TestFollowSymlinks() {
+	req.NoError(s.T(), os.MkdirAll("tempTest/real", 0777))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/real/app.2.log", []byte("two\n"), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log.external", []byte("one\n"), 0644))
+	req.NoError(s.T(), os.Symlink("../app.1.log.external", "tempTest/real/app.1.log"))
+	req.NoError(s.T(), os.Symlink("app.2.log", "tempTest/real/app.3.log"))
+	req.NoError(s.T(), os.Symlink("loop.b.log", "tempTest/real/loop.a.log"))
+	req.NoError(s.T(), os.Symlink("loop.a.log", "tempTest/real/loop.b.log"))
+	req.NoError(s.T(), os.Symlink("real", "tempTest/link"))
+
+	var skipped []string
+	allFiles, err := ScanFolder("tempTest/link", &scanOptions{
+		followSymlinks: true,
+		onError: func(path string, err error) error {
+			skipped = append(skipped, filepath.Base(path))
+			return nil
+		},
+	})
+	req.NoError(s.T(), err)
+	req.ElementsMatch(s.T(), []string{"loop.a.log", "loop.b.log"}, skipped, "Loop was not detected")
+	req.Len(s.T(), allFiles, 1)
+	list := allFiles["app"]
+	req.Len(s.T(), list, 2, "Link to a part was merged twice")
+	for _, part := range list {
+		req.Equal(s.T(), int64(4), part.size, "Size of %s is not the one of its target", part.name)
+	}
+
+	result := MainRoutine(&Options{Input: "tempTest/link", FollowSymlinks: true, SkipErrors: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	data, err := ioutil.ReadFile("tempTest/real/app.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "two\none\n", string(data))
+}
+
+func (s *AggregateSuite) TestHiddenAndTempFiles() {
+	s.GenerateLog("out", 2)
+	for _, name := range []string{".out.3.log.swp", "out.3.log~", "out.4.log.part", "out.5.log.tmp", "#out.6.log#", ".out.7.log"} {
+		req.NoError(s.T(), ioutil.WriteFile(filepath.Join("tempTest", name), []byte("[Temp]\n"), 0644))
+	}
+
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles, 1)
+	req.Len(s.T(), allFiles["out"], 2, "Hidden or temporary files were taken as parts")
+
+	allFiles, err = ScanFolder("tempTest", &scanOptions{includeHidden: true})
+	req.NoError(s.T(), err)
+	var parts int
+	for _, list := range allFiles {
+		parts += len(list)
+	}
+	req.Equal(s.T(), 8, parts, "Hidden or temporary files were not included")
+}
+
+func (s *AggregateSuite) TestSizeAndAgeSelectors() {
+	s.GenerateLog("out", 4)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/out.5.log", []byte("[Small]\n"), 0644))
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"out.3.log", "out.4.log"} {
+		req.NoError(s.T(), os.Chtimes(filepath.Join("tempTest", name), old, old))
+	}
+
+	names := func(allFiles FilesList) []string {
+		var result []string
+		for _, part := range allFiles["out"] {
+			result = append(result, part.name)
+		}
+		return result
+	}
+	allFiles, err := ScanFolder("tempTest", &scanOptions{minSize: 1 << 10})
+	req.NoError(s.T(), err)
+	req.ElementsMatch(s.T(), []string{"out.1.log", "out.2.log", "out.3.log", "out.4.log"}, names(allFiles))
+
+	allFiles, err = ScanFolder("tempTest", &scanOptions{maxSize: 1 << 10})
+	req.NoError(s.T(), err)
+	req.ElementsMatch(s.T(), []string{"out.5.log"}, names(allFiles))
+
+	allFiles, err = ScanFolder("tempTest", &scanOptions{minAge: 24 * time.Hour})
+	req.NoError(s.T(), err)
+	req.ElementsMatch(s.T(), []string{"out.3.log", "out.4.log"}, names(allFiles))
+
+	allFiles, err = ScanFolder("tempTest", &scanOptions{maxAge: 24 * time.Hour, minSize: 1 << 10})
+	req.NoError(s.T(), err)
+	req.ElementsMatch(s.T(), []string{"out.1.log", "out.2.log"}, names(allFiles))
+
+	result := MainRoutine(&Options{Input: "tempTest", MinAge: 24 * time.Hour, Delete: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Equal(s.T(), 3, s.CountInputFiles("out"), "Only the old parts should have been merged and deleted")
+}
+
+func (s *AggregateSuite) TestDateRange() {
+	for name, expected := range map[string]string{
+		"app.log.2021-03-01":  "2021-03-01",
+		"app.20210302.log":    "2021-03-02",
+		"app.log.2021_03_03":  "2021-03-03",
+		"app.1.log":           "",
+		"app.log.20211399":    "",
+		"app.log.120210301.1": "",
+	} {
+		day, ok := filenameDate(name)
+		if expected == "" {
+			req.Falsef(s.T(), ok, "Date found in %s", name)
+			continue
+		}
+		req.Truef(s.T(), ok, "No date found in %s", name)
+		req.Equal(s.T(), expected, day.Format("2006-01-02"))
+	}
+
+	_ = os.Mkdir("tempTest", 0777)
+	for _, name := range []string{"app.log", "app.log.2021-02-28", "app.log.2021-03-01", "app.log.2021-03-02", "app.log.2021-03-03"} {
+		req.NoError(s.T(), ioutil.WriteFile(filepath.Join("tempTest", name), []byte(name+"\n"), 0644))
+	}
+	scan, err := newScanOptions(&Options{FromDate: "2021-03-01", ToDate: "2021-03-02"})
+	req.NoError(s.T(), err)
+	allFiles, err := ScanFolder("tempTest", scan)
+	req.NoError(s.T(), err)
+	var names []string
+	for _, part := range allFiles["app"] {
+		names = append(names, part.name)
+	}
+	req.ElementsMatch(s.T(), []string{"app.log.2021-03-01", "app.log.2021-03-02"}, names)
+
+	_, err = newScanOptions(&Options{FromDate: "2021-03-02", ToDate: "2021-03-01"})
+	req.Error(s.T(), err, "Reversed range was accepted")
+	_, err = newScanOptions(&Options{FromDate: "March"})
+	req.Error(s.T(), err, "Invalid date was accepted")
+}
+
+func (s *AggregateSuite) TestLogrotate() {
+	stanza, err := parseLogrotate(strings.NewReader(`
+compress
+/var/log/app/access.log /var/log/app/error.log {
+    daily
+    dateext
+    postrotate
+        kill -HUP $(cat /run/app.pid) # {
+    endscript
+    nocompress
+}
+`))
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), []string{"/var/log/app/access.log", "/var/log/app/error.log"}, stanza.Paths)
+	req.False(s.T(), stanza.Compress, "Directive of the stanza did not override the global one")
+	req.Equal(s.T(), "/var/log/app", stanza.inputDir())
+	for name, matches := range map[string]bool{
+		"access.log":          true,
+		"error.log-20210301":  true,
+		"access.log-20210301": true,
+		"access.log.bak":      false,
+		"other.log-20210301":  false,
+	} {
+		req.Equalf(s.T(), matches, stanza.partPattern().MatchString(name), "Wrong match of %s", name)
+	}
+	_, err = parseLogrotate(strings.NewReader("/var/log/app.log {\n  daily\n"))
+	req.Error(s.T(), err, "Unclosed stanza was accepted")
+
+	req.NoError(s.T(), os.MkdirAll("tempTest/old", 0777))
+	conf := "tempTest/app.conf"
+	req.NoError(s.T(), ioutil.WriteFile(conf, []byte("tempTest/app.log {\n  olddir old\n  compress\n  rotate 5\n}\n"), 0644))
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write([]byte("[Line 0]\n"))
+	req.NoError(s.T(), zw.Close())
+	for name, content := range map[string]string{
+		"app.log.1":    "[Line 2]\n",
+		"app.log.2":    "[Line 1]\n",
+		"app.log.3.gz": compressed.String(),
+		"other.log.1":  "[Other]\n",
+	} {
+		req.NoError(s.T(), ioutil.WriteFile(filepath.Join("tempTest/old", name), []byte(content), 0644))
+	}
+
+	options := &Options{Input: ".", Logrotate: flags.Filename(conf)}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	req.Equal(s.T(), flags.Filename("."), options.Input, "Options were changed by the run")
+	data, err := ioutil.ReadFile("tempTest/old/app.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "[Line 0]\n[Line 1]\n[Line 2]\n", string(data), "Compressed part was not merged")
+
+	// the parts that cannot be read in place are decompressed too
+	var bundle bytes.Buffer
+	zipWriter := zip.NewWriter(&bundle)
+	w, err := zipWriter.Create("app.log.3.gz")
+	req.NoError(s.T(), err)
+	_, _ = w.Write(compressed.Bytes())
+	req.NoError(s.T(), zipWriter.Close())
+	zipReader, err := zip.NewReader(bytes.NewReader(bundle.Bytes()), int64(bundle.Len()))
+	req.NoError(s.T(), err)
+	part, err := openPart(zipReader, "", "app.log.3.gz")
+	req.NoError(s.T(), err)
+	data, err = ioutil.ReadAll(part)
+	req.NoError(s.T(), err)
+	req.NoError(s.T(), part.Close())
+	req.Equal(s.T(), "[Line 0]\n", string(data))
+	_, err = os.Stat("tempTest/old/other.full.log")
+	req.True(s.T(), os.IsNotExist(err), "File outside the stanza was merged")
+}
+
+func (s *AggregateSuite) TestSplitOnMarker() {
+	_ = os.Mkdir("tempTest", 0777)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.2.log", []byte("a\nServer started\nb\n"), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log", []byte("c\nServer started\nd\n"), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/single.1.log", []byte("Server started\ne\n"), 0644))
+
+	result := MainRoutine(&Options{Input: "tempTest", SplitOnMarker: "Server started", MaxChunks: 2})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Conflicting options were accepted")
+
+	result = MainRoutine(&Options{Input: "tempTest", SplitOnMarker: "Server started", Index: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	for name, expected := range map[string]string{
+		"app.full.1.log":  "a\n",
+		"app.full.2.log":  "Server started\nb\nc\n",
+		"app.full.3.log":  "Server started\nd\n",
+		"single.full.log": "Server started\ne\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("tempTest", name))
+		req.NoError(s.T(), err)
+		req.Equal(s.T(), expected, string(data), "Wrong content of %s", name)
+		req.FileExists(s.T(), filepath.Join("tempTest", name)+indexFileSuffix)
+	}
+	_, err := os.Stat("tempTest/app.full.log")
+	req.True(s.T(), os.IsNotExist(err), "Unsplit output was left")
+	_, err = os.Stat("tempTest/app.full.log" + indexFileSuffix)
+	req.True(s.T(), os.IsNotExist(err), "Sidecar of the unsplit output was left")
+
+	// the header stays with the first session, the names are padded to the
+	// count of the sessions
+	var sessions strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&sessions, "Server started\n%d\n", i)
+	}
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/many.1.log", []byte(sessions.String()), 0644))
+	result = MainRoutine(&Options{Input: "tempTest", SplitOnMarker: "Server started", Header: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	first, err := ioutil.ReadFile("tempTest/many.full.01.log")
+	req.NoError(s.T(), err)
+	req.True(s.T(), strings.HasPrefix(string(first), "# aggregatelogs "), "Header missing from the first session")
+	req.True(s.T(), strings.HasSuffix(string(first), "\nServer started\n0\n"))
+	last, err := ioutil.ReadFile("tempTest/many.full.10.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "Server started\n9\n", string(last))
+	for _, name := range []string{"many.full.log", "many.full.1.log", "many.full.2.log"} {
+		_, err = os.Stat(filepath.Join("tempTest", name))
+		req.True(s.T(), os.IsNotExist(err), "Output %s was left", name)
+	}
+
+	result = MainRoutine(&Options{Input: "tempTest", SplitOnMarker: "Server started", IfExists: ifExistsAppend})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Appending to the sessions was accepted")
+}
+
+func (s *AggregateSuite) TestRecordDelimiter() {
+	var delim Delimiter
+	req.Equal(s.T(), byte('\n'), delim.value())
+	req.Error(s.T(), delim.UnmarshalFlag("ab"), "Delimiter of two characters was accepted")
+	req.NoError(s.T(), delim.UnmarshalFlag(`\0`))
+	req.Equal(s.T(), byte(0), delim.value())
+	req.Equal(s.T(), `\0`, delim.String())
+
+	// the records span several lines, only the delimiter ends them
+	_ = os.Mkdir("tempTest", 0777)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.2.log", []byte("start\none\x00keep\na\x00drop\nb\x00"), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log", []byte("start\ntwo\x00keep c\x00"), 0644))
+
+	result := MainRoutine(&Options{Input: "tempTest", RecordDelimiter: delim, Contains: []string{"start", "keep"}, SplitOnMarker: "start"})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	for name, expected := range map[string]string{
+		"app.full.1.log": "start\none\x00keep\na\x00",
+		"app.full.2.log": "start\ntwo\x00keep c\x00",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("tempTest", name))
+		req.NoError(s.T(), err)
+		req.Equal(s.T(), expected, string(data), "Wrong content of %s", name)
+	}
+}
+
+func (s *AggregateSuite) TestSplitAggregate() {
+	s.GenerateTimedLog("out", 3)
+	result := MainRoutine(&Options{Input: "tempTest"})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	aggregate := "tempTest/out.full.log"
+	original, err := ioutil.ReadFile(aggregate)
+	req.NoError(s.T(), err)
+
+	_, err = SplitAggregate(aggregate, "", splitLimits{size: 1 << 10, lines: 10})
+	req.Error(s.T(), err, "Two limits were accepted")
+
+	join := func(pieces []string) []byte {
+		var buf bytes.Buffer
+		for _, piece := range pieces {
+			data, err := ioutil.ReadFile(piece)
+			req.NoError(s.T(), err)
+			buf.Write(data)
+			req.NoError(s.T(), os.Remove(piece))
+		}
+		return buf.Bytes()
+	}
+
+	pieces, err := SplitAggregate(aggregate, "", splitLimits{lines: 5000})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), pieces, 3)
+	req.Equal(s.T(), "out.full.log.001", filepath.Base(pieces[0]))
+	req.Equal(s.T(), original, join(pieces))
+
+	pieces, err = SplitAggregate(aggregate, "", splitLimits{size: 64 << 10})
+	req.NoError(s.T(), err)
+	for _, piece := range pieces[:len(pieces)-1] {
+		info, err := os.Stat(piece)
+		req.NoError(s.T(), err)
+		req.LessOrEqual(s.T(), info.Size(), int64(64<<10))
+	}
+	req.Equal(s.T(), original, join(pieces))
+
+	// 12000 lines one second apart
+	pieces, err = SplitAggregate(aggregate, "", splitLimits{window: time.Hour})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), pieces, 4)
+	req.Equal(s.T(), original, join(pieces))
+
+	// the header written by --header stays with the first piece
+	result = MainRoutine(&Options{Input: "tempTest", Header: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	withHeader, err := ioutil.ReadFile(aggregate)
+	req.NoError(s.T(), err)
+	pieces, err = SplitAggregate(aggregate, "", splitLimits{window: time.Hour})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), pieces, 4)
+	first, err := ioutil.ReadFile(pieces[0])
+	req.NoError(s.T(), err)
+	req.True(s.T(), bytes.HasPrefix(first, []byte("# aggregatelogs ")))
+	req.Equal(s.T(), withHeader, join(pieces))
+	req.NoError(s.T(), os.Remove(aggregate))
+	result = MainRoutine(&Options{Input: "tempTest"})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	// the pieces are not taken for parts
+	_, err = SplitAggregate(aggregate, "", splitLimits{lines: 5000})
+	req.NoError(s.T(), err)
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles["out"], 3)
+}
+
+func (s *AggregateSuite) TestDiffAggregates() {
+	s.GenerateTimedLog("out", 3)
+	options := &Options{Input: "tempTest"}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	aggregate := "tempTest/out.full.log"
+
+	parts, err := diffPartSources(options, aggregate)
+	req.NoError(s.T(), err)
+	req.Len(s.T(), parts, 3)
+	report, err := DiffSources(parts, []diffSource{{name: "out.full.log", path: aggregate}}, 10)
+	req.NoError(s.T(), err)
+	req.Zero(s.T(), report.Missing+report.Extra, "The aggregate differs from its parts")
+
+	// the second line changed, the third dropped and one line added at the end
+	data, err := ioutil.ReadFile(aggregate)
+	req.NoError(s.T(), err)
+	lines := strings.SplitAfter(string(data), "\n")
+	lines[1] = "changed line\n"
+	lines = append(lines[:2], lines[3:]...)
+	lines = append(lines, "added line\n")
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/other.log", []byte(strings.Join(lines, "")), 0644))
+
+	report, err = DiffSources([]diffSource{{name: "out.full.log", path: aggregate}},
+		[]diffSource{{name: "other.log", path: "tempTest/other.log"}}, 10)
+	req.NoError(s.T(), err)
+	req.EqualValues(s.T(), 2, report.Missing)
+	req.EqualValues(s.T(), 2, report.Extra)
+	req.EqualValues(s.T(), 1, report.Changed)
+	req.Len(s.T(), report.Entries, 3)
+	req.Equal(s.T(), byte('~'), report.Entries[0].Kind)
+	req.Equal(s.T(), "out.full.log:2", report.Entries[0].Where)
+	req.Equal(s.T(), "other.log:2", report.Entries[0].OtherWhere)
+	req.Equal(s.T(), "changed line", report.Entries[0].OtherText)
+	req.Equal(s.T(), byte('-'), report.Entries[1].Kind)
+	req.Equal(s.T(), "out.full.log:3", report.Entries[1].Where)
+	req.Equal(s.T(), byte('+'), report.Entries[2].Kind)
+	req.Equal(s.T(), "added line", report.Entries[2].Text)
+
+	var buf bytes.Buffer
+	req.NoError(s.T(), report.Write(&buf))
+	req.Contains(s.T(), buf.String(), "2 missing, 2 extra, 1 changed")
+}
+
+func (s *AggregateSuite) TestCombineGroups() {
+	for _, values := range [][]string{{"api,worker"}, {"api=>"}, {"=>platform"}, {"api=>plat.form"}, {"api=>a", "api,worker=>b"}} {
+		_, err := parseCombineSpecs(values)
+		req.Errorf(s.T(), err, "Wrong --combine %v was accepted", values)
+	}
+
+	_ = os.Mkdir("tempTest", 0777)
+	start := time.Now().Add(-time.Hour)
+	// the parts of worker are interleaved with the ones of api by mtime
+	for idx, name := range []string{"api.2.log", "worker.2.log", "api.1.log", "worker.1.log"} {
+		path := filepath.Join("tempTest", name)
+		req.NoError(s.T(), ioutil.WriteFile(path, []byte(name+"\n"), 0644))
+		mtime := start.Add(time.Duration(idx) * time.Minute)
+		req.NoError(s.T(), os.Chtimes(path, mtime, mtime))
+	}
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/other.1.log", []byte("other\n"), 0644))
+
+	result := MainRoutine(&Options{Input: "tempTest", Combine: []string{"api, worker=>platform"}})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	data, err := ioutil.ReadFile("tempTest/platform.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "api.2.log\nworker.2.log\napi.1.log\nworker.1.log\n", string(data))
+	for _, name := range []string{"api.full.log", "worker.full.log"} {
+		_, err = os.Stat(filepath.Join("tempTest", name))
+		req.Truef(s.T(), os.IsNotExist(err), "Combined group %s was merged on its own", name)
+	}
+	_, err = os.Stat("tempTest/other.full.log")
+	req.NoError(s.T(), err, "Other group was not merged")
+
+	result = MainRoutine(&Options{Input: "tempTest", Combine: []string{"api=>other"}})
+	req.Equalf(s.T(), 1, result, "Target clashing with another group was accepted")
+}
+
+func (s *AggregateSuite) TestOutputHeader() {
+	s.GenerateLog("out", 2)
+	options := &Options{Input: "tempTest", Header: true, Contains: []string{"[Line 1"}}
+	result := MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	data, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	req.True(s.T(), strings.HasPrefix(lines[0], "# aggregatelogs "), "Output does not start with the header")
+	header := 0
+	for header < len(lines) && strings.HasPrefix(lines[header], headerPrefix) {
+		header++
+	}
+	req.Contains(s.T(), lines[:header], "# Contains: [[Line 1]")
+	req.Contains(s.T(), lines[:header], "# Parts: 2")
+	req.Equal(s.T(), []string{"# Part: out.2.log", "# Part: out.1.log"}, lines[header-2:header])
+	req.Equal(s.T(), "[Line 1]", lines[header])
+
+	// appending to the output adds no second header
+	options.IfExists = "append"
+	result = MainRoutine(options)
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	data, err = ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), 1, strings.Count(string(data), "# aggregatelogs "))
+}
+
+func (s *AggregateSuite) TestExtractWithHeader() {
+	s.GenerateTimedLog("out", 2)
+	result := MainRoutine(&Options{Input: "tempTest", Header: true, TimeIndex: true})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	// the merge time in the header is not the time of a line
+	var out bytes.Buffer
+	since := TimedLogStart.Add(10 * time.Second)
+	req.NoError(s.T(), ExtractTimeRange("tempTest/out.full.log", since, since.Add(6*time.Second), &out))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	req.Len(s.T(), lines, 6)
+	req.Contains(s.T(), lines[0], "[Line 10]")
+
+	// the header is left out of the sidecars parsing the lines
+	header := outputHeader(newOutputHeader(&Options{}, time.Now()), []*logFile{{name: "out.1.log"}})
+	size, err := headerSize(bytes.NewReader(append(header, "# 2021-03-01T00:00:00Z a line\n"...)))
+	req.NoError(s.T(), err)
+	req.EqualValues(s.T(), len(header), size)
+	histogram := newHistogramWriter(time.Hour, "text")
+	histogram.skipHeader(size)
+	_, _ = histogram.Write(append(header, "2021-03-01T00:00:00Z a line\n"...))
+	req.Len(s.T(), histogram.buckets(), 1, "Header line counted in the histogram")
+
+	// the log lines written like the header lines keep their timestamp
+	size, err = headerSize(strings.NewReader("# 2021-03-01T00:00:00Z started\n# aggregatelogs\n"))
+	req.NoError(s.T(), err)
+	req.Zero(s.T(), size, "Log lines taken for a header")
+	ts, ok := ParseTimestamp("# 2021-03-01T00:00:00Z started")
+	req.True(s.T(), ok, "Commented log line lost its timestamp")
+	req.True(s.T(), TimedLogStart.Equal(ts))
+}
+
+func (s *AggregateSuite) TestScanFS() {
+	fsys := fstest.MapFS{
+		"app.2.log":     {Data: []byte("first\n")},
+		"app.1.log":     {Data: []byte("second\n")},
+		"app.full.log":  {Data: []byte("merged\n")},
+		".app.3.log":    {Data: []byte("hidden\n")},
+		"sub/app.4.log": {Data: []byte("nested\n")},
+		"notes.txt":     {Data: []byte("not a part\n")},
+	}
+	allFiles, err := ScanFS(fsys, &scanOptions{})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles, 1)
+	list := allFiles["app"]
+	SortLogList(list, false)
+	req.Len(s.T(), list, 2)
+	req.Equal(s.T(), "app.2.log", list[0].name)
+	req.Equal(s.T(), 2, list[0].index)
+	req.EqualValues(s.T(), 6, list[0].size)
+
+	data, err := readPart(fsys, "", "app.1.log", list[1].size, nil, time.Time{})
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "second\n", string(data))
+
+	// a resumed stream starts after the bytes already written
+	var buf bytes.Buffer
+	n, err := streamPartFrom(&buf, fsys, "", "app.1.log", 3, defaultReadBuffer, nil, time.Time{})
+	req.NoError(s.T(), err)
+	req.EqualValues(s.T(), 4, n)
+	req.Equal(s.T(), "ond\n", buf.String())
+}
+
+func (s *AggregateSuite) TestArchiveInput() {
+	_ = os.Mkdir("tempTest", 0777)
+	parts := map[string]string{"app.2.log": "first\n", "app.1.log": "second\n", "app.full.log": "old output\n"}
+
+	f, err := os.Create("tempTest/bundle.zip")
+	req.NoError(s.T(), err)
+	zw := zip.NewWriter(f)
+	for name, content := range parts {
+		w, err := zw.Create("bundle/" + name)
+		req.NoError(s.T(), err)
+		_, _ = w.Write([]byte(content))
+	}
+	req.NoError(s.T(), zw.Close())
+	req.NoError(s.T(), f.Close())
+
+	f, err = os.Create("tempTest/bundle.tar.gz")
+	req.NoError(s.T(), err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range parts {
+		req.NoError(s.T(), tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}))
+		_, _ = tw.Write([]byte(content))
+	}
+	req.NoError(s.T(), tw.WriteHeader(&tar.Header{Name: "../escape.1.log", Mode: 0644, Size: 1, ModTime: time.Now()}))
+	_, _ = tw.Write([]byte("x"))
+	req.NoError(s.T(), tw.Close())
+	req.NoError(s.T(), gz.Close())
+	req.NoError(s.T(), f.Close())
+
+	result := MainRoutine(&Options{Input: "tempTest/bundle.zip", Delete: true})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Deleting the parts of an archive was accepted")
+
+	for _, archive := range []string{"tempTest/bundle.zip", "tempTest/bundle.tar.gz"} {
+		req.NoError(s.T(), os.RemoveAll("tempTest/app.full.log"))
+		result = MainRoutine(&Options{Input: flags.Filename(archive)})
+		req.Equalf(s.T(), 0, result, "Failed check correct method result for %s", archive)
+		data, err := ioutil.ReadFile("tempTest/app.full.log")
+		req.NoError(s.T(), err)
+		req.Equal(s.T(), "first\nsecond\n", string(data), "Wrong merge of %s", archive)
+
+		var buf bytes.Buffer
+		req.NoError(s.T(), CatLogs(&buf, &Options{Input: flags.Filename(archive)}, "app"))
+		req.Equal(s.T(), "first\nsecond\n", buf.String(), "Wrong cat of %s", archive)
+
+		// the subcommands read the parts from the archive as well
+		scan, err := newScanOptions(&Options{Input: flags.Filename(archive)})
+		req.NoError(s.T(), err)
+		input, basepath, release, err := openInput(archive)
+		req.NoError(s.T(), err)
+		req.Equal(s.T(), "tempTest", basepath)
+		names, err := tailParts(input, basepath, scan, "app")
+		req.NoError(s.T(), err)
+		buf.Reset()
+		req.NoError(s.T(), TailLines(&buf, input, basepath, names, 1))
+		req.Equal(s.T(), "second\n", buf.String(), "Wrong tail of %s", archive)
+
+		allFiles, err := scanInput(input, basepath, scan)
+		req.NoError(s.T(), err)
+		stats, err := CollectStats(input, basepath, allFiles, false, defaultSeverityDetector)
+		req.NoError(s.T(), err)
+		req.Len(s.T(), stats, 1)
+		req.Equal(s.T(), int64(2), stats[0].Lines, "Wrong stats of %s", archive)
+
+		var matches []SearchMatch
+		req.NoError(s.T(), SearchArchiveParts(input, archive, names, "sec", '\n', func(match SearchMatch) {
+			matches = append(matches, match)
+		}))
+		req.Equal(s.T(), []SearchMatch{{File: filepath.Join(archive, "app.1.log"), Line: 1, Text: "second"}}, matches)
+		release()
+	}
+	_, err = os.Stat("escape.1.log")
+	req.True(s.T(), os.IsNotExist(err), "Entry outside of the archive was extracted")
+}
+
+func (s *AggregateSuite) TestValidateOptions() {
+	var conflict *ConflictError
+	err := (&Options{SkipErrors: true, Strict: true}).Validate()
+	req.True(s.T(), errors.As(err, &conflict), "Conflict not reported as such: %v", err)
+	req.Equal(s.T(), "--skip-errors and --strict cannot be used together", err.Error())
+
+	var invalid *OptionError
+	err = (&Options{Combine: []string{"api"}}).Validate()
+	req.True(s.T(), errors.As(err, &invalid), "Invalid value not reported as such: %v", err)
+	req.Equal(s.T(), "combine", invalid.Option)
+	err = (&Options{FromDate: "2021-03-02", ToDate: "2021-03-01"}).Validate()
+	req.True(s.T(), errors.As(err, &invalid), "Invalid value not reported as such: %v", err)
+	req.Equal(s.T(), "to-date", invalid.Option)
+
+	// the options are only read, a run leaves them as they were
+	s.GenerateLog("out", 3)
+	options := &Options{Input: "tempTest", MaxChunks: 2, Combine: []string{"out=>all"}}
+	req.NoError(s.T(), options.Validate())
+	before := *options
+	req.Equal(s.T(), 0, MainRoutine(options))
+	req.Equal(s.T(), before, *options)
+}
+
+func (s *AggregateSuite) TestMergeOrder() {
+	list := []*logFile{{index: 1, name: "app.1.log"}, {index: 0, name: "app.log"}, {index: 2, name: "app.2.log"}, {index: 1, name: "app.0001.log"}}
+	names := func() []string {
+		var result []string
+		for _, part := range list {
+			result = append(result, part.name)
+		}
+		return result
+	}
+	SortLogList(list, false)
+	req.Equal(s.T(), []string{"app.2.log", "app.0001.log", "app.1.log", "app.log"}, names())
+	SortLogList(list, true)
+	req.Equal(s.T(), []string{"app.log", "app.1.log", "app.0001.log", "app.2.log"}, names())
+
+	s.GenerateLog("out", 3)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/out.log", []byte("[Live]\n"), 0644))
+	for _, test := range []struct {
+		options     Options
+		first, last string
+	}{
+		{Options{Order: orderAsc}, "[Line 0]", "[Live]"},
+		{Options{Order: orderDesc}, "[Live]", "[Line 3999]"},
+		{Options{Reverse: true}, "[Live]", "[Line 3999]"},
+		{Options{Reverse: true, Order: orderDesc}, "[Live]", "[Line 3999]"},
+	} {
+		options := test.options
+		options.Input = "tempTest"
+		req.Equal(s.T(), 0, MainRoutine(&options))
+		data, err := ioutil.ReadFile("tempTest/out.full.log")
+		req.NoError(s.T(), err)
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		req.Equal(s.T(), test.first, lines[0], "Wrong first line with %+v", test.options)
+		req.Equal(s.T(), test.last, lines[len(lines)-1], "Wrong last line with %+v", test.options)
+	}
+
+	result := MainRoutine(&Options{Input: "tempTest", Reverse: true, Order: orderAsc})
+	req.Equalf(s.T(), exitInvalidOptions, result, "Contradicting order was accepted")
+}
+
+func (s *AggregateSuite) TestWindowsPaths() {
+	req.Equal(s.T(), `\\?\C:\inetpub\logs`, extendedPath(`C:\inetpub\logs`))
+	req.Equal(s.T(), `\\?\UNC\server\share\logs`, extendedPath(`\\server\share\logs`))
+	req.Equal(s.T(), `\\?\C:\logs`, extendedPath(`\\?\C:\logs`))
+	req.Equal(s.T(), `\\.\pipe\logs`, extendedPath(`\\.\pipe\logs`))
+
+	// IIS and the Windows services write upper case extensions
+	s.GenerateLog("out", 2)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/W3SVC.LOG", []byte("[Line 0]\n"), 0644))
+	allFiles, err := ScanFolder("tempTest", &scanOptions{})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles["W3SVC"], 1, "Upper case extension was not matched")
+	req.Len(s.T(), allFiles["out"], 2)
+}
+
+func (s *AggregateSuite) TestUnicodeNames() {
+	req.Equal(s.T(), "caf\u00e9", groupName("cafe\u0301.1.log"))
+	req.Equal(s.T(), "nh\u1eadt", groupName("nha\u0323\u0302t.log"))
+	req.Equal(s.T(), "app", groupName("app.1.log"))
+	// a mark without a precomposed letter is left as it is
+	req.Equal(s.T(), "q\u0301", groupName("q\u0301.log"))
+	// the other scripts are composed as well
+	req.Equal(s.T(), "\u0436\u0443\u0440\u043d\u0430\u043b\u0438\u0439", groupName("\u0436\u0443\u0440\u043d\u0430\u043b\u0438\u0438\u0306.log"))
+	req.Equal(s.T(), "\u03ad\u03be\u03bf\u03b4\u03bf\u03c2", groupName("\u03b5\u0301\u03be\u03bf\u03b4\u03bf\u03c2.log"))
+	req.Equal(s.T(), "\ud55c", groupName("\u1112\u1161\u11ab.log"))
+
+	// the same name written by macOS and by Linux
+	s.GenerateLog("out", 1)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/cafe\u0301.1.log", []byte("macOS\n"), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/caf\u00e9.2.log", []byte("Linux\n"), 0644))
+	allFiles, err := ScanFolder("tempTest", &scanOptions{})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles["caf\u00e9"], 2, "Parts were split by the encoding of their names")
+
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/\u0441\u0435\u0440\u0432\u0435\u0440\u0438\u0306.1.log", []byte("macOS\n"), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/\u0441\u0435\u0440\u0432\u0435\u0440\u0439.2.log", []byte("Linux\n"), 0644))
+	allFiles, err = ScanFolder("tempTest", &scanOptions{})
+	req.NoError(s.T(), err)
+	req.Len(s.T(), allFiles["\u0441\u0435\u0440\u0432\u0435\u0440\u0439"], 2, "Cyrillic parts were split by the encoding of their names")
+
+	req.Equal(s.T(), 0, MainRoutine(&Options{Input: "tempTest"}))
+	data, err := ioutil.ReadFile("tempTest/caf\u00e9.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), "Linux\nmacOS\n", string(data))
+}
+
+func (s *AggregateSuite) TestUntilQuiet() {
+	s.GenerateLog("out", 2)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/out.log", []byte("[Live 0]\n"), 0644))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for idx := 1; idx <= 3; idx++ {
+			time.Sleep(50 * time.Millisecond)
+			f, err := os.OpenFile("tempTest/out.log", os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return
+			}
+			_, _ = fmt.Fprintf(f, "[Live %d]\n", idx)
+			_ = f.Close()
+		}
+	}()
+
+	result := MainRoutine(&Options{Input: "tempTest", UntilQuiet: 300 * time.Millisecond})
+	<-done
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+	data, err := ioutil.ReadFile("tempTest/out.full.log")
+	req.NoError(s.T(), err)
+	req.True(s.T(), strings.HasSuffix(string(data), "[Live 3]\n"), "Merge did not wait for the live part")
+
+	// a rotation ends the wait
+	allFiles, err := ScanFolder("tempTest", &scanOptions{})
+	req.NoError(s.T(), err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = os.Rename("tempTest/out.log", "tempTest/out.0.log")
+		_ = ioutil.WriteFile("tempTest/out.log", nil, 0644)
+	}()
+	start := time.Now()
+	req.True(s.T(), waitUntilQuiet("tempTest", allFiles, time.Minute, 0), "Rotation was not detected")
+	req.Less(s.T(), int64(time.Since(start)), int64(10*time.Second))
+
+	// a part that never stops growing is waited for up to the maximum
+	allFiles, err = ScanFolder("tempTest", &scanOptions{})
+	req.NoError(s.T(), err)
+	stop := make(chan struct{})
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for idx := 0; ; idx++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			f, err := os.OpenFile("tempTest/out.log", os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return
+			}
+			_, _ = fmt.Fprintf(f, "[Live %d]\n", idx)
+			_ = f.Close()
+		}
+	}()
+	start = time.Now()
+	req.False(s.T(), waitUntilQuiet("tempTest", allFiles, time.Second, 300*time.Millisecond), "Growing part taken for rotated")
+	close(stop)
+	<-done
+	req.Less(s.T(), int64(time.Since(start)), int64(time.Second))
+}
+
+func (s *AggregateSuite) TestExitCodes() {
+	req.NoError(s.T(), os.MkdirAll("tempTest/empty", 0755))
+	result := MainRoutine(&Options{Input: "tempTest/empty"})
+	req.Equalf(s.T(), exitNoFilesFound, result, "Empty input not reported")
+
+	for _, options := range []*Options{
+		{Input: "tempTest", NameTemplate: "{{.Base"},
+		{Input: "tempTest", NameTemplate: "{{.Base}}", MaxChunks: 2},
+		{Input: "tempTest", NameTemplate: "{{.Base}}", TimestampedOutput: true},
+		{Input: "tempTest", Suffix: "2"},
+		{Input: "tempTest", Logrotate: "tempTest/missing.conf"},
+		{Input: "tempTest", FromDate: "yesterday"},
+		{Input: "tempTest", Combine: []string{"api"}},
+		{Input: "tempTest", Tag: []string{"host"}},
+	} {
+		result = MainRoutine(options)
+		req.Equalf(s.T(), exitInvalidOptions, result, "Invalid options not reported: %v", options)
+	}
+
+	req.Equal(s.T(), exitOK, exitCode(nil))
+	req.Equal(s.T(), exitFailure, exitCode(errors.New("disk full")))
+	req.Equal(s.T(), exitInvalidOptions, exitCode(&ConflictError{"strict", "skip-errors"}))
+	req.Equal(s.T(), exitInvalidOptions, exitCode(&OptionError{"tag", errors.New("bad tag")}))
+	req.Equal(s.T(), exitNoFilesFound, exitCode(fmt.Errorf("scan: %w", ErrNoFilesFound)))
+	req.Equal(s.T(), exitOutputExists, exitCode(fmt.Errorf("out.log: %w", ErrOutputExists)))
+
+	var unreadable *PartUnreadableError
+	err := error(&PartUnreadableError{File: "out.1.log", Err: os.ErrPermission})
+	req.Equal(s.T(), exitPartUnreadable, exitCode(err))
+	req.True(s.T(), errors.As(err, &unreadable))
+	req.Equal(s.T(), "out.1.log", unreadable.File)
+	req.True(s.T(), errors.Is(err, os.ErrPermission))
+}
+
+func (s *AggregateSuite) findOutputs() []string {
+	files, _ := FindAggregateOutputs("tempTest", nil)
+	return files
+}
+
 func (s *AggregateSuite) TestNoData() {
 	result := MainRoutine(&Options{
 		Input: "tempTest",
@@ -94,6 +2046,38 @@ func (s *AggregateSuite) TestNoData() {
 	req.Equalf(s.T(), 1, result, "Failed check correct method result")
 }
 
+func (s *AggregateSuite) TestLoggingConfiguration() {
+	defer func() {
+		log.SetFormatter(&log.TextFormatter{})
+		log.SetLevel(log.InfoLevel)
+	}()
+
+	err := ConfigureLogging(&Options{LogFormat: "json", LogLevel: "debug"})
+	req.NoError(s.T(), err)
+	req.IsType(s.T(), &log.JSONFormatter{}, log.StandardLogger().Formatter)
+	req.Equal(s.T(), log.DebugLevel, log.GetLevel())
+
+	err = ConfigureLogging(&Options{LogFormat: "text", LogLevel: "debug", Quiet: true})
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), log.ErrorLevel, log.GetLevel(), "Quiet did not override the log level")
+
+	err = ConfigureLogging(&Options{Quiet: true, Verbose: true})
+	req.Error(s.T(), err, "Quiet and verbose were accepted together")
+
+	err = ConfigureLogging(&Options{LogFormat: "xml"})
+	req.Error(s.T(), err, "Unknown log format was accepted")
+
+	err = ConfigureLogging(&Options{LogFormat: "text", LogLevel: "loud"})
+	req.Error(s.T(), err, "Unknown log level was accepted")
+}
+
+func (s *AggregateSuite) TestVersion() {
+	var buf bytes.Buffer
+	req.NoError(s.T(), WriteVersion(&buf))
+	req.Contains(s.T(), buf.String(), "aggregatelogs "+version, "Version output is missing the version")
+	req.Contains(s.T(), buf.String(), "commit "+commit, "Version output is missing the commit")
+}
+
 // --- Test Utils --- //
 type BaseSuite struct {
 	suite.Suite
@@ -121,6 +2105,26 @@ func (b *BaseSuite) GenerateLog(basename string, maxChunks int) {
 	}
 }
 
+// GenerateTimedLog writes parts whose lines carry a timestamp one second
+// apart starting from TimedLogStart, line N of the merge is at second N
+func (b *BaseSuite) GenerateTimedLog(basename string, maxChunks int) {
+	if maxChunks < 1 {
+		maxChunks = 1
+	}
+	_ = os.Mkdir("tempTest", 0777)
+
+	var lines = LinesPerChunk
+	for k := 0; k < maxChunks; k++ {
+		f, _ := os.Create(fmt.Sprintf("tempTest/%s.%d.log", basename, maxChunks-k))
+		for index := 0; index < lines; index++ {
+			line := lines*k + index
+			ts := TimedLogStart.Add(time.Duration(line) * time.Second)
+			_, _ = f.WriteString(fmt.Sprintf("%s [Line %d] some padding text\n", ts.Format(time.RFC3339), line))
+		}
+		_ = f.Close()
+	}
+}
+
 func (b *BaseSuite) CheckLogOutput(basename string, maxChunks int) {
 	if maxChunks < 1 {
 		maxChunks = 1
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+)
+
+var (
+	ipv4Pattern = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+	ipv6Pattern = regexp.MustCompile(`[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}(?:\.\d{1,3}){0,3}`)
+	// ipv6Mask is the prefix of the IPv6 addresses kept when zeroing
+	ipv6Mask = net.CIDRMask(48, 128)
+)
+
+// ipAnonymizer rewrites the IPv4 and IPv6 addresses of the lines. Without a
+// salt the last octet of IPv4 addresses and all but the /48 prefix of IPv6
+// ones are zeroed, with a salt the addresses are replaced by a keyed hash so
+// that they can still be correlated but not recovered. An address split
+// between two pieces of a line longer than transformLineLimit is left as is.
+type ipAnonymizer struct {
+	salt []byte
+}
+
+func newIPAnonymizer(salt string) *ipAnonymizer {
+	a := &ipAnonymizer{}
+	if salt != "" {
+		a.salt = []byte(salt)
+	}
+	return a
+}
+
+func (a *ipAnonymizer) anonymize(line []byte) []byte {
+	line = a.replaceAll(line, ipv4Pattern)
+	if bytes.Count(line, []byte{':'}) >= 2 {
+		line = a.replaceAll(line, ipv6Pattern)
+	}
+	return line
+}
+
+// replaceAll replaces the matches of pattern that are not part of a longer
+// word, so that identifiers like Class::method are left alone
+func (a *ipAnonymizer) replaceAll(line []byte, pattern *regexp.Regexp) []byte {
+	matches := pattern.FindAllIndex(line, -1)
+	if matches == nil {
+		return line
+	}
+	result := make([]byte, 0, len(line))
+	last := 0
+	for _, match := range matches {
+		if (match[0] > 0 && isWordByte(line[match[0]-1])) || (match[1] < len(line) && isWordByte(line[match[1]])) {
+			continue
+		}
+		result = append(result, line[last:match[0]]...)
+		result = append(result, a.replace(line[match[0]:match[1]])...)
+		last = match[1]
+	}
+	return append(result, line[last:]...)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// replace returns the anonymized form of the address, text that does not
+// parse as an address, or the unspecified one, is returned unchanged
+func (a *ipAnonymizer) replace(text []byte) []byte {
+	ip := net.ParseIP(string(text))
+	if ip == nil || ip.IsUnspecified() {
+		return text
+	}
+	if a.salt != nil {
+		mac := hmac.New(sha256.New, a.salt)
+		_, _ = mac.Write(ip)
+		return []byte("ip-" + hex.EncodeToString(mac.Sum(nil)[:8]))
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip4 = append(net.IP(nil), ip4...)
+		ip4[3] = 0
+		return []byte(ip4.String())
+	}
+	return []byte(ip.Mask(ipv6Mask).String())
+}
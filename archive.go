@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// isArchive tells whether the input path names a support bundle whose
+// content is merged instead of a directory
+func isArchive(input string) bool {
+	if info, err := os.Stat(input); err != nil || info.IsDir() {
+		return false
+	}
+	name := strings.ToLower(input)
+	for _, suffix := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// openArchive returns the content of the zip or tar archive as the input
+// tree and the function releasing it. The zip archives are read in place,
+// the tar ones, which cannot be read at random, are extracted to a
+// temporary directory. An archive holding a single directory is entered.
+func openArchive(archive string) (fs.FS, func(), error) {
+	var fsys fs.FS
+	var release func()
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		reader, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, nil, err
+		}
+		fsys, release = reader, func() { _ = reader.Close() }
+	} else {
+		dir, err := ioutil.TempDir("", "aggregatelogs-")
+		if err != nil {
+			return nil, nil, err
+		}
+		release = func() { _ = os.RemoveAll(dir) }
+		if err := extractTar(archive, dir); err != nil {
+			release()
+			return nil, nil, fmt.Errorf("extracting %s: %v", archive, err)
+		}
+		fsys = os.DirFS(dir)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		if fsys, err = fs.Sub(fsys, entries[0].Name()); err != nil {
+			release()
+			return nil, nil, err
+		}
+	}
+	return fsys, release, nil
+}
+
+// extractTar writes the regular files of the tar archive, gzipped or not,
+// to dir keeping their modification time. The entries leaving dir are
+// skipped.
+func extractTar(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if name := strings.ToLower(archive); strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if !fs.ValidPath(name) {
+			log.Warnf("Skipping %s of %s, it is outside of the archive\n", header.Name, filepath.Base(archive))
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, reader)
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type BenchCommand struct {
+	Size       ByteSize `long:"size" description:"Total size of the synthetic parts" default:"100MB"`
+	Parts      int      `long:"parts" description:"Number of synthetic parts" default:"10"`
+	LineLength int      `long:"line-length" description:"Length of each synthetic line" default:"120"`
+	Keep       bool     `long:"keep" description:"Keep the generated data and outputs"`
+
+	options *Options
+}
+
+func (c *BenchCommand) Execute(args []string) error {
+	if err := ConfigureLogging(c.options); err != nil {
+		return err
+	}
+	stopProfiling, err := StartProfiling(c.options)
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	dir, err := ioutil.TempDir("", "aggregatelogs-bench")
+	if err != nil {
+		return err
+	}
+	if !c.Keep {
+		defer os.RemoveAll(dir)
+	}
+
+	total, err := GenerateBenchData(dir, "bench", int64(c.Size), c.Parts, c.LineLength)
+	if err != nil {
+		return err
+	}
+
+	options := benchOptions(c.options, dir)
+	start := time.Now()
+	if MainRoutine(&options) != 0 {
+		return fmt.Errorf("benchmark merge failed")
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("Merged %d parts, %s in %v (%s/s)\n", c.Parts, formatBytes(total), elapsed,
+		formatBytes(int64(float64(total)/elapsed.Seconds())))
+	if c.Keep {
+		fmt.Printf("Data kept in %s\n", dir)
+	}
+	return nil
+}
+
+// benchOptions returns the options of a merge of the synthetic parts in dir
+// with the settings of the user that change how fast the parts are merged.
+// The others are left out: they select or skip parts, act outside of dir,
+// wait for input or do not merge at all.
+func benchOptions(options *Options, dir string) Options {
+	return Options{
+		Input:           flags.Filename(dir),
+		Reverse:         options.Reverse,
+		Order:           options.Order,
+		MaxChunks:       options.MaxChunks,
+		Parallel:        options.Parallel,
+		Workers:         options.Workers,
+		MaxMemory:       options.MaxMemory,
+		WriteBuffer:     options.WriteBuffer,
+		ReadBuffer:      options.ReadBuffer,
+		BwLimit:         options.BwLimit,
+		Index:           options.Index,
+		TimeIndex:       options.TimeIndex,
+		Histogram:       options.Histogram,
+		HistogramFormat: options.HistogramFormat,
+		ClusterErrors:   options.ClusterErrors,
+		ClusterTop:      options.ClusterTop,
+		LevelMap:        options.LevelMap,
+		AnonymizeIPs:    options.AnonymizeIPs,
+		AnonymizeSalt:   options.AnonymizeSalt,
+		DropFields:      options.DropFields,
+		KeepFields:      options.KeepFields,
+		RecordDelimiter: options.RecordDelimiter,
+		Contains:        options.Contains,
+		IgnoreCase:      options.IgnoreCase,
+		WordRegexp:      options.WordRegexp,
+		Tag:             options.Tag,
+		TagPrefix:       options.TagPrefix,
+		Retries:         options.Retries,
+		RetryBackoff:    options.RetryBackoff,
+		CheckOrder:      options.CheckOrder,
+		SessionMarker:   options.SessionMarker,
+		SessionGap:      options.SessionGap,
+		SplitOnMarker:   options.SplitOnMarker,
+		Preallocate:     options.Preallocate,
+		Fsync:           options.Fsync,
+		Header:          options.Header,
+	}
+}
+
+// GenerateBenchData writes a rotation set of parts of the given total size
+// in dir, returning the number of bytes written
+func GenerateBenchData(dir, basename string, size int64, parts, lineLength int) (int64, error) {
+	if parts < 1 {
+		parts = 1
+	}
+	if lineLength < 16 {
+		lineLength = 16
+	}
+	perPart := size / int64(parts)
+	filler := strings.Repeat("x", lineLength)
+
+	var total int64
+	var line int64
+	for idx := parts - 1; idx >= 0; idx-- {
+		name := basename + ".log"
+		if idx > 0 {
+			name = fmt.Sprintf("%s.log.%d", basename, idx)
+		}
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return total, err
+		}
+
+		var written int64
+		for written < perPart {
+			text := fmt.Sprintf("[Line %d] ", line)
+			if len(text) < lineLength-1 {
+				text += filler[:lineLength-1-len(text)]
+			}
+			text += "\n"
+			n, err := f.WriteString(text)
+			if err != nil {
+				_ = f.Close()
+				return total, err
+			}
+			written += int64(n)
+			line++
+		}
+		total += written
+		if err := f.Close(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+type CatCommand struct {
+	Args struct {
+		Basename string `positional-arg-name:"basename" description:"Base name of the log to print, default all of them"`
+	} `positional-args:"yes"`
+
+	options *Options
+}
+
+func (c *CatCommand) Execute(args []string) error {
+	if err := ConfigureLogging(c.options); err != nil {
+		return err
+	}
+	out := bufio.NewWriterSize(os.Stdout, defaultWriteBuffer)
+	var w io.Writer = out
+	var colors *transformWriter
+	if c.options.Color && isTerminal(os.Stdout) {
+		colors = newColorWriter(out, c.options)
+		w = colors
+	}
+	err := CatLogs(w, c.options, c.Args.Basename)
+	if colors != nil {
+		if flushErr := colors.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	if flushErr := out.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// CatLogs writes the content the merge would produce for the base name, or
+// for every base name when empty, without creating any file. The parts are
+// found, grouped and written as MainRoutine does, one output after the other.
+func CatLogs(w io.Writer, options *Options, basename string) error {
+	scan, err := newScanOptions(options)
+	if err != nil {
+		return err
+	}
+	combines, err := parseCombineSpecs(options.Combine)
+	if err != nil {
+		return err
+	}
+	tags, err := newTagInjector(options.Tag, options.TagPrefix)
+	if err != nil {
+		return err
+	}
+	input, basepath, release, err := openInput(string(scan.input))
+	if err != nil {
+		return err
+	}
+	defer release()
+	allFiles, err := scanInput(input, basepath, scan)
+	if err != nil {
+		return err
+	}
+	if allFiles, err = combineGroups(allFiles, combines); err != nil {
+		return err
+	}
+
+	var bases []string
+	if basename != "" {
+		if _, ok := allFiles[basename]; !ok {
+			return fmt.Errorf("no parts found for %s", basename)
+		}
+		bases = []string{basename}
+	} else {
+		for base := range allFiles {
+			bases = append(bases, base)
+		}
+		sort.Strings(bases)
+	}
+
+	run := &mergeRun{readBuffer: int(options.ReadBuffer), input: input}
+	if run.readBuffer <= 0 {
+		run.readBuffer = defaultReadBuffer
+	}
+	run.setContent(options, tags, time.Now())
+	for _, base := range bases {
+		list := allFiles[base]
+		SortLogList(list, options.newestFirst())
+		chunks, err := planChunks(list, options.MaxChunks)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			if err := catChunk(w, basepath, chunk, run); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// catChunk writes the parts of list as MergeLogChunk writes them to a new
+// output: after the header and through the transforms
+func catChunk(w io.Writer, basepath string, list []*logFile, run *mergeRun) error {
+	if run.header != "" {
+		if _, err := w.Write(outputHeader(run.header, list)); err != nil {
+			return err
+		}
+	}
+	merged := w
+	var transformer *transformWriter
+	if transforms := run.newTransforms(); len(transforms) > 0 {
+		transformer = newTransformWriter(w, transforms, run.delimiter)
+		merged = transformer
+	}
+	for _, part := range list {
+		if _, err := streamPartFrom(merged, run.input, basepath, part.name, 0, run.readBuffer, nil, time.Time{}); err != nil {
+			return err
+		}
+	}
+	if transformer != nil {
+		return transformer.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	clustersSuffix = ".clusters"
+	// clusterLineLimit is how much of a line is used to build its template
+	clusterLineLimit = 512
+	// clusterMaxTemplates bounds the memory used by the distinct templates,
+	// lines of new templates beyond it are counted as other
+	clusterMaxTemplates = 100000
+)
+
+var clusterNormalizers = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<UUID>"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`), "<IP>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]*\d[0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*\b`), "<HEX>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)?`), "<NUM>"},
+}
+
+// NormalizeMessage turns a line in a template by replacing the variable
+// parts (ids, addresses, numbers) with placeholders
+func NormalizeMessage(line string) string {
+	if ts := timestampPatterns[0].pattern.FindStringIndex(line); ts != nil && ts[0] < timestampScanLimit {
+		line = line[:ts[0]] + line[ts[1]:]
+	}
+	for _, normalizer := range clusterNormalizers {
+		line = normalizer.pattern.ReplaceAllString(line, normalizer.placeholder)
+	}
+	return strings.TrimSpace(line)
+}
+
+type messageCluster struct {
+	Template string
+	Count    int64
+	Example  string
+}
+
+// clusterWriter groups the error lines written to it by template
+type clusterWriter struct {
+	top      int
+	levels   *severityDetector
+	clusters map[string]*messageCluster
+	other    int64
+	line     []byte
+	// header is what is left of the header of the output, not clustered
+	header int64
+}
+
+func newClusterWriter(top int, levels *severityDetector) *clusterWriter {
+	if top < 1 {
+		top = 10
+	}
+	if levels == nil {
+		levels = defaultSeverityDetector
+	}
+	return &clusterWriter{
+		top:      top,
+		levels:   levels,
+		clusters: make(map[string]*messageCluster),
+		line:     make([]byte, 0, clusterLineLimit),
+	}
+}
+
+func (c *clusterWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for _, b := range skipBytes(p, &c.header) {
+		if b == '\n' {
+			c.endLine()
+			continue
+		}
+		if len(c.line) < clusterLineLimit {
+			c.line = append(c.line, b)
+		}
+	}
+	return n, nil
+}
+
+func (c *clusterWriter) skipHeader(size int64) {
+	c.header = size
+}
+
+func (c *clusterWriter) endLine() {
+	line := strings.TrimRight(string(c.line), "\r")
+	c.line = c.line[:0]
+
+	switch c.levels.Detect(line) {
+	case "ERROR", "FATAL":
+	default:
+		return
+	}
+	template := NormalizeMessage(line)
+	cluster, ok := c.clusters[template]
+	if !ok {
+		if len(c.clusters) >= clusterMaxTemplates {
+			c.other++
+			return
+		}
+		cluster = &messageCluster{Template: template, Example: line}
+		c.clusters[template] = cluster
+	}
+	cluster.Count++
+}
+
+// topClusters returns the most frequent templates first
+func (c *clusterWriter) topClusters() []*messageCluster {
+	list := make([]*messageCluster, 0, len(c.clusters))
+	for _, cluster := range c.clusters {
+		list = append(list, cluster)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Template < list[j].Template
+	})
+	if len(list) > c.top {
+		list = list[:c.top]
+	}
+	return list
+}
+
+func (c *clusterWriter) save(indexedPath string) error {
+	if len(c.line) > 0 {
+		c.endLine()
+	}
+	f, err := os.Create(indexedPath + clustersSuffix)
+	if err != nil {
+		return err
+	}
+	for idx, cluster := range c.topClusters() {
+		if _, err := fmt.Fprintf(f, "#%d count=%d\n  template: %s\n  example:  %s\n", idx+1, cluster.Count, cluster.Template, cluster.Example); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if c.other > 0 {
+		if _, err := fmt.Fprintf(f, "other=%d (too many distinct templates)\n", c.other); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+)
+
+// the ANSI sequences of the highlighted text
+const (
+	ansiReset = "\x1b[0m"
+	ansiMatch = "\x1b[1;35m"
+)
+
+// severityColors are the colors of the normalized severities
+var severityColors = map[string]string{
+	"FATAL": "\x1b[1;31m",
+	"ERROR": "\x1b[31m",
+	"WARN":  "\x1b[33m",
+	"INFO":  "\x1b[32m",
+	"DEBUG": "\x1b[34m",
+	"TRACE": "\x1b[34m",
+}
+
+// isTerminal reports whether f is a terminal, the colors are only written
+// there so that the bytes piped elsewhere are the ones of the outputs
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorSpan is a part of a line to color
+type colorSpan struct {
+	start, end int
+	color      string
+}
+
+// highlighter colors the --contains texts and the severity label of the
+// lines
+type highlighter struct {
+	matcher *lineMatcher
+	levels  *severityDetector
+}
+
+func newHighlighter(options *Options) *highlighter {
+	h := &highlighter{levels: newSeverityDetector(options.LevelMap)}
+	if len(options.Contains) > 0 {
+		h.matcher = newLineMatcher(options.Contains, options.IgnoreCase, options.WordRegexp)
+	}
+	return h
+}
+
+// newColorWriter highlights the lines written to w, ending with delim
+func newColorWriter(w io.Writer, options *Options) *transformWriter {
+	return newTransformWriter(w, []lineTransform{newHighlighter(options).highlight}, options.RecordDelimiter.value())
+}
+
+func (h *highlighter) highlight(line []byte) []byte {
+	var spans []colorSpan
+	head := line
+	if len(head) > severityScanLimit {
+		head = head[:severityScanLimit]
+	}
+	if loc := h.levels.pattern.FindIndex(head); loc != nil {
+		level := string(bytes.ToUpper(head[loc[0]:loc[1]]))
+		if alias, ok := h.levels.aliases[level]; ok {
+			level = alias
+		}
+		if color, ok := severityColors[level]; ok {
+			spans = append(spans, colorSpan{loc[0], loc[1], color})
+		}
+	}
+	if h.matcher != nil {
+		spans = append(spans, h.matches(line)...)
+	}
+	if len(spans) == 0 {
+		return line
+	}
+
+	// the spans overlapping an earlier one are left out
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	result := make([]byte, 0, len(line)+len(spans)*(len(ansiMatch)+len(ansiReset)))
+	last := 0
+	for _, span := range spans {
+		if span.start < last {
+			continue
+		}
+		result = append(result, line[last:span.start]...)
+		result = append(result, span.color...)
+		result = append(result, line[span.start:span.end]...)
+		result = append(result, ansiReset...)
+		last = span.end
+	}
+	return append(result, line[last:]...)
+}
+
+// matches returns the spans of the texts of the matcher in line
+func (h *highlighter) matches(line []byte) []colorSpan {
+	searched := line
+	if h.matcher.ignoreCase {
+		searched = bytes.ToLower(line)
+		// the offsets of a line whose case changes its length are not the
+		// ones of the line
+		if len(searched) != len(line) {
+			return nil
+		}
+	}
+	var spans []colorSpan
+	for _, text := range h.matcher.texts {
+		for start := 0; start <= len(searched)-len(text); {
+			idx := bytes.Index(searched[start:], text)
+			if idx < 0 {
+				break
+			}
+			idx += start
+			end := idx + len(text)
+			if !h.matcher.wholeWord || (idx == 0 || !isWordByte(searched[idx-1])) && (end == len(searched) || !isWordByte(searched[end])) {
+				spans = append(spans, colorSpan{idx, end, ansiMatch})
+				start = end
+			} else {
+				start = idx + 1
+			}
+		}
+	}
+	return spans
+}
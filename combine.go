@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// combineSpec merges the groups of sources into one group named target
+type combineSpec struct {
+	sources []string
+	target  string
+}
+
+// parseCombineSpecs parses the --combine values, 'api,worker=>platform'
+func parseCombineSpecs(values []string) ([]combineSpec, error) {
+	var specs []combineSpec
+	seen := make(map[string]bool)
+	for _, value := range values {
+		idx := strings.Index(value, "=>")
+		if idx < 0 {
+			return nil, &OptionError{"combine", fmt.Errorf("%q: expected base names and a target, e.g. api,worker=>platform", value)}
+		}
+		spec := combineSpec{target: strings.TrimSpace(value[idx+2:])}
+		if spec.target == "" || strings.ContainsAny(spec.target, `./\`) {
+			return nil, &OptionError{"combine", fmt.Errorf("%q: the target must be a base name, without dots or separators", value)}
+		}
+		for _, source := range strings.Split(value[:idx], ",") {
+			if source = strings.TrimSpace(source); source == "" {
+				continue
+			}
+			if seen[source] {
+				return nil, &OptionError{"combine", fmt.Errorf("%q: %s is already combined", value, source)}
+			}
+			seen[source] = true
+			spec.sources = append(spec.sources, source)
+		}
+		if len(spec.sources) == 0 {
+			return nil, &OptionError{"combine", fmt.Errorf("%q: no base names to combine", value)}
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// combineGroups replaces the groups of each spec with one group holding all
+// their parts, interleaved by modification time so that the parts covering
+// the same period are merged next to each other while the parts of each
+// group keep their order. The combined parts are renumbered so that the
+// merge order, which follows the index, is the interleaved one.
+func combineGroups(allFiles FilesList, specs []combineSpec) (FilesList, error) {
+	for _, spec := range specs {
+		if _, ok := allFiles[spec.target]; ok && !contains(spec.sources, spec.target) {
+			return nil, fmt.Errorf("--combine target %s is also the base name of other parts", spec.target)
+		}
+
+		var groups [][]*logFile
+		total := 0
+		for _, source := range spec.sources {
+			list, ok := allFiles[source]
+			if !ok {
+				log.Warnf("No parts found for %s, not combined into %s\n", source, spec.target)
+				continue
+			}
+			delete(allFiles, source)
+			// oldest first, like the merge
+			SortLogList(list, false)
+			groups = append(groups, list)
+			total += len(list)
+		}
+		if total == 0 {
+			continue
+		}
+
+		// take the next part of the group whose next part is the oldest, the
+		// first group listed on ties
+		combined := make([]*logFile, 0, total)
+		for len(combined) < total {
+			next := -1
+			for idx, list := range groups {
+				if len(list) > 0 && (next < 0 || list[0].modTime.Before(groups[next][0].modTime)) {
+					next = idx
+				}
+			}
+			part := *groups[next][0]
+			part.index = total - len(combined)
+			combined = append(combined, &part)
+			groups[next] = groups[next][1:]
+		}
+		allFiles[spec.target] = combined
+		log.Printf("[Combined %s into %s: %d parts]\n", strings.Join(spec.sources, ","), spec.target, len(combined))
+	}
+	return allFiles, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// commandArgumentChoices lists the accepted positional values of the
+// subcommands, which go-flags does not track on its own
+var commandArgumentChoices = map[string][]string{
+	"completion": completionShells,
+}
+
+type CompletionCommand struct {
+	Args struct {
+		Shell string `positional-arg-name:"shell" description:"One of bash, zsh, fish or powershell"`
+	} `positional-args:"yes" required:"yes"`
+
+	parser *flags.Parser
+}
+
+func (c *CompletionCommand) Execute(args []string) error {
+	return WriteCompletion(os.Stdout, c.parser, c.Args.Shell)
+}
+
+// completionOption describes a single flag of the parser in a shell agnostic way
+type completionOption struct {
+	short       string
+	long        string
+	description string
+	takesValue  bool
+	choices     []string
+	directory   bool
+	file        bool
+}
+
+func (o completionOption) names() []string {
+	var names []string
+	if o.short != "" {
+		names = append(names, "-"+o.short)
+	}
+	if o.long != "" {
+		names = append(names, "--"+o.long)
+	}
+	return names
+}
+
+// completionCommandSpec groups the options and accepted arguments of a
+// subcommand, or of the root command when name is empty
+type completionCommandSpec struct {
+	name        string
+	description string
+	options     []completionOption
+	arguments   []string
+}
+
+func collectCompletionOptions(group *flags.Group) []completionOption {
+	var list []completionOption
+	for _, opt := range group.Options() {
+		if opt.Hidden {
+			continue
+		}
+		field := opt.Field()
+		kind := field.Type.Kind()
+		if kind == reflect.Slice {
+			kind = field.Type.Elem().Kind()
+		}
+		def := completionOption{
+			long:        opt.LongName,
+			description: opt.Description,
+			takesValue:  kind != reflect.Bool && kind != reflect.Func,
+			choices:     opt.Choices,
+			directory:   field.Tag.Get("completion") == "directory",
+			file:        field.Type == reflect.TypeOf(flags.Filename("")),
+		}
+		if opt.ShortName != 0 {
+			def.short = string(opt.ShortName)
+		}
+		list = append(list, def)
+	}
+	for _, sub := range group.Groups() {
+		list = append(list, collectCompletionOptions(sub)...)
+	}
+	return list
+}
+
+func collectCompletionSpecs(parser *flags.Parser) (completionCommandSpec, []completionCommandSpec) {
+	root := completionCommandSpec{
+		options: collectCompletionOptions(parser.Command.Group),
+	}
+
+	var commands []completionCommandSpec
+	for _, cmd := range parser.Commands() {
+		if cmd.Hidden {
+			continue
+		}
+		commands = append(commands, completionCommandSpec{
+			name:        cmd.Name,
+			description: cmd.ShortDescription,
+			options:     collectCompletionOptions(cmd.Group),
+			arguments:   commandArgumentChoices[cmd.Name],
+		})
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].name < commands[j].name
+	})
+	return root, commands
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// WriteCompletion writes the completion script for the requested shell,
+// covering every flag and subcommand registered on the parser
+func WriteCompletion(w io.Writer, parser *flags.Parser, shell string) error {
+	root, commands := collectCompletionSpecs(parser)
+	program := parser.Name
+
+	switch shell {
+	case "bash":
+		return writeBashCompletion(w, program, root, commands)
+	case "zsh":
+		if _, err := fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit"); err != nil {
+			return err
+		}
+		return writeBashCompletion(w, program, root, commands)
+	case "fish":
+		return writeFishCompletion(w, program, root, commands)
+	case "powershell":
+		return writePowershellCompletion(w, program, root, commands)
+	}
+	return fmt.Errorf("unsupported shell %q, expected one of: %s", shell, strings.Join(completionShells, ", "))
+}
+
+// uniqueCompletionOptions merges the options of the root and of every
+// subcommand, keeping only the first definition of each flag
+func uniqueCompletionOptions(root completionCommandSpec, commands []completionCommandSpec) []completionOption {
+	seen := make(map[string]bool)
+	var list []completionOption
+	add := func(options []completionOption) {
+		for _, opt := range options {
+			key := strings.Join(opt.names(), "|")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			list = append(list, opt)
+		}
+	}
+	add(root.options)
+	for _, cmd := range commands {
+		add(cmd.options)
+	}
+	return list
+}
+
+func allOptionNames(options []completionOption) string {
+	var names []string
+	for _, opt := range options {
+		names = append(names, opt.names()...)
+	}
+	return strings.Join(names, " ")
+}
+
+func writeBashCompletion(w io.Writer, program string, root completionCommandSpec, commands []completionCommandSpec) error {
+	function := "_" + nonIdentifierChars.ReplaceAllString(program, "_")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n", program)
+	fmt.Fprintf(&b, "%s() {\n", function)
+	b.WriteString("    local cur prev cmd i\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    cmd=\"\"\n")
+
+	if len(commands) > 0 {
+		var names []string
+		for _, cmd := range commands {
+			names = append(names, cmd.name)
+		}
+		b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+		b.WriteString("        case \"${COMP_WORDS[i]}\" in\n")
+		fmt.Fprintf(&b, "            %s) cmd=\"${COMP_WORDS[i]}\"; break ;;\n", strings.Join(names, "|"))
+		b.WriteString("        esac\n")
+		b.WriteString("    done\n")
+	}
+
+	// values of the options, regardless of the subcommand they belong to
+	b.WriteString("\n    case \"$prev\" in\n")
+	all := uniqueCompletionOptions(root, commands)
+	for _, opt := range all {
+		if !opt.takesValue {
+			continue
+		}
+		reply := ""
+		switch {
+		case len(opt.choices) > 0:
+			reply = fmt.Sprintf("COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); ", strings.Join(opt.choices, " "))
+		case opt.directory:
+			reply = "COMPREPLY=($(compgen -d -- \"$cur\")); "
+		case opt.file:
+			reply = "COMPREPLY=($(compgen -f -- \"$cur\")); "
+		}
+		fmt.Fprintf(&b, "        %s) %sreturn 0 ;;\n", strings.Join(opt.names(), "|"), reply)
+	}
+	b.WriteString("    esac\n")
+
+	b.WriteString("\n    case \"$cmd\" in\n")
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	writeBashBranch(&b, "\"\"", allOptionNames(root.options), strings.Join(names, " "))
+	for _, cmd := range commands {
+		options := allOptionNames(uniqueCompletionOptions(root, []completionCommandSpec{cmd}))
+		writeBashBranch(&b, cmd.name, options, strings.Join(cmd.arguments, " "))
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", function, program)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeBashBranch(b *strings.Builder, label, options, words string) {
+	fmt.Fprintf(b, "        %s)\n", label)
+	b.WriteString("            if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(b, "                COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", options)
+	if words != "" {
+		b.WriteString("            else\n")
+		fmt.Fprintf(b, "                COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", words)
+	}
+	b.WriteString("            fi ;;\n")
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "\\'") + "'"
+}
+
+func writeFishCompletion(w io.Writer, program string, root completionCommandSpec, commands []completionCommandSpec) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\n", program)
+	fmt.Fprintf(&b, "complete -c %s -f\n", program)
+
+	writeOptions := func(condition string, options []completionOption) {
+		for _, opt := range options {
+			fmt.Fprintf(&b, "complete -c %s", program)
+			if condition != "" {
+				fmt.Fprintf(&b, " -n %s", fishQuote(condition))
+			}
+			if opt.short != "" {
+				fmt.Fprintf(&b, " -s %s", opt.short)
+			}
+			if opt.long != "" {
+				fmt.Fprintf(&b, " -l %s", opt.long)
+			}
+			switch {
+			case !opt.takesValue:
+			case len(opt.choices) > 0:
+				fmt.Fprintf(&b, " -x -a %s", fishQuote(strings.Join(opt.choices, " ")))
+			case opt.directory:
+				b.WriteString(" -x -a '(__fish_complete_directories)'")
+			case opt.file:
+				b.WriteString(" -r -F")
+			default:
+				b.WriteString(" -x")
+			}
+			if opt.description != "" {
+				fmt.Fprintf(&b, " -d %s", fishQuote(opt.description))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	writeOptions("", root.options)
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c %s -n '__fish_use_subcommand' -a %s -d %s\n", program, cmd.name, fishQuote(cmd.description))
+		condition := "__fish_seen_subcommand_from " + cmd.name
+		writeOptions(condition, cmd.options)
+		if len(cmd.arguments) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n", program, fishQuote(condition), fishQuote(strings.Join(cmd.arguments, " ")))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func powershellList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, "'"+strings.ReplaceAll(item, "'", "''")+"'")
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+func writePowershellCompletion(w io.Writer, program string, root completionCommandSpec, commands []completionCommandSpec) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# powershell completion for %s\n", program)
+	fmt.Fprintf(&b, "Register-ArgumentCompleter -Native -CommandName '%s' -ScriptBlock {\n", program)
+	b.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	b.WriteString("    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })\n")
+	b.WriteString("    $prev = ''\n")
+	b.WriteString("    if ($words.Count -gt 1) {\n")
+	b.WriteString("        if ($wordToComplete -eq '') { $prev = $words[-1] } else { $prev = $words[-2] }\n")
+	b.WriteString("    }\n")
+
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(&b, "    $commands = %s\n", powershellList(names))
+	b.WriteString("    $cmd = ''\n")
+	b.WriteString("    foreach ($word in $words) { if ($commands -contains $word) { $cmd = $word; break } }\n")
+
+	all := uniqueCompletionOptions(root, commands)
+	b.WriteString("    $candidates = switch -CaseSensitive ($prev) {\n")
+	for _, opt := range all {
+		if !opt.takesValue {
+			continue
+		}
+		value := "@()"
+		switch {
+		case len(opt.choices) > 0:
+			value = powershellList(opt.choices)
+		case opt.directory:
+			value = "@(Get-ChildItem -Directory -Path \"$wordToComplete*\" | ForEach-Object { $_.Name })"
+		case opt.file:
+			value = "@(Get-ChildItem -Path \"$wordToComplete*\" | ForEach-Object { $_.Name })"
+		}
+		for _, name := range opt.names() {
+			fmt.Fprintf(&b, "        '%s' { %s; break }\n", name, value)
+		}
+	}
+	b.WriteString("        default { $null }\n")
+	b.WriteString("    }\n")
+
+	b.WriteString("    if ($null -eq $candidates) {\n")
+	b.WriteString("        $candidates = switch ($cmd) {\n")
+	rootOptions := powershellList(strings.Fields(allOptionNames(root.options)))
+	b.WriteString("            '' {\n")
+	fmt.Fprintf(&b, "                if ($wordToComplete.StartsWith('-')) { %s } else { $commands }\n", rootOptions)
+	b.WriteString("            }\n")
+	for _, cmd := range commands {
+		options := powershellList(strings.Fields(allOptionNames(uniqueCompletionOptions(root, []completionCommandSpec{cmd}))))
+		fmt.Fprintf(&b, "            '%s' {\n", cmd.name)
+		fmt.Fprintf(&b, "                if ($wordToComplete.StartsWith('-')) { %s } else { %s }\n", options, powershellList(cmd.arguments))
+		b.WriteString("            }\n")
+	}
+	b.WriteString("        }\n")
+	b.WriteString("    }\n")
+	b.WriteString("    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	b.WriteString("        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+
+	req "github.com/stretchr/testify/require"
+)
+
+func init() {
+	AllTestSuites = append(AllTestSuites, &CompletionSuite{})
+}
+
+type CompletionSuite struct {
+	BaseSuite
+}
+
+func (s *CompletionSuite) TestBashCompletion() {
+	var buf bytes.Buffer
+	err := WriteCompletion(&buf, NewParser(&Options{}), "bash")
+	req.NoError(s.T(), err)
+
+	script := buf.String()
+	req.Contains(s.T(), script, `-i|--input) COMPREPLY=($(compgen -d -- "$cur"))`, "Input flag is missing directory completion")
+	req.Contains(s.T(), script, `--log-format) COMPREPLY=($(compgen -W "text json" -- "$cur"))`, "Enum flag is missing its choices")
+	req.Contains(s.T(), script, `compgen -W "bash zsh fish powershell"`, "Subcommand arguments are missing")
+}
+
+func (s *CompletionSuite) TestAllShells() {
+	for _, shell := range completionShells {
+		var buf bytes.Buffer
+		err := WriteCompletion(&buf, NewParser(&Options{}), shell)
+		req.NoErrorf(s.T(), err, "Failed generating completion for %s", shell)
+		req.Containsf(s.T(), buf.String(), "completion", "Subcommands missing from %s script", shell)
+	}
+
+	err := WriteCompletion(&bytes.Buffer{}, NewParser(&Options{}), "tcsh")
+	req.Error(s.T(), err, "Unsupported shell was accepted")
+}
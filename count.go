@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// PartCounts are the lines of a part matched by the --contains filter
+type PartCounts struct {
+	Name  string
+	Lines int64
+	// Kept are the lines the filter keeps, containing any of the texts
+	Kept int64
+	// Texts are the lines containing each text, in the order of the texts
+	Texts []int64
+}
+
+func (c *PartCounts) add(other PartCounts) {
+	c.Lines += other.Lines
+	c.Kept += other.Kept
+	for idx, count := range other.Texts {
+		c.Texts[idx] += count
+	}
+}
+
+// GroupCounts are the counts of the parts of a group, in merge order, and
+// their total
+type GroupCounts struct {
+	Name  string
+	Total PartCounts
+	Parts []PartCounts
+	Err   error
+}
+
+// CountMatches reads the parts of every group through the --contains filter
+// and counts the lines matching each text, without writing anything. A
+// group stops at the first part that cannot be read.
+func CountMatches(fsys fs.FS, basepath string, allFiles FilesList, options *Options) []GroupCounts {
+	filter := newLineMatcher(options.Contains, options.IgnoreCase, options.WordRegexp)
+	matchers := make([]*lineMatcher, len(options.Contains))
+	for idx, text := range options.Contains {
+		matchers[idx] = newLineMatcher([]string{text}, options.IgnoreCase, options.WordRegexp)
+	}
+	delim := options.RecordDelimiter.value()
+
+	bases := make([]string, 0, len(allFiles))
+	for base := range allFiles {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	result := make([]GroupCounts, 0, len(bases))
+	for _, base := range bases {
+		list := allFiles[base]
+		SortLogList(list, options.newestFirst())
+		group := GroupCounts{Name: base, Total: PartCounts{Name: base, Texts: make([]int64, len(matchers))}}
+		for _, part := range list {
+			counts, err := countPart(fsys, basepath, part.name, filter, matchers, delim)
+			if err != nil {
+				group.Err = fmt.Errorf("%s: %v", part.name, err)
+				break
+			}
+			group.Parts = append(group.Parts, counts)
+			group.Total.add(counts)
+		}
+		result = append(result, group)
+	}
+	return result
+}
+
+func countPart(fsys fs.FS, basepath, name string, filter *lineMatcher, matchers []*lineMatcher, delim byte) (PartCounts, error) {
+	counts := PartCounts{Name: name, Texts: make([]int64, len(matchers))}
+	f, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return counts, err
+	}
+	defer f.Close()
+
+	err = eachRecord(f, delim, func(line []byte) error {
+		counts.Lines++
+		if len(filter.texts) == 0 || filter.matches(line) {
+			counts.Kept++
+		}
+		for idx, matcher := range matchers {
+			if len(matcher.texts) > 0 && matcher.matches(line) {
+				counts.Texts[idx]++
+			}
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// WriteMatchCounts prints the counts of every group, then of its parts, one
+// column for each of the texts
+func WriteMatchCounts(w io.Writer, counts []GroupCounts, texts []string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "NAME\tLINES\tKEPT")
+	for _, text := range texts {
+		fmt.Fprintf(tw, "\t%s", strconv.Quote(text))
+	}
+	fmt.Fprintln(tw)
+
+	row := func(indent string, c PartCounts) {
+		fmt.Fprintf(tw, "%s%s\t%d\t%d", indent, c.Name, c.Lines, c.Kept)
+		for _, count := range c.Texts {
+			fmt.Fprintf(tw, "\t%d", count)
+		}
+		fmt.Fprintln(tw)
+	}
+	for _, group := range counts {
+		if group.Err != nil {
+			fmt.Fprintf(tw, "%s\terror: %v\n", group.Name, group.Err)
+			continue
+		}
+		row("", group.Total)
+		for _, part := range group.Parts {
+			row("  ", part)
+		}
+	}
+	return tw.Flush()
+}
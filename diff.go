@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type DiffCommand struct {
+	Parts bool `long:"parts" description:"Compare the aggregate with the parts of its base name in the input path instead of another aggregate"`
+	Max   int  `long:"max" description:"Number of differences printed of each kind" default:"100"`
+	Args  struct {
+		File  flags.Filename `positional-arg-name:"file" description:"Aggregate to compare" required:"yes"`
+		Other flags.Filename `positional-arg-name:"other" description:"Aggregate to compare with, not used with --parts"`
+	} `positional-args:"yes"`
+
+	options *Options
+}
+
+func (c *DiffCommand) Execute(args []string) error {
+	if err := ConfigureLogging(c.options); err != nil {
+		return err
+	}
+	expected := []diffSource{{name: filepath.Base(string(c.Args.File)), path: string(c.Args.File)}}
+	var actual []diffSource
+	if c.Parts {
+		if c.Args.Other != "" {
+			return errors.New("--parts compares with the parts, no other aggregate is needed")
+		}
+		parts, err := diffPartSources(c.options, string(c.Args.File))
+		if err != nil {
+			return err
+		}
+		// the parts are the reference, the aggregate has the missing lines
+		expected, actual = parts, expected
+	} else {
+		if c.Args.Other == "" {
+			return errors.New("two aggregates, or --parts, are needed")
+		}
+		actual = []diffSource{{name: filepath.Base(string(c.Args.Other)), path: string(c.Args.Other)}}
+	}
+
+	report, err := DiffSources(expected, actual, c.Max)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(os.Stdout); err != nil {
+		return err
+	}
+	if report.Missing > 0 || report.Extra > 0 {
+		return errors.New("the aggregates differ")
+	}
+	return nil
+}
+
+// diffSource is a file whose lines are compared, name is the one reported
+type diffSource struct {
+	name string
+	path string
+}
+
+// diffPartSources lists the parts merged into the aggregate, in merge order
+func diffPartSources(options *Options, aggregate string) ([]diffSource, error) {
+	scan, err := newScanOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	allFiles, err := ScanFolder(scan.input, scan)
+	if err != nil {
+		return nil, err
+	}
+	base := groupName(filepath.Base(aggregate))
+	list, ok := allFiles[base]
+	if !ok {
+		return nil, fmt.Errorf("no parts found for %s", base)
+	}
+	SortLogList(list, options.newestFirst())
+	sources := make([]diffSource, 0, len(list))
+	for _, part := range list {
+		sources = append(sources, diffSource{name: part.name, path: filepath.Join(string(scan.input), part.name)})
+	}
+	return sources, nil
+}
+
+// DiffEntry is a line found on one side only, or a line replaced by another
+// at the same position for Kind '~'
+type DiffEntry struct {
+	Kind  byte
+	Where string
+	Text  string
+	// Other is where the replacing line is, for Kind '~'
+	OtherWhere string
+	OtherText  string
+
+	position int64
+}
+
+// DiffReport counts the lines missing from and extra in the actual side,
+// the entries are the first ones found
+type DiffReport struct {
+	Missing int64
+	Extra   int64
+	Changed int64
+	Entries []DiffEntry
+}
+
+// eachSourceLine calls fn with every line of the sources, with its position
+// in the whole stream and where it comes from
+func eachSourceLine(sources []diffSource, fn func(line []byte, position int64, where string)) error {
+	var position int64
+	for _, source := range sources {
+		f, err := openPart(nil, "", source.path)
+		if err != nil {
+			return err
+		}
+		var lineNo int64
+		err = eachLine(f, func(line []byte) error {
+			lineNo++
+			fn(bytes.TrimRight(line, "\r\n"), position, fmt.Sprintf("%s:%d", source.name, lineNo))
+			position++
+			return nil
+		})
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lineHash(line []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(line)
+	return h.Sum64()
+}
+
+// DiffSources compares the lines of expected and actual regardless of their
+// order, reporting the lines missing from actual and the extra ones with the
+// file and line they come from. A missing and an extra line at the same
+// position of their streams are reported as changed. At most max entries of
+// each kind are kept, the counts are complete.
+func DiffSources(expected, actual []diffSource, max int) (*DiffReport, error) {
+	counts := make(map[uint64]int64)
+	if err := eachSourceLine(expected, func(line []byte, position int64, where string) {
+		counts[lineHash(line)]++
+	}); err != nil {
+		return nil, err
+	}
+
+	report := &DiffReport{}
+	var extra []DiffEntry
+	if err := eachSourceLine(actual, func(line []byte, position int64, where string) {
+		key := lineHash(line)
+		if counts[key] > 0 {
+			counts[key]--
+			return
+		}
+		report.Extra++
+		if len(extra) < max {
+			extra = append(extra, DiffEntry{Kind: '+', Where: where, Text: string(line), position: position})
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	// the lines left in counts are missing, the first occurrences are reported
+	var missing []DiffEntry
+	for _, count := range counts {
+		report.Missing += count
+	}
+	if report.Missing > 0 {
+		if err := eachSourceLine(expected, func(line []byte, position int64, where string) {
+			key := lineHash(line)
+			if counts[key] == 0 {
+				return
+			}
+			counts[key]--
+			if len(missing) < max {
+				missing = append(missing, DiffEntry{Kind: '-', Where: where, Text: string(line), position: position})
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// pair the entries at the same position
+	extraAt := make(map[int64]int, len(extra))
+	for idx, entry := range extra {
+		extraAt[entry.position] = idx
+	}
+	paired := make(map[int]bool)
+	for _, entry := range missing {
+		if idx, ok := extraAt[entry.position]; ok {
+			entry.Kind = '~'
+			entry.OtherWhere, entry.OtherText = extra[idx].Where, extra[idx].Text
+			paired[idx] = true
+			report.Changed++
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+	for idx, entry := range extra {
+		if !paired[idx] {
+			report.Entries = append(report.Entries, entry)
+		}
+	}
+	sort.SliceStable(report.Entries, func(i, j int) bool {
+		return report.Entries[i].position < report.Entries[j].position
+	})
+	return report, nil
+}
+
+// Write prints the entries and the counts of the report
+func (r *DiffReport) Write(w io.Writer) error {
+	for _, entry := range r.Entries {
+		var err error
+		switch entry.Kind {
+		case '~':
+			_, err = fmt.Fprintf(w, "~ %s: %s\n  %s: %s\n", entry.Where, entry.Text, entry.OtherWhere, entry.OtherText)
+		default:
+			_, err = fmt.Fprintf(w, "%c %s: %s\n", entry.Kind, entry.Where, entry.Text)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d missing, %d extra, %d changed\n", r.Missing, r.Extra, r.Changed)
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckDiskSpace verifies that the filesystem holding basepath can store the
+// aggregates of all the groups, estimated as their total input size times
+// the safety factor. A factor of zero or less disables the check.
+func CheckDiskSpace(basepath string, allFiles FilesList, factor float64) error {
+	if factor <= 0 {
+		return nil
+	}
+
+	var total int64
+	for _, list := range allFiles {
+		for _, part := range list {
+			total += part.size
+		}
+	}
+	required := int64(float64(total) * factor)
+
+	available, err := availableDiskSpace(basepath)
+	if err != nil {
+		log.Warningf("Could not check the free disk space, continuing: %v\n", err)
+		return nil
+	}
+	log.Printf("[Disk space: %s required, %s available]\n", formatBytes(required), formatBytes(available))
+	if required > available {
+		return fmt.Errorf("not enough disk space in %s: %s required (%s of input with safety factor %v), %s available",
+			basepath, formatBytes(required), formatBytes(total), factor, formatBytes(available))
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package main
+
+import "errors"
+
+func availableDiskSpace(path string) (int64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
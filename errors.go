@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoFilesFound is returned when the input path holds no parts
+	ErrNoFilesFound = errors.New("no parts found")
+	// ErrOutputExists is returned for an output left by a previous run with
+	// --if-exists fail
+	ErrOutputExists = errors.New("output already exists")
+)
+
+// PartUnreadableError reports a part that could not be read
+type PartUnreadableError struct {
+	File string
+	Err  error
+}
+
+func (e *PartUnreadableError) Error() string {
+	return fmt.Sprintf("reading %s: %v", e.File, e.Err)
+}
+
+func (e *PartUnreadableError) Unwrap() error {
+	return e.Err
+}
+
+// Exit codes of the merge, listed in the help
+const (
+	exitOK             = 0
+	exitFailure        = 1
+	exitInvalidOptions = 2
+	exitNoFilesFound   = 3
+	exitOutputExists   = 4
+	exitPartUnreadable = 5
+)
+
+const exitCodesHelp = "Exit codes: 0 success, 1 failure, 2 invalid options, 3 no parts found, " +
+	"4 output already exists with --if-exists fail, 5 part not readable without --skip-errors"
+
+// exitCode maps the error ending a run to its exit code
+func exitCode(err error) int {
+	var conflict *ConflictError
+	var invalid *OptionError
+	var unreadable *PartUnreadableError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &conflict), errors.As(err, &invalid):
+		return exitInvalidOptions
+	case errors.Is(err, ErrNoFilesFound):
+		return exitNoFilesFound
+	case errors.Is(err, ErrOutputExists):
+		return exitOutputExists
+	case errors.As(err, &unreadable):
+		return exitPartUnreadable
+	}
+	return exitFailure
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// estimateSample is how much of the inputs is read to measure the throughput
+const estimateSample = 16 << 20
+
+// ChunkEstimate is an output the merge would write
+type ChunkEstimate struct {
+	Name  string
+	Parts int
+	Bytes int64
+}
+
+// GroupEstimate is what the merge of a group would produce
+type GroupEstimate struct {
+	Name   string
+	Parts  int
+	Bytes  int64
+	Chunks []ChunkEstimate
+	Err    error
+}
+
+// EstimateMerge plans the chunks of every group without writing anything
+func EstimateMerge(allFiles FilesList, options *Options, names *outputNames) []GroupEstimate {
+	bases := make([]string, 0, len(allFiles))
+	for base := range allFiles {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	result := make([]GroupEstimate, 0, len(bases))
+	for _, base := range bases {
+		list := allFiles[base]
+		SortLogList(list, options.newestFirst())
+		group := GroupEstimate{Name: base, Parts: len(list)}
+		for _, part := range list {
+			group.Bytes += part.size
+		}
+		chunks, err := planChunks(list, options.MaxChunks)
+		group.Err = err
+		for chunkIdx, chunk := range chunks {
+			estimate := ChunkEstimate{Name: names.chunk(base, chunkIdx, len(chunks)), Parts: len(chunk)}
+			for _, part := range chunk {
+				estimate.Bytes += part.size
+			}
+			group.Chunks = append(group.Chunks, estimate)
+		}
+		result = append(result, group)
+	}
+	return result
+}
+
+// sampleThroughput reads up to estimateSample bytes of the parts, largest
+// first, and returns the bytes read per second
+func sampleThroughput(fsys fs.FS, basepath string, allFiles FilesList, limit Rate) float64 {
+	var parts []*logFile
+	for _, list := range allFiles {
+		parts = append(parts, list...)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].size > parts[j].size })
+
+	buf := make([]byte, defaultReadBuffer)
+	var read int64
+	start := time.Now()
+	for _, part := range parts {
+		if read >= estimateSample {
+			break
+		}
+		f, err := openPart(fsys, basepath, part.name)
+		if err != nil {
+			continue
+		}
+		n, _ := io.CopyBuffer(ioutil.Discard, io.LimitReader(f, estimateSample-read), buf)
+		_ = f.Close()
+		read += n
+	}
+	elapsed := time.Since(start).Seconds()
+	if read == 0 || elapsed <= 0 {
+		return 0
+	}
+	throughput := float64(read) / elapsed
+	if limit > 0 && float64(limit) < throughput {
+		throughput = float64(limit)
+	}
+	return throughput
+}
+
+// WriteEstimate prints the planned outputs and the expected duration, which
+// is optimistic when the sampled parts were already in the page cache
+func WriteEstimate(w io.Writer, estimates []GroupEstimate, throughput float64) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPARTS\tSIZE\tOUTPUT")
+	var total int64
+	for _, group := range estimates {
+		total += group.Bytes
+		if group.Err != nil {
+			fmt.Fprintf(tw, "%s\t%d\t%s\terror: %v\n", group.Name, group.Parts, formatBytes(group.Bytes), group.Err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d chunks\n", group.Name, group.Parts, formatBytes(group.Bytes), len(group.Chunks))
+		for _, chunk := range group.Chunks {
+			fmt.Fprintf(tw, "  %s\t%d\t%s\t\n", chunk.Name, chunk.Parts, formatBytes(chunk.Bytes))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if throughput <= 0 {
+		_, err := fmt.Fprintf(w, "\nTotal: %s, duration unknown\n", formatBytes(total))
+		return err
+	}
+	duration := fmt.Sprint(time.Duration(float64(total) / throughput * float64(time.Second)).Round(time.Second))
+	if duration == "0s" {
+		duration = "<1s"
+	}
+	_, err := fmt.Fprintf(w, "\nTotal: %s, estimated duration %s at %s/s\n", formatBytes(total), duration, formatBytes(int64(throughput)))
+	return err
+}
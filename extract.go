@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type ExtractCommand struct {
+	Since  string         `long:"since" description:"Start of the time range, e.g. 2021-03-01T10:00:00Z"`
+	Until  string         `long:"until" description:"End of the time range (excluded)"`
+	Output flags.Filename `short:"o" long:"output" description:"Write the extracted lines to this file instead of stdout"`
+	Args   struct {
+		File flags.Filename `positional-arg-name:"file" description:"Aggregate to extract from"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	ts, ok := ParseTimestamp(value)
+	if !ok {
+		return time.Time{}, &OptionError{name, fmt.Errorf("%s is not a timestamp", value)}
+	}
+	return ts, nil
+}
+
+func (c *ExtractCommand) Execute(args []string) error {
+	since, err := parseTimeFlag("since", c.Since)
+	if err != nil {
+		return err
+	}
+	until, err := parseTimeFlag("until", c.Until)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Output != "" {
+		f, err := os.Create(string(c.Output))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return ExtractTimeRange(string(c.Args.File), since, until, w)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// logfmtKey matches the key of a logfmt key=value pair
+var logfmtKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*=`)
+
+// fieldFilter removes fields from JSON object and logfmt lines, either the
+// dropped ones or all but the kept ones. Only the top level fields of JSON
+// objects are considered, the other lines are left unchanged. The lines end
+// with delim.
+type fieldFilter struct {
+	drop  map[string]bool
+	keep  map[string]bool
+	delim byte
+}
+
+// newFieldFilter builds the filter from lists of comma separated names,
+// a keep list takes precedence over a drop list
+func newFieldFilter(drop, keep []string, delim byte) *fieldFilter {
+	return &fieldFilter{drop: fieldSet(drop), keep: fieldSet(keep), delim: delim}
+}
+
+func fieldSet(lists []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, list := range lists {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				set[name] = true
+			}
+		}
+	}
+	return set
+}
+
+func (f *fieldFilter) keeps(key string) bool {
+	if len(f.keep) > 0 {
+		return f.keep[key]
+	}
+	return !f.drop[key]
+}
+
+func (f *fieldFilter) filter(line []byte) []byte {
+	body := bytes.TrimRight(bytes.TrimSuffix(line, []byte{f.delim}), "\r\n")
+	eol := line[len(body):]
+
+	var filtered []byte
+	var changed bool
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		filtered, changed = f.filterJSON(trimmed)
+	} else {
+		filtered, changed = f.filterLogfmt(body)
+	}
+	if !changed {
+		return line
+	}
+	return append(filtered, eol...)
+}
+
+// filterJSON rewrites the object without the removed fields, keeping the
+// order and the encoding of the other ones
+func (f *fieldFilter) filterJSON(body []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, false
+	}
+
+	result := []byte{'{'}
+	changed := false
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, false
+		}
+		if !f.keeps(key) {
+			changed = true
+			continue
+		}
+		if len(result) > 1 {
+			result = append(result, ',')
+		}
+		result = append(result, encodeJSONString(key)...)
+		result = append(result, ':')
+		result = append(result, value...)
+	}
+	if token, err := decoder.Token(); err != nil || token != json.Delim('}') {
+		return nil, false
+	}
+	// anything after the object means the line is not a JSON record
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+	return append(result, '}'), changed
+}
+
+func encodeJSONString(text string) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(text)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+// filterLogfmt removes the key=value pairs along with the space before them,
+// the words that are not pairs are kept
+func (f *fieldFilter) filterLogfmt(body []byte) ([]byte, bool) {
+	var result []byte
+	changed := false
+	last := 0
+	pos := 0
+	for pos < len(body) {
+		if isLogfmtSpace(body[pos]) {
+			pos++
+			continue
+		}
+		start := pos
+		end := logfmtTokenEnd(body, pos)
+		pos = end
+
+		key := logfmtKey.Find(body[start:end])
+		if key == nil || f.keeps(string(key[:len(key)-1])) {
+			continue
+		}
+		// drop the separator before the pair, or after it for the first one
+		// of a line
+		cut := start
+		for cut > 0 && (body[cut-1] == ' ' || body[cut-1] == '\t') {
+			cut--
+		}
+		if cut == 0 || body[cut-1] == '\n' || body[cut-1] == '\r' {
+			for end < len(body) && (body[end] == ' ' || body[end] == '\t') {
+				end++
+			}
+			pos = end
+		}
+		if cut < last {
+			cut = last
+		}
+		result = append(result, body[last:cut]...)
+		last = end
+		changed = true
+	}
+	if !changed {
+		return nil, false
+	}
+	return append(result, body[last:]...), true
+}
+
+// isLogfmtSpace tells the bytes separating the pairs, the line breaks
+// included for the records spanning several lines
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// logfmtTokenEnd returns the end of the token starting at pos, spaces
+// inside double quotes do not end it
+func logfmtTokenEnd(body []byte, pos int) int {
+	quoted := false
+	for ; pos < len(body); pos++ {
+		switch c := body[pos]; {
+		case c == '\\' && quoted:
+			pos++
+		case c == '"':
+			quoted = !quoted
+		case isLogfmtSpace(c) && !quoted:
+			return pos
+		}
+	}
+	return pos
+}
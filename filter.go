@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+)
+
+// lineMatcher tells the lines containing one of the texts. The texts are
+// plain text, not regexps, so they need no escaping and are matched with
+// bytes.Contains, regardless of case with ignoreCase and only as whole words
+// with wholeWord.
+type lineMatcher struct {
+	texts      [][]byte
+	ignoreCase bool
+	wholeWord  bool
+}
+
+func newLineMatcher(texts []string, ignoreCase, wholeWord bool) *lineMatcher {
+	m := &lineMatcher{ignoreCase: ignoreCase, wholeWord: wholeWord}
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		if ignoreCase {
+			text = string(bytes.ToLower([]byte(text)))
+		}
+		m.texts = append(m.texts, []byte(text))
+	}
+	return m
+}
+
+func (m *lineMatcher) matches(line []byte) bool {
+	if m.ignoreCase {
+		line = bytes.ToLower(line)
+	}
+	for _, text := range m.texts {
+		if m.wholeWord {
+			if containsWord(line, text) {
+				return true
+			}
+		} else if bytes.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWord reports whether text is in line with no word character right
+// before or after it
+func containsWord(line, text []byte) bool {
+	for start := 0; start <= len(line)-len(text); {
+		idx := bytes.Index(line[start:], text)
+		if idx < 0 {
+			return false
+		}
+		idx += start
+		end := idx + len(text)
+		if (idx == 0 || !isWordByte(line[idx-1])) && (end == len(line) || !isWordByte(line[end])) {
+			return true
+		}
+		start = idx + 1
+	}
+	return false
+}
+
+// lineSelector keeps the lines told by the matcher and drops the others from
+// an output. One is made for each output since it follows the lines longer
+// than transformLineLimit, split in pieces.
+type lineSelector struct {
+	matcher *lineMatcher
+	delim   byte
+	// partial is set while the pieces of a long line are filtered, they
+	// follow the decision taken on the first piece
+	partial bool
+	keep    bool
+}
+
+func newLineSelector(matcher *lineMatcher, delim byte) *lineSelector {
+	return &lineSelector{matcher: matcher, delim: delim}
+}
+
+func (s *lineSelector) filter(line []byte) []byte {
+	if !s.partial {
+		s.keep = len(s.matcher.texts) == 0 || s.matcher.matches(line)
+	}
+	s.partial = len(line) > 0 && line[len(line)-1] != s.delim
+	if !s.keep {
+		return nil
+	}
+	return line
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+const (
+	fingerprintSuffix  = ".fingerprint"
+	fingerprintVersion = 1
+	// fingerprintSample is how much of the beginning and of the end of each
+	// part is hashed, hashing whole parts would cost as much as merging them
+	fingerprintSample = 64 << 10
+)
+
+// groupFingerprint describes the inputs and the outputs of the last
+// successful merge of a group
+type groupFingerprint struct {
+	Version  int                 `json:"version"`
+	Settings string              `json:"settings"`
+	Parts    []partFingerprint   `json:"parts"`
+	Outputs  []outputFingerprint `json:"outputs"`
+}
+
+type partFingerprint struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Sample  string `json:"sample"`
+}
+
+type outputFingerprint struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// fingerprintPath is where the fingerprint of the group is kept, its name
+// does not contain .log so it is never scanned as a part
+func fingerprintPath(basepath, basename, suffix string) string {
+	return filepath.Join(basepath, basename+"."+outputSuffix(suffix)+fingerprintSuffix)
+}
+
+// outputSettings lists the options changing the content, the names or the
+// metadata of the outputs or of their sidecars, a change of any of them
+// requires a new merge. The salt is kept as a digest, the fingerprints are
+// readable by anyone reading the outputs.
+func outputSettings(options *Options) string {
+	var salt string
+	if options.AnonymizeSalt != "" {
+		digest := sha256.Sum256([]byte(options.AnonymizeSalt))
+		salt = hex.EncodeToString(digest[:])
+	}
+	return fmt.Sprint(options.newestFirst(), options.MaxChunks, options.Index, options.TimeIndex,
+		options.Histogram, options.HistogramFormat, options.ClusterErrors, options.ClusterTop,
+		options.LevelMap, options.Sign, options.AnonymizeIPs, salt,
+		options.DropFields, options.KeepFields, options.RecordDelimiter, options.Contains, options.IgnoreCase,
+		options.WordRegexp, options.Tag, options.TagPrefix, options.Combine, options.SplitOnMarker,
+		options.Header, options.IfExists, options.Suffix, options.TimestampedOutput, options.NameTemplate,
+		options.OutputMode, options.OutputOwner, options.PreserveMtime)
+}
+
+// computeFingerprint fingerprints the parts of list, in merge order
+func computeFingerprint(fsys fs.FS, basepath string, list []*logFile, settings string) (*groupFingerprint, error) {
+	fingerprint := &groupFingerprint{Version: fingerprintVersion, Settings: settings}
+	for _, part := range list {
+		sample, err := sampleHash(fsys, basepath, part.name, part.size)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint.Parts = append(fingerprint.Parts, partFingerprint{
+			Name:    part.name,
+			Size:    part.size,
+			ModTime: part.modTime.UnixNano(),
+			Sample:  sample,
+		})
+	}
+	return fingerprint, nil
+}
+
+// sampleHash hashes the beginning and the end of the file
+func sampleHash(fsys fs.FS, basepath, name string, size int64) (string, error) {
+	f, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, io.LimitReader(f, fingerprintSample)); err != nil {
+		return "", err
+	}
+	if size > fingerprintSample {
+		// the end, without the bytes already hashed
+		start := size - fingerprintSample
+		if start < fingerprintSample {
+			start = fingerprintSample
+		}
+		var end io.Reader
+		if at, ok := f.(io.ReaderAt); ok {
+			end = io.NewSectionReader(at, start, size-start)
+		} else if err := skipPart(f, start-fingerprintSample); err != nil {
+			return "", err
+		} else {
+			// the archived parts are read through
+			end = f
+		}
+		if _, err := io.Copy(digest, end); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// upToDate tells whether the previous merge had the same inputs and settings
+// and its outputs are still there, unchanged in size
+func (g *groupFingerprint) upToDate(basepath, basename, suffix string) bool {
+	data, err := ioutil.ReadFile(fingerprintPath(basepath, basename, suffix))
+	if err != nil {
+		return false
+	}
+	previous := &groupFingerprint{}
+	if err := json.Unmarshal(data, previous); err != nil || len(previous.Outputs) == 0 {
+		return false
+	}
+	if previous.Version != g.Version || previous.Settings != g.Settings || !reflect.DeepEqual(previous.Parts, g.Parts) {
+		return false
+	}
+	for _, output := range previous.Outputs {
+		info, err := os.Stat(filepath.Join(basepath, output.Name))
+		if err != nil || info.Size() != output.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// save records the fingerprint along with the outputs of the merge
+func (g *groupFingerprint) save(basepath, basename, suffix string, outputs []string) error {
+	g.Outputs = nil
+	for _, output := range outputs {
+		info, err := os.Stat(output)
+		if err != nil {
+			return err
+		}
+		g.Outputs = append(g.Outputs, outputFingerprint{Name: filepath.Base(output), Size: info.Size()})
+	}
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fingerprintPath(basepath, basename, suffix), append(data, '\n'), 0644)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerPrefix starts every line of the header block of the outputs
+const headerPrefix = "# "
+
+// newOutputHeader returns the header lines shared by all the outputs of the
+// run: the tool version, the run start time and the options, filters
+// included, so that an aggregate found later tells how it was made
+func newOutputHeader(options *Options, start time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%saggregatelogs %s (commit %s, built %s)\n", headerPrefix, version, commit, buildDate)
+	fmt.Fprintf(&b, "%sMerged: %s\n", headerPrefix, start.Format(time.RFC3339))
+	for _, line := range strings.Split(options.String(), "\n") {
+		b.WriteString(headerPrefix + line + "\n")
+	}
+	return b.String()
+}
+
+// outputHeader completes the header of the run with the parts merged into
+// one output
+func outputHeader(header string, list []*logFile) []byte {
+	var b bytes.Buffer
+	b.WriteString(header)
+	fmt.Fprintf(&b, "%sParts: %d\n", headerPrefix, len(list))
+	for _, part := range list {
+		fmt.Fprintf(&b, "%sPart: %s\n", headerPrefix, part.name)
+	}
+	return b.Bytes()
+}
+
+// headerSize returns the size of the header written by outputHeader at the
+// start of r, 0 when there is none. Only that block is recognized, the log
+// lines starting like the header lines are not part of it.
+func headerSize(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	var size int64
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		size += int64(len(line))
+		return line, err
+	}
+
+	line, err := readLine()
+	if err != nil || !strings.HasPrefix(line, headerPrefix+"aggregatelogs ") {
+		return 0, ignoreEOF(err)
+	}
+	parts := -1
+	for parts < 0 {
+		if line, err = readLine(); err != nil || !strings.HasPrefix(line, headerPrefix) {
+			return 0, ignoreEOF(err)
+		}
+		if count := strings.TrimPrefix(line, headerPrefix+"Parts: "); count != line {
+			if parts, err = strconv.Atoi(strings.TrimSpace(count)); err != nil {
+				return 0, nil
+			}
+		}
+	}
+	for ; parts > 0; parts-- {
+		if line, err = readLine(); err != nil || !strings.HasPrefix(line, headerPrefix+"Part: ") {
+			return 0, ignoreEOF(err)
+		}
+	}
+	return size, nil
+}
+
+// ignoreEOF drops the end of file errors of a block shorter than expected
+func ignoreEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
+
+// headerSkipper is implemented by the sidecars parsing the lines of the
+// outputs, told the size of the header at the start of the output so that
+// its lines are not taken for log lines
+type headerSkipper interface {
+	skipHeader(size int64)
+}
+
+// skipHeaders tells the sidecars able to skip it the size of the header
+func skipHeaders(sidecars []sidecarWriter, size int64) {
+	for _, sidecar := range sidecars {
+		if skipper, ok := sidecar.(headerSkipper); ok {
+			skipper.skipHeader(size)
+		}
+	}
+}
+
+// skipBytes drops what is left of the first skip bytes of a stream from p
+func skipBytes(p []byte, skip *int64) []byte {
+	if *skip <= 0 {
+		return p
+	}
+	n := int64(len(p))
+	if n > *skip {
+		n = *skip
+	}
+	*skip -= n
+	return p[n:]
+}
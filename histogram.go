@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	histogramSuffix = ".histogram"
+	// histogramMaxEmptyBuckets limits the empty buckets listed to show gaps
+	histogramMaxEmptyBuckets = 10000
+)
+
+// HistogramBucket counts the lines and bytes whose timestamp falls in the
+// bucket starting at Start, lines without a timestamp count in the bucket of
+// the previous line
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Lines int64     `json:"lines"`
+	Bytes int64     `json:"bytes"`
+}
+
+// histogramWriter builds the volume histogram of the bytes written to it
+type histogramWriter struct {
+	bucket    time.Duration
+	format    string
+	counts    map[int64]*HistogramBucket
+	current   *HistogramBucket
+	untimed   HistogramBucket
+	prefix    []byte
+	lineBytes int64
+	// header is what is left of the header of the output, not counted
+	header int64
+}
+
+func newHistogramWriter(bucket time.Duration, format string) *histogramWriter {
+	return &histogramWriter{
+		bucket: bucket,
+		format: format,
+		counts: make(map[int64]*HistogramBucket),
+		prefix: make([]byte, 0, timestampScanLimit),
+	}
+}
+
+func (h *histogramWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for _, c := range skipBytes(p, &h.header) {
+		h.lineBytes++
+		if c == '\n' {
+			h.endLine()
+			continue
+		}
+		if len(h.prefix) < timestampScanLimit {
+			h.prefix = append(h.prefix, c)
+		}
+	}
+	return n, nil
+}
+
+func (h *histogramWriter) skipHeader(size int64) {
+	h.header = size
+}
+
+func (h *histogramWriter) endLine() {
+	if ts, ok := ParseTimestamp(string(h.prefix)); ok {
+		start := ts.UTC().Truncate(h.bucket)
+		bucket, exists := h.counts[start.UnixNano()]
+		if !exists {
+			bucket = &HistogramBucket{Start: start}
+			h.counts[start.UnixNano()] = bucket
+		}
+		h.current = bucket
+	}
+	target := h.current
+	if target == nil {
+		target = &h.untimed
+	}
+	target.Lines++
+	target.Bytes += h.lineBytes
+	h.prefix = h.prefix[:0]
+	h.lineBytes = 0
+}
+
+// buckets returns the buckets in time order, including the empty buckets
+// between the first and the last so that gaps are visible
+func (h *histogramWriter) buckets() []HistogramBucket {
+	keys := make([]int64, 0, len(h.counts))
+	for key := range h.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var result []HistogramBucket
+	for idx, key := range keys {
+		if idx > 0 {
+			prev := result[len(result)-1].Start
+			missing := h.counts[key].Start.Sub(prev)/h.bucket - 1
+			if missing > 0 && missing <= histogramMaxEmptyBuckets {
+				for gap := int64(1); gap <= int64(missing); gap++ {
+					result = append(result, HistogramBucket{Start: prev.Add(time.Duration(gap) * h.bucket)})
+				}
+			}
+		}
+		result = append(result, *h.counts[key])
+	}
+	return result
+}
+
+func (h *histogramWriter) save(indexedPath string) error {
+	if h.lineBytes > 0 {
+		h.endLine()
+	}
+	path := indexedPath + histogramSuffix
+	if h.format == "json" {
+		path += ".json"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := h.writeTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (h *histogramWriter) writeTo(w io.Writer) error {
+	buckets := h.buckets()
+	if h.format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Bucket  string            `json:"bucket"`
+			Buckets []HistogramBucket `json:"buckets"`
+			Untimed HistogramBucket   `json:"untimed"`
+		}{h.bucket.String(), buckets, h.untimed})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "BUCKET (%v)\tLINES\tBYTES\n", h.bucket)
+	for _, bucket := range buckets {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", bucket.Start.Format(time.RFC3339), bucket.Lines, bucket.Bytes)
+	}
+	if h.untimed.Lines > 0 {
+		fmt.Fprintf(tw, "no timestamp\t%d\t%d\n", h.untimed.Lines, h.untimed.Bytes)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// indexBlockLines is the number of lines covered by each block of the
+	// trigram index, searches read whole blocks
+	indexBlockLines = 1024
+	indexFileSuffix = ".idx"
+	indexVersion    = 2
+)
+
+// aggregateOutputName matches the names of the outputs produced by the merge
+// with the given suffix, timestamped or not
+func aggregateOutputName(suffix string) *regexp.Regexp {
+	return regexp.MustCompile(`^[^.]+\.` + regexp.QuoteMeta(outputSuffix(suffix)) + `(\.\d{8}T\d{6})?(\.\d+)?\.log$`)
+}
+
+// aggregateFileName matches the outputs and the sidecars written next to them
+func aggregateFileName(suffix string) *regexp.Regexp {
+	return regexp.MustCompile(`^[^.]+\.` + regexp.QuoteMeta(outputSuffix(suffix)) + `(\.\d{8}T\d{6})?(\.\d+)?\.log(\.[^.]+)*$`)
+}
+
+// trigramIndex maps every trigram of the indexed file to the blocks of lines
+// containing it, so a search only reads the blocks that can match. The lines
+// end with Delimiter, the --record-delimiter of the merge.
+type trigramIndex struct {
+	Version     int
+	Size        int64
+	Delimiter   byte
+	BlockOffset []int64
+	BlockLine   []int64
+	Trigrams    map[uint32][]uint32
+}
+
+// trigramIndexer builds a trigramIndex from the bytes written to it
+type trigramIndexer struct {
+	index  *trigramIndex
+	offset int64
+	line   int64
+	prev   [2]byte
+	filled int
+}
+
+func newTrigramIndexer(delim byte) *trigramIndexer {
+	return &trigramIndexer{
+		index: &trigramIndex{
+			Version:     indexVersion,
+			Delimiter:   delim,
+			BlockOffset: []int64{0},
+			BlockLine:   []int64{0},
+			Trigrams:    make(map[uint32][]uint32),
+		},
+	}
+}
+
+func trigramKey(a, b, c byte) uint32 {
+	return uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+}
+
+func (t *trigramIndexer) Write(p []byte) (int, error) {
+	block := uint32(len(t.index.BlockOffset) - 1)
+	for i, c := range p {
+		if c == t.index.Delimiter {
+			t.line++
+			t.filled = 0
+			if t.line%indexBlockLines == 0 {
+				t.index.BlockOffset = append(t.index.BlockOffset, t.offset+int64(i)+1)
+				t.index.BlockLine = append(t.index.BlockLine, t.line)
+				block++
+			}
+			continue
+		}
+		if t.filled == 2 {
+			key := trigramKey(t.prev[0], t.prev[1], c)
+			blocks := t.index.Trigrams[key]
+			if len(blocks) == 0 || blocks[len(blocks)-1] != block {
+				t.index.Trigrams[key] = append(blocks, block)
+			}
+			t.prev[0], t.prev[1] = t.prev[1], c
+		} else {
+			t.prev[t.filled] = c
+			t.filled++
+		}
+	}
+	t.offset += int64(len(p))
+	return len(p), nil
+}
+
+// save writes the index next to the indexed file
+func (t *trigramIndexer) save(indexedPath string) error {
+	t.index.Size = t.offset
+	f, err := os.Create(indexedPath + indexFileSuffix)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := gob.NewEncoder(w).Encode(t.index); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadIndex reads the index of the file, it fails when the index is missing
+// or does not match the current size of the file
+func loadIndex(indexedPath string) (*trigramIndex, error) {
+	info, err := os.Stat(indexedPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(indexedPath + indexFileSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	index := &trigramIndex{}
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(index); err != nil {
+		return nil, err
+	}
+	if index.Version != indexVersion || index.Size != info.Size() {
+		return nil, errStaleIndex
+	}
+	return index, nil
+}
+
+var errStaleIndex = errors.New("index does not match the indexed file")
+
+// candidateBlocks returns the blocks that contain every trigram of the query,
+// ok is false when the query is too short to use the index
+func (idx *trigramIndex) candidateBlocks(query string) ([]uint32, bool) {
+	if len(query) < 3 {
+		return nil, false
+	}
+	var result []uint32
+	for i := 0; i+3 <= len(query); i++ {
+		blocks := idx.Trigrams[trigramKey(query[i], query[i+1], query[i+2])]
+		if i == 0 {
+			result = append([]uint32{}, blocks...)
+		} else {
+			result = intersectBlocks(result, blocks)
+		}
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result, true
+}
+
+func intersectBlocks(a, b []uint32) []uint32 {
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+// SearchMatch is a line containing the searched text
+type SearchMatch struct {
+	File string
+	Line int64
+	Text string
+}
+
+// SearchFile reports every line of the file containing the query, reading
+// only the candidate blocks when a valid index is available. The lines end
+// with delim, or with the delimiter the index was built with.
+func SearchFile(path, query string, useIndex bool, delim byte, emit func(SearchMatch)) error {
+	f, err := openPart(nil, "", path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the indexed aggregates are never compressed
+	if at, ok := f.(io.ReaderAt); ok && useIndex {
+		if index, err := loadIndex(path); err == nil {
+			if blocks, ok := index.candidateBlocks(query); ok {
+				return searchBlocks(at, path, query, index, blocks, emit)
+			}
+		}
+	}
+	return searchReader(f, path, query, 0, delim, emit)
+}
+
+func searchBlocks(f io.ReaderAt, path, query string, index *trigramIndex, blocks []uint32, emit func(SearchMatch)) error {
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	for _, block := range blocks {
+		start := index.BlockOffset[block]
+		end := index.Size
+		if int(block)+1 < len(index.BlockOffset) {
+			end = index.BlockOffset[block+1]
+		}
+		section := io.NewSectionReader(f, start, end-start)
+		if err := searchReader(section, path, query, index.BlockLine[block], index.Delimiter, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func searchReader(r io.Reader, path, query string, firstLine int64, delim byte, emit func(SearchMatch)) error {
+	reader := bufio.NewReader(r)
+	line := firstLine
+	for {
+		text, err := reader.ReadString(delim)
+		if len(text) > 0 {
+			line++
+			if strings.Contains(text, query) {
+				text = strings.TrimSuffix(text, string([]byte{delim}))
+				emit(SearchMatch{File: path, Line: line, Text: strings.TrimRight(text, "\r\n")})
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
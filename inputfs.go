@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+)
+
+// openInput returns the tree the parts of the input path are read from, nil
+// for the operating system, the directory the outputs are in and the
+// function releasing the tree. A support bundle is read from the archive,
+// its outputs are next to it.
+func openInput(input string) (fs.FS, string, func(), error) {
+	if !isArchive(input) {
+		return nil, input, func() {}, nil
+	}
+	fsys, release, err := openArchive(input)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("opening archive: %v", err)
+	}
+	return fsys, filepath.Dir(input), release, nil
+}
+
+// scanInput lists the parts of fsys, or of the basepath directory when nil
+func scanInput(fsys fs.FS, basepath string, scan *scanOptions) (FilesList, error) {
+	if fsys != nil {
+		return ScanFS(fsys, scan)
+	}
+	return ScanFolder(flags.Filename(basepath), scan)
+}
+
+// openPart opens the part name of basepath, from fsys when not nil or from
+// the operating system otherwise. The parts compressed with gzip, as by the
+// compress directive of logrotate, are read decompressed.
+func openPart(fsys fs.FS, basepath, name string) (fs.File, error) {
+	open := func() (fs.File, error) {
+		if fsys == nil {
+			return os.Open(filepath.Join(basepath, name))
+		}
+		return fsys.Open(name)
+	}
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	// the start is read again from a new file when it cannot be read in place
+	magic := make([]byte, len(gzipMagic))
+	if at, ok := f.(io.ReaderAt); ok {
+		_, err = at.ReadAt(magic, 0)
+	} else {
+		_, _ = io.ReadFull(f, magic)
+		_ = f.Close()
+		if f, err = open(); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &gzipPart{File: f, zr: zr}, nil
+}
+
+// partSize is the current size of the part as stored, compressed or not
+func partSize(fsys fs.FS, basepath, name string) (int64, error) {
+	var info fs.FileInfo
+	var err error
+	if fsys == nil {
+		info, err = os.Stat(filepath.Join(basepath, name))
+	} else {
+		info, err = fs.Stat(fsys, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// gzipMagic starts the gzip streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gzipPart is a compressed part read decompressed, its size is the
+// compressed one
+type gzipPart struct {
+	fs.File
+	zr *gzip.Reader
+}
+
+func (g *gzipPart) Read(p []byte) (int, error) {
+	return g.zr.Read(p)
+}
+
+func (g *gzipPart) Close() error {
+	_ = g.zr.Close()
+	return g.File.Close()
+}
+
+// skipPart moves past the first offset bytes of the part, seeking when the
+// file allows it
+func skipPart(f fs.File, offset int64) error {
+	if offset <= 0 {
+		return nil
+	}
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, f, offset)
+	return err
+}
+
+// newLogFile describes the part, the last number of the name orders it
+func newLogFile(info fs.FileInfo) *logFile {
+	def := &logFile{
+		name:    info.Name(),
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+	parts := strings.Split(info.Name(), ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		if idx, err := strconv.Atoi(parts[i]); err == nil {
+			def.index = idx
+			break
+		}
+	}
+	return def
+}
+
+// ScanFS lists the parts in the root directory of fsys like ScanFolder, the
+// symlinks are left to fsys
+func ScanFS(fsys fs.FS, scan *scanOptions) (FilesList, error) {
+	filesMap := make(FilesList)
+	outputName := scan.names.filePattern()
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return filesMap, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !scan.admits(entry.Name(), outputName) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if scan.onError == nil {
+				return filesMap, err
+			}
+			if err := scan.onError(entry.Name(), err); err != nil {
+				return filesMap, err
+			}
+			continue
+		}
+		if !scan.selects(info) {
+			log.Debugln("Skipping by size or age: ", info.Name())
+			continue
+		}
+		log.Debugln("Found: ", info.Name())
+		base := groupName(info.Name())
+		filesMap[base] = append(filesMap[base], newLogFile(info))
+	}
+	return filesMap, nil
+}
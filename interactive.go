@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const interactiveHelp = `Commands:
+  <n>      toggle group n
+  a / none select all / no groups
+  p <n>    preview the merge order of group n
+  d        toggle deletion of the original files
+  m        merge the selected groups
+  q        quit without doing anything
+`
+
+// RunInteractive shows the discovered groups on out and reads commands from
+// in, letting the user choose which groups are merged and whether their
+// parts are deleted. It returns the selected groups, the delete choice and
+// false when the user quit without confirming. in is left positioned after
+// the confirmation, so it can be read again for the delete confirmations.
+func RunInteractive(in *bufio.Scanner, out io.Writer, allFiles FilesList, deleteFiles, newestFirst bool) (FilesList, bool, bool) {
+	names := make([]string, 0, len(allFiles))
+	for name := range allFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	printGroups := func() {
+		fmt.Fprintln(out)
+		for idx, name := range names {
+			mark := " "
+			if selected[name] {
+				mark = "x"
+			}
+			var size int64
+			for _, part := range allFiles[name] {
+				size += part.size
+			}
+			fmt.Fprintf(out, "[%s] %d) %s - %d parts, %s\n", mark, idx+1, name, len(allFiles[name]), formatBytes(size))
+		}
+		fmt.Fprintf(out, "Delete originals after merge: %v\n", deleteFiles)
+		fmt.Fprint(out, "> ")
+	}
+	groupAt := func(arg string) (string, bool) {
+		idx, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil || idx < 1 || idx > len(names) {
+			fmt.Fprintf(out, "No group with number %q\n", arg)
+			return "", false
+		}
+		return names[idx-1], true
+	}
+
+	fmt.Fprint(out, interactiveHelp)
+	printGroups()
+	for in.Scan() {
+		line := strings.TrimSpace(in.Text())
+		switch {
+		case line == "":
+		case line == "q":
+			return nil, deleteFiles, false
+		case line == "a":
+			for _, name := range names {
+				selected[name] = true
+			}
+		case line == "none":
+			for _, name := range names {
+				selected[name] = false
+			}
+		case line == "d":
+			deleteFiles = !deleteFiles
+		case strings.HasPrefix(line, "p "):
+			name, ok := groupAt(line[2:])
+			if !ok {
+				break
+			}
+			list := append([]*logFile{}, allFiles[name]...)
+			SortLogList(list, newestFirst)
+			fmt.Fprintf(out, "Merge order of %s:\n", name)
+			for idx, part := range list {
+				fmt.Fprintf(out, "  %d. %s (%s)\n", idx+1, part.name, formatBytes(part.size))
+			}
+		case line == "m":
+			result := make(FilesList)
+			for _, name := range names {
+				if selected[name] {
+					result[name] = allFiles[name]
+				}
+			}
+			action := "merge"
+			if deleteFiles {
+				action = "merge and DELETE the originals of"
+			}
+			fmt.Fprintf(out, "About to %s %d groups, confirm? [y/N] ", action, len(result))
+			if in.Scan() && strings.EqualFold(strings.TrimSpace(in.Text()), "y") {
+				return result, deleteFiles, true
+			}
+		default:
+			if name, ok := groupAt(line); ok {
+				selected[name] = !selected[name]
+			}
+		}
+		printGroups()
+	}
+	// input closed before confirmation
+	return nil, deleteFiles, false
+}
+
+// deleteConfirmer asks on out, reading the answers from in, before the
+// originals of each group are deleted. Groups are merged concurrently, so
+// the questions are asked one at a time.
+type deleteConfirmer struct {
+	mu      sync.Mutex
+	scanner *bufio.Scanner
+	out     io.Writer
+	// answer is set when the user answered for all the remaining groups
+	answer *bool
+}
+
+// newDeleteConfirmer reads the answers from in, which is shared with the
+// interactive session so that neither reads ahead the input of the other
+func newDeleteConfirmer(in *bufio.Scanner, out io.Writer) *deleteConfirmer {
+	return &deleteConfirmer{scanner: in, out: out}
+}
+
+// confirm lists the files of the group that would be deleted and returns
+// whether the user agreed, closed input means no
+func (c *deleteConfirmer) confirm(name string, list []*logFile) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.answer != nil {
+		return *c.answer
+	}
+
+	fmt.Fprintf(c.out, "\nOriginals of %s to delete:\n", name)
+	var size int64
+	for _, part := range list {
+		fmt.Fprintf(c.out, "  %s (%s)\n", part.name, formatBytes(part.size))
+		size += part.size
+	}
+	fmt.Fprintf(c.out, "Delete these %d files, %s? [y/N/a(ll)/q(uit)] ", len(list), formatBytes(size))
+	if !c.scanner.Scan() {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(c.scanner.Text())) {
+	case "y":
+		return true
+	case "a":
+		all := true
+		c.answer = &all
+		return true
+	case "q":
+		none := false
+		c.answer = &none
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	req "github.com/stretchr/testify/require"
+)
+
+func init() {
+	AllTestSuites = append(AllTestSuites, &InteractiveSuite{})
+}
+
+type InteractiveSuite struct {
+	BaseSuite
+}
+
+func (s *InteractiveSuite) files() FilesList {
+	return FilesList{
+		"api": {{index: 1, name: "api.log.1", size: 10}, {index: 2, name: "api.log.2", size: 20}},
+		"web": {{index: 0, name: "web.log", size: 30}},
+	}
+}
+
+func (s *InteractiveSuite) TestToggleAndConfirm() {
+	var out bytes.Buffer
+	in := bufio.NewScanner(strings.NewReader("2\np 1\nd\nm\ny\n"))
+
+	selected, deleteFiles, confirmed := RunInteractive(in, &out, s.files(), false, false)
+	req.True(s.T(), confirmed, "Merge was not confirmed")
+	req.True(s.T(), deleteFiles, "Delete toggle was not applied")
+	req.Len(s.T(), selected, 1, "Deselected group was returned")
+	req.Contains(s.T(), selected, "api")
+	req.Contains(s.T(), out.String(), "1. api.log.2", "Preview did not show the merge order")
+}
+
+func (s *InteractiveSuite) TestQuitAndDecline() {
+	_, _, confirmed := RunInteractive(bufio.NewScanner(strings.NewReader("q\n")), &bytes.Buffer{}, s.files(), false, false)
+	req.False(s.T(), confirmed, "Quit was treated as confirmation")
+
+	_, _, confirmed = RunInteractive(bufio.NewScanner(strings.NewReader("m\nn\n")), &bytes.Buffer{}, s.files(), false, false)
+	req.False(s.T(), confirmed, "Declined merge was treated as confirmation")
+}
+
+func (s *InteractiveSuite) TestConfirmDelete() {
+	files := s.files()
+	var out bytes.Buffer
+	confirmer := newDeleteConfirmer(bufio.NewScanner(strings.NewReader("y\n\na\n")), &out)
+	req.True(s.T(), confirmer.confirm("api", files["api"]))
+	req.Contains(s.T(), out.String(), "  api.log.2 (20 B)", "Files to delete were not listed")
+	req.False(s.T(), confirmer.confirm("web", files["web"]), "Empty answer was taken as yes")
+	req.True(s.T(), confirmer.confirm("api", files["api"]))
+	req.True(s.T(), confirmer.confirm("web", files["web"]), "Answer for all was not remembered")
+
+	confirmer = newDeleteConfirmer(bufio.NewScanner(strings.NewReader("q\n")), &bytes.Buffer{})
+	req.False(s.T(), confirmer.confirm("api", files["api"]))
+	req.False(s.T(), confirmer.confirm("web", files["web"]), "Quit did not decline the remaining groups")
+
+	confirmer = newDeleteConfirmer(bufio.NewScanner(strings.NewReader("")), &bytes.Buffer{})
+	req.False(s.T(), confirmer.confirm("api", files["api"]), "Closed input was taken as yes")
+}
+
+func (s *InteractiveSuite) TestSharedInput() {
+	// piped answers for the session and then for the deletion
+	in := bufio.NewScanner(strings.NewReader("d\nm\ny\ny\n"))
+	selected, deleteFiles, confirmed := RunInteractive(in, &bytes.Buffer{}, s.files(), false, false)
+	req.True(s.T(), confirmed)
+	req.True(s.T(), deleteFiles)
+	confirmer := newDeleteConfirmer(in, &bytes.Buffer{})
+	req.True(s.T(), confirmer.confirm("api", selected["api"]), "Answer to the deletion was lost")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logrotateStanza holds the directives of a logrotate stanza that decide
+// the names and the location of the rotated files
+type logrotateStanza struct {
+	Paths       []string
+	Olddir      string
+	Compress    bool
+	CompressExt string
+	DateExt     bool
+	Extension   string
+}
+
+// loadLogrotate reads the first stanza of the logrotate configuration file
+func loadLogrotate(path string) (*logrotateStanza, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stanza, err := parseLogrotate(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return stanza, nil
+}
+
+// parseLogrotate parses the first stanza of a logrotate configuration, the
+// global directives before it are applied to it like logrotate does
+func parseLogrotate(r io.Reader) (*logrotateStanza, error) {
+	stanza := &logrotateStanza{CompressExt: ".gz"}
+	inStanza, inScript := false, false
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		// the scripts can contain anything until endscript
+		if inScript {
+			inScript = fields[0] != "endscript"
+			continue
+		}
+
+		switch {
+		case !inStanza && strings.HasSuffix(line, "{"):
+			paths := strings.Fields(strings.TrimSuffix(line, "{"))
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("line %d: stanza without paths", lineNo)
+			}
+			for _, path := range paths {
+				stanza.Paths = append(stanza.Paths, strings.Trim(path, `"`))
+			}
+			inStanza = true
+		case inStanza && line == "}":
+			return stanza, nil
+		default:
+			switch fields[0] {
+			case "compress":
+				stanza.Compress = true
+			case "nocompress":
+				stanza.Compress = false
+			case "compressext":
+				if len(fields) < 2 {
+					return nil, fmt.Errorf("line %d: compressext without extension", lineNo)
+				}
+				stanza.CompressExt = fields[1]
+			case "dateext":
+				stanza.DateExt = true
+			case "nodateext":
+				stanza.DateExt = false
+			case "extension":
+				if len(fields) < 2 {
+					return nil, fmt.Errorf("line %d: extension without value", lineNo)
+				}
+				stanza.Extension = fields[1]
+			case "olddir":
+				if len(fields) < 2 {
+					return nil, fmt.Errorf("line %d: olddir without directory", lineNo)
+				}
+				stanza.Olddir = strings.Trim(fields[1], `"`)
+			case "noolddir":
+				stanza.Olddir = ""
+			case "prerotate", "postrotate", "firstaction", "lastaction", "preremove":
+				inScript = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !inStanza {
+		return nil, fmt.Errorf("no stanza found")
+	}
+	return nil, fmt.Errorf("stanza of %s is not closed", strings.Join(stanza.Paths, " "))
+}
+
+// inputDir is where the rotated files are, olddir when set
+func (st *logrotateStanza) inputDir() string {
+	logDir := filepath.Dir(st.Paths[0])
+	for _, path := range st.Paths[1:] {
+		if filepath.Dir(path) != logDir {
+			log.Warnf("logrotate stanza spans several directories, using %s\n", logDir)
+			break
+		}
+	}
+	if st.Olddir == "" {
+		return logDir
+	}
+	if filepath.IsAbs(st.Olddir) {
+		return st.Olddir
+	}
+	return filepath.Join(logDir, st.Olddir)
+}
+
+// partPattern matches the rotated files of the stanza and the live ones,
+// compressed or not
+func (st *logrotateStanza) partPattern() *regexp.Regexp {
+	rotation := `\.\d+`
+	if st.DateExt {
+		// dateformat is free form, it starts with a separator and a digit
+		rotation = `[-_.]\d[\d_.-]*`
+	}
+
+	var alternatives []string
+	for _, path := range st.Paths {
+		name := globToRegexp(filepath.Base(path))
+		alternative := name + `(` + rotation + `)?`
+		if st.Extension != "" && strings.HasSuffix(filepath.Base(path), st.Extension) {
+			// the rotation goes before the extension, app.log -> app.1.log
+			stem := globToRegexp(strings.TrimSuffix(filepath.Base(path), st.Extension))
+			alternative = `(` + alternative + `|` + stem + rotation + regexp.QuoteMeta(st.Extension) + `)`
+		}
+		alternatives = append(alternatives, alternative)
+	}
+	return regexp.MustCompile(`^(` + strings.Join(alternatives, "|") + `)(` + regexp.QuoteMeta(st.CompressExt) + `)?$`)
+}
+
+// globToRegexp turns the * and ? wildcards of a logrotate path into a
+// regexp, the rest is literal
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(`[^/]*`)
+		case '?':
+			b.WriteString(`[^/]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
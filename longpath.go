@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// extendedPath prefixes the absolute Windows path with \\?\, or \\?\UNC\ for
+// a share, so that the paths made from it are not limited to MAX_PATH
+func extendedPath(abs string) string {
+	switch {
+	case strings.HasPrefix(abs, `\\?\`), strings.HasPrefix(abs, `\\.\`):
+		return abs
+	case strings.HasPrefix(abs, `\\`):
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "path/filepath"
+
+// longPath returns path made absolute in its extended form. The os package
+// extends the long drive paths it opens but not the UNC ones, and a path
+// under MAX_PATH can make ones over it once the names are joined to it.
+func longPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return extendedPath(abs)
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -19,32 +25,246 @@ import (
 )
 
 type Options struct {
-	Input     flags.Filename `short:"i" long:"input" description:"Input file" default:"."`
-	Reverse   bool           `short:"n" long:"Reverse" description:"Reverse numerical order of found files"`
-	Delete    bool           `short:"d" long:"delete" description:"Delete original files'"`
-	MaxChunks int            `short:"c" long:"max-chunks" description:"Max chunks to merge, default 0 means merge all'" default:"0"`
+	Input             flags.Filename `short:"i" long:"input" description:"Input directory, or a .zip, .tar or .tar.gz support bundle whose content is merged next to it" default:"." completion:"directory"`
+	Reverse           bool           `short:"n" long:"Reverse" description:"Reverse numerical order of found files, same as --order desc"`
+	Order             string         `long:"order" description:"Merge order of the parts: asc from the oldest rotation to the live file, desc from the live file to the oldest rotation" choice:"asc" choice:"desc"`
+	Delete            bool           `short:"d" long:"delete" description:"Delete original files'"`
+	MaxChunks         int            `short:"c" long:"max-chunks" description:"Max chunks to merge, default 0 means merge all'" default:"0"`
+	LogFormat         string         `long:"log-format" description:"Format of the tool's own log messages" choice:"text" choice:"json" default:"text"`
+	LogLevel          string         `long:"log-level" description:"Minimum level of the tool's own log messages" choice:"trace" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+	Quiet             bool           `short:"q" long:"quiet" description:"Only log errors, overrides --log-level"`
+	Verbose           bool           `short:"v" long:"verbose" description:"Log every discovered file and per-file timings, overrides --log-level"`
+	Version           bool           `long:"version" description:"Print version and build information and exit"`
+	FollowSymlinks    bool           `long:"follow-symlinks" description:"Resolve the input path and merge the symlinked parts, a link to a part already merged is skipped"`
+	IncludeHidden     bool           `long:"include-hidden" description:"Also merge the dotfiles, the editor temporary files (~, .swp) and the unfinished downloads (.part, .tmp)"`
+	MinSize           ByteSize       `long:"min-size" description:"Only merge the parts of at least this size, e.g. 1KB"`
+	MaxSizeInput      ByteSize       `long:"max-size-input" description:"Only merge the parts of at most this size, e.g. 10GB"`
+	MinAge            time.Duration  `long:"min-age" description:"Only merge the parts last modified at least this long ago, e.g. 24h"`
+	MaxAge            time.Duration  `long:"max-age" description:"Only merge the parts last modified at most this long ago, e.g. 720h"`
+	FromDate          string         `long:"from-date" description:"Only merge the parts with a date in their name from this day, e.g. 2021-03-01, the parts without one are left out"`
+	ToDate            string         `long:"to-date" description:"Only merge the parts with a date in their name up to this day included, the parts without one are left out"`
+	Logrotate         flags.Filename `long:"logrotate" description:"Merge the files rotated by this logrotate configuration, taking the input path, the names and the olddir from its first stanza"`
+	Combine           []string       `long:"combine" description:"Merge the groups of these base names into one output named after the target, e.g. 'api,worker=>platform', their parts interleaved by modification time, can be repeated"`
+	Interactive       bool           `long:"interactive" description:"Choose the groups to merge and confirm the merge/delete interactively"`
+	Parallel          int            `long:"parallel" description:"Number of base name groups merged concurrently" default:"1"`
+	Workers           int            `long:"workers" description:"Number of parts read or deleted concurrently across all the groups, bounds the open files" default:"64"`
+	MaxMemory         ByteSize       `long:"max-memory" description:"Memory used to buffer parts (e.g. 512MB), larger parts are streamed, default 0 means unlimited" default:"0"`
+	WriteBuffer       ByteSize       `long:"write-buffer" description:"Size of the output write buffer" default:"1MB"`
+	ReadBuffer        ByteSize       `long:"read-buffer" description:"Size of the read buffer used when streaming parts" default:"256KB"`
+	SpaceFactor       float64        `long:"space-factor" description:"Safety factor applied to the input size when checking the free disk space, 0 disables the check" default:"1.1"`
+	BwLimit           Rate           `long:"bwlimit" description:"Limit read and write throughput each to this rate (e.g. 50MB/s), default 0 means unlimited" default:"0"`
+	Index             bool           `long:"index" description:"Write a trigram index next to each output to speed up the search subcommand"`
+	TimeIndex         bool           `long:"time-index" description:"Write a sparse timestamp index next to each output to speed up the extract subcommand"`
+	Histogram         time.Duration  `long:"histogram" description:"Write a per time bucket line and byte count next to each output, e.g. 1m or 1h"`
+	HistogramFormat   string         `long:"histogram-format" description:"Format of the histogram report" choice:"text" choice:"json" default:"text"`
+	ClusterErrors     bool           `long:"cluster-errors" description:"Write the most recurring error messages, grouped by template, next to each output"`
+	ClusterTop        int            `long:"cluster-top" description:"Number of error templates reported by --cluster-errors" default:"10"`
+	LevelMap          LevelMap       `long:"level-map" description:"Map severity labels, e.g. WARNING=WARN,SEVERE=ERROR, used by --cluster-errors and the stats subcommand"`
+	Sign              flags.Filename `long:"sign" description:"Write a detached signature of each output made with this PEM private key (RSA, ECDSA or Ed25519)"`
+	AnonymizeIPs      bool           `long:"anonymize-ips" description:"Zero the last octet of the IPv4 addresses and all but the /48 prefix of the IPv6 ones in the outputs"`
+	AnonymizeSalt     string         `long:"anonymize-salt" description:"With --anonymize-ips, replace the addresses with a hash keyed by this salt instead"`
+	DropFields        []string       `long:"drop-fields" description:"Remove these comma separated fields from the JSON and logfmt lines of the outputs"`
+	KeepFields        []string       `long:"keep-fields" description:"Remove all but these comma separated fields from the JSON and logfmt lines of the outputs"`
+	RecordDelimiter   Delimiter      `long:"record-delimiter" description:"Character ending the records of the parts, e.g. \\0 for NUL terminated records, used by --contains, --tag, --drop-fields, --keep-fields, --split-on-marker, --index and search instead of the newline"`
+	Contains          []string       `long:"contains" description:"Only write the lines containing this text, matched as is and not as a regexp, can be repeated to keep the lines containing any of them"`
+	IgnoreCase        bool           `long:"ignore-case" description:"Match the --contains texts regardless of case"`
+	WordRegexp        bool           `long:"word-regexp" description:"Match the --contains texts only as whole words, not as part of a longer word"`
+	Preview           int            `long:"preview" description:"Print the first N lines of each group containing each --contains text, and the first N the filter drops, then exit"`
+	Count             bool           `long:"count" description:"Print how many lines of each part contain each --contains text, and how many the filter keeps, then exit"`
+	Color             bool           `long:"color" description:"Highlight the --contains texts and the severities of the lines written to a terminal by cat and --tee -"`
+	Tag               []string       `long:"tag" description:"Add this key=value tag as a field of the JSON lines of the outputs, e.g. host=web-01, can be repeated"`
+	TagPrefix         bool           `long:"tag-prefix" description:"With --tag, also prefix the other lines with the tags, host=web-01 env=prod ..."`
+	SkipErrors        bool           `long:"skip-errors" description:"Skip the files that cannot be read, report them in the run summary and keep merging. A file failing after part of it was written fails its whole chunk"`
+	Strict            bool           `long:"strict" description:"Stop at the first file that cannot be read, the default unless --skip-errors"`
+	Retries           int            `long:"retries" description:"Retry the reads and writes failing with a transient error this many times" default:"0"`
+	RetryBackoff      time.Duration  `long:"retry-backoff" description:"Wait before the first retry, doubled after each one" default:"500ms"`
+	Timeout           time.Duration  `long:"timeout" description:"Fail the parts not read before this time from the start of the run, e.g. 2h"`
+	FileTimeout       time.Duration  `long:"file-timeout" description:"Fail the parts not read within this time, e.g. 10m"`
+	ChunkTimeout      time.Duration  `long:"chunk-timeout" description:"Fail the parts of a chunk not merged within this time from the start of the chunk, e.g. 30m"`
+	KeepPartial       bool           `long:"keep-partial" description:"Keep the output of a failed chunk instead of removing what it wrote"`
+	UntilQuiet        time.Duration  `long:"until-quiet" description:"Before merging, wait for the newest part of each group to stop growing for this time, or to be rotated, e.g. 30s, waiting at most --timeout or 10 times this time"`
+	IfExists          string         `long:"if-exists" description:"What to do with an output left by a previous run" choice:"fail" choice:"overwrite" choice:"append" choice:"rename" default:"overwrite"`
+	Trash             bool           `long:"trash" description:"With --delete or --delete-empty, move the files to a trash directory instead of removing them"`
+	TrashDir          flags.Filename `long:"trash-dir" description:"Trash directory, default .trash inside the input path" completion:"directory"`
+	TrashTTL          time.Duration  `long:"trash-ttl" description:"Purge the trashed runs older than this, 0 keeps them forever" default:"168h"`
+	InteractiveDelete bool           `long:"interactive-delete" description:"With --delete, list the originals of each group and ask before deleting them"`
+	Estimate          bool           `long:"estimate" description:"Print the outputs each group would produce, their size and the expected duration, then exit"`
+	SkipUnchanged     bool           `long:"skip-unchanged" description:"Skip the groups whose parts and settings did not change since their last successful merge, if the outputs are still there"`
+	DeleteEmpty       bool           `long:"delete-empty" description:"Delete the empty parts instead of merging them, honoring --trash"`
+	SessionMarker     string         `long:"session-marker" description:"Report the application runs of each group in the run summary, each starting at a line containing this text"`
+	SessionGap        time.Duration  `long:"session-gap" description:"Report the application runs of each group in the run summary, a new one starting after a gap this long between timestamps, e.g. 10m"`
+	CheckOrder        bool           `long:"check-order" description:"Report the backwards timestamp jumps of each merged group in the run summary"`
+	Tee               []string       `long:"tee" description:"Also write the merged stream to this sink: - for stdout, tcp://host:port or a file path, can be repeated"`
+	ReplayRate        LineRate       `long:"replay-rate" description:"Send at most this many lines to the tee sinks, e.g. 1000/s, the merge goes at the same pace"`
+	ReplayRealtime    bool           `long:"replay-realtime" description:"Send the lines to the tee sinks spaced like their timestamps, the merge goes at the same pace"`
+	Suffix            string         `long:"suffix" description:"Marker of the output names, basename.<suffix>.log" default:"full"`
+	TimestampedOutput bool           `long:"timestamped-output" description:"Embed the run start time in the output names, basename.full.<time>.log, so each run keeps its own outputs"`
+	NameTemplate      string         `long:"name-template" description:"Template of the output names, e.g. '{{.Base}}-{{.Date}}-merged{{.Chunk}}.log', with .Base, .Chunk, .Date, .Time, .Host and .Suffix"`
+	Preallocate       bool           `long:"preallocate" description:"Reserve the space of each output before writing it, to limit fragmentation and fail early when the disk is full"`
+	SplitOnMarker     string         `long:"split-on-marker" description:"Start a new output at every line containing this text, e.g. 'Server started', for one output per application run"`
+	Fsync             string         `long:"fsync" description:"When the outputs are synced to disk: after every part, at the end of each output or never, leaving it to the OS" choice:"always" choice:"end" choice:"never" default:"end"`
+	OutputMode        FileMode       `long:"output-mode" description:"Permissions of the outputs and of their sidecars, in octal, e.g. 0640"`
+	OutputOwner       string         `long:"output-owner" description:"Owner of the outputs and of their sidecars, user[:group], needs root"`
+	Header            bool           `long:"header" description:"Start each new output with a block of lines beginning with #, holding the tool version, the run time, the options and the merged parts"`
+	PreserveMtime     bool           `long:"preserve-mtime" description:"Set the mtime of each output to the newest mtime of its parts"`
+	CPUProfile        flags.Filename `long:"cpuprofile" description:"Write a cpu profile to this file"`
+	MemProfile        flags.Filename `long:"memprofile" description:"Write a memory profile to this file at the end of the run"`
+	Trace             flags.Filename `long:"trace" description:"Write an execution trace to this file"`
 }
 
 const (
-	optionsFormat       = "[Config]\nInput: %v\nReverse: %v\nDelete: %v\nMaxChunks: %v"
+	defaultWriteBuffer = 1 << 20
+	defaultReadBuffer  = 256 << 10
+)
+
+const (
+	optionsFormat       = "[Config]\nInput: %v\nReverse: %v\nOrder: %v\nDelete: %v\nMaxChunks: %v\nLogFormat: %v\nLogLevel: %v\nQuiet: %v\nVerbose: %v\nFollowSymlinks: %v\nIncludeHidden: %v\nMinSize: %v\nMaxSizeInput: %v\nMinAge: %v\nMaxAge: %v\nFromDate: %v\nToDate: %v\nLogrotate: %v\nCombine: %v\nInteractive: %v\nParallel: %v\nWorkers: %v\nMaxMemory: %v\nWriteBuffer: %v\nReadBuffer: %v\nSpaceFactor: %v\nBwLimit: %v\nIndex: %v\nTimeIndex: %v\nHistogram: %v\nClusterErrors: %v\nLevelMap: %v\nSign: %v\nAnonymizeIPs: %v\nDropFields: %v\nKeepFields: %v\nRecordDelimiter: %v\nContains: %v\nIgnoreCase: %v\nWordRegexp: %v\nPreview: %v\nCount: %v\nColor: %v\nTag: %v\nTagPrefix: %v\nSkipErrors: %v\nRetries: %v\nTimeout: %v\nFileTimeout: %v\nChunkTimeout: %v\nKeepPartial: %v\nUntilQuiet: %v\nIfExists: %v\nTrash: %v\nInteractiveDelete: %v\nEstimate: %v\nDeleteEmpty: %v\nSkipUnchanged: %v\nCheckOrder: %v\nSessionMarker: %v\nSessionGap: %v\nTee: %v\nReplayRate: %v\nReplayRealtime: %v\nSuffix: %v\nTimestampedOutput: %v\nNameTemplate: %v\nPreallocate: %v\nSplitOnMarker: %v\nFsync: %v\nOutputMode: %v\nOutputOwner: %v\nHeader: %v\nPreserveMtime: %v"
 	aggregatedLogSuffix = "full"
 )
 
 func (o Options) String() string {
-	return fmt.Sprintf(optionsFormat, o.Input, o.Reverse, o.Delete, o.MaxChunks)
+	return fmt.Sprintf(optionsFormat, o.Input, o.Reverse, o.Order, o.Delete, o.MaxChunks, o.LogFormat, o.LogLevel, o.Quiet, o.Verbose, o.FollowSymlinks, o.IncludeHidden, o.MinSize, o.MaxSizeInput, o.MinAge, o.MaxAge, o.FromDate, o.ToDate, o.Logrotate, o.Combine, o.Interactive, o.Parallel, o.Workers, o.MaxMemory, o.WriteBuffer, o.ReadBuffer, o.SpaceFactor, o.BwLimit, o.Index, o.TimeIndex, o.Histogram, o.ClusterErrors, o.LevelMap, o.Sign, o.AnonymizeIPs, o.DropFields, o.KeepFields, o.RecordDelimiter, o.Contains, o.IgnoreCase, o.WordRegexp, o.Preview, o.Count, o.Color, o.Tag, o.TagPrefix, o.SkipErrors, o.Retries, o.Timeout, o.FileTimeout, o.ChunkTimeout, o.KeepPartial, o.UntilQuiet, o.IfExists, o.Trash, o.InteractiveDelete, o.Estimate, o.DeleteEmpty, o.SkipUnchanged, o.CheckOrder, o.SessionMarker, o.SessionGap, o.Tee, o.ReplayRate, o.ReplayRealtime, o.Suffix, o.TimestampedOutput, o.NameTemplate, o.Preallocate, o.SplitOnMarker, o.Fsync, o.OutputMode, o.OutputOwner, o.Header, o.PreserveMtime)
 }
 
 type logFile struct {
-	index int
-	name  string
+	index   int
+	name    string
+	size    int64
+	modTime time.Time
 }
 
 type FilesList map[string][]*logFile
 
+// mergeRun holds the state shared by all the groups merged in one run
+type mergeRun struct {
+	memory      *memoryBudget
+	writeBuffer int
+	readBuffer  int
+	readLimit   *rateLimiter
+	writeLimit  *rateLimiter
+	buildIndex  bool
+	timeIndex   bool
+	histogram   time.Duration
+	histFormat  string
+	clusterTop  int
+	levels      *severityDetector
+	signKey     crypto.Signer
+	anonymize   bool
+	salt        string
+	fields      *fieldFilter
+	contains    *lineMatcher
+	tags        *tagInjector
+	skipErrors  bool
+	retry       *retryPolicy
+	deadline    time.Time
+	fileTimeout time.Duration
+	chunkTime   time.Duration
+	keepPartial bool
+	checkOrder  bool
+	report      *runReport
+	tee         *teeSinks
+	pool        *workerPool
+	names       *outputNames
+	metadata    *outputMetadata
+	fsync       string
+	preallocate bool
+	// sessionMark and sessionGap enable the session report
+	sessionMark string
+	sessionGap  time.Duration
+	header      string
+	delimiter   byte
+	// input holds the parts when not read from the input directory
+	input fs.FS
+
+	// aborted is set once a group failed without --skip-errors, cause is
+	// the error of the first failure
+	aborted   int32
+	causeLock sync.Mutex
+	cause     error
+}
+
+// abort stops the merge of the groups not started yet, err is the cause
+// reported if it is the first failure
+func (run *mergeRun) abort(err error) {
+	run.causeLock.Lock()
+	if run.cause == nil {
+		run.cause = err
+	}
+	run.causeLock.Unlock()
+	atomic.StoreInt32(&run.aborted, 1)
+}
+
+// sidecarWriter receives the bytes written to an output and saves an index
+// of them next to it once the output is complete
+type sidecarWriter interface {
+	io.Writer
+	save(indexedPath string) error
+}
+
+func (run *mergeRun) newSidecars() []sidecarWriter {
+	var sidecars []sidecarWriter
+	if run.buildIndex {
+		sidecars = append(sidecars, newTrigramIndexer(run.delimiter))
+	}
+	if run.timeIndex {
+		sidecars = append(sidecars, newTimeIndexer())
+	}
+	if run.histogram > 0 {
+		sidecars = append(sidecars, newHistogramWriter(run.histogram, run.histFormat))
+	}
+	if run.clusterTop > 0 {
+		sidecars = append(sidecars, newClusterWriter(run.clusterTop, run.levels))
+	}
+	if run.signKey != nil {
+		sidecars = append(sidecars, newSignatureWriter(run.signKey))
+	}
+	return sidecars
+}
+
+// newTransforms returns the rewrites applied to the lines of the outputs
+// setContent applies the options deciding what is written to the outputs
+// besides the parts: the transforms of their lines and the header
+func (run *mergeRun) setContent(options *Options, tags *tagInjector, start time.Time) {
+	run.anonymize = options.AnonymizeIPs
+	run.salt = options.AnonymizeSalt
+	if len(options.Contains) > 0 {
+		run.contains = newLineMatcher(options.Contains, options.IgnoreCase, options.WordRegexp)
+	}
+	if len(options.Tag) > 0 {
+		run.tags = tags
+	}
+	if options.Header {
+		run.header = newOutputHeader(options, start)
+	}
+	run.delimiter = options.RecordDelimiter.value()
+	if len(options.DropFields) > 0 || len(options.KeepFields) > 0 {
+		run.fields = newFieldFilter(options.DropFields, options.KeepFields, run.delimiter)
+	}
+}
+
+func (run *mergeRun) newTransforms() []lineTransform {
+	var transforms []lineTransform
+	// the lines are selected as they are in the parts, before any rewrite
+	if run.contains != nil {
+		transforms = append(transforms, newLineSelector(run.contains, run.delimiter).filter)
+	}
+	if run.anonymize {
+		transforms = append(transforms, newIPAnonymizer(run.salt).anonymize)
+	}
+	if run.fields != nil {
+		transforms = append(transforms, run.fields.filter)
+	}
+	// the tags come last, not to be removed by the field filter
+	if run.tags != nil {
+		transforms = append(transforms, run.tags.forOutput(run.delimiter).inject)
+	}
+	return transforms
+}
+
 func main() {
 	var options Options
-	var parser = flags.NewParser(&options, flags.Default)
-	
+	var parser = NewParser(&options)
+
 	defer func() {
 		if err := recover(); err != nil {
 			log.Errorf("[ERROR]: %v\n", err)
@@ -53,17 +273,106 @@ func main() {
 		log.Println("[Finished]")
 	}()
 
-	log.Println("[Begin AggregateLogs]")
 	if _, err := parser.Parse(); err != nil {
 		outCode := 0
-		if flagsErr, ok := err.(*flags.Error); !ok || flagsErr.Type != flags.ErrHelp {
-			log.Errorf("%v\n", flagsErr)
-			outCode = 1
+		if flagsErr, ok := err.(*flags.Error); !ok {
+			log.Errorf("%v\n", err)
+			outCode = exitCode(err)
+		} else if flagsErr.Type != flags.ErrHelp {
+			log.Errorf("%v\n", err)
+			outCode = exitInvalidOptions
 		}
 		os.Exit(outCode)
 	}
+	if parser.Active != nil {
+		// a subcommand was executed by the parser, nothing else to do
+		os.Exit(0)
+	}
+	if options.Version {
+		if err := WriteVersion(os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err := ConfigureLogging(&options); err != nil {
+		log.Errorf("%v\n", err)
+		os.Exit(1)
+	}
+	stopProfiling, err := StartProfiling(&options)
+	if err != nil {
+		log.Errorf("%v\n", err)
+		os.Exit(1)
+	}
+	log.Println("[Begin AggregateLogs]")
+
+	code := MainRoutine(&options)
+	stopProfiling()
+	os.Exit(code)
+}
+
+// NewParser creates the command line parser for the options and registers
+// the subcommands on it, the merge runs when no subcommand is given
+func NewParser(options *Options) *flags.Parser {
+	var parser = flags.NewParser(options, flags.Default)
+	parser.SubcommandsOptional = true
+	parser.LongDescription = exitCodesHelp
+
+	_, _ = parser.AddCommand("cat", "Print the merged content without writing files",
+		"Streams the parts of the base name, or of all of them, to stdout in merge order", &CatCommand{options: options})
+	_, _ = parser.AddCommand("completion", "Generate shell completion script",
+		"Writes the completion script for bash, zsh, fish or powershell to stdout", &CompletionCommand{parser: parser})
+	_, _ = parser.AddCommand("bench", "Benchmark the merge on synthetic data",
+		"Generates a rotation set of the given size in a temporary directory and merges it with the current options", &BenchCommand{options: options})
+	_, _ = parser.AddCommand("diff", "Compare an aggregate with another or with its parts",
+		"Reports the lines missing from or extra in the second aggregate, or in the aggregate compared with its parts, with the file and line they come from", &DiffCommand{options: options})
+	_, _ = parser.AddCommand("extract", "Extract a time range from an aggregate",
+		"Writes the lines of an aggregate between --since and --until, using the sidecar written by --time-index when available", &ExtractCommand{})
+	_, _ = parser.AddCommand("search", "Search the aggregates for a text",
+		"Prints the lines of the aggregates containing the query, using the index sidecars written by --index when available", &SearchCommand{options: options})
+	_, _ = parser.AddCommand("split", "Split an aggregate back into pieces",
+		"Splits an aggregate by size, line count or time window, never cutting a line, for channels limiting the file size", &SplitCommand{})
+	_, _ = parser.AddCommand("stats", "Report statistics of the logs without merging",
+		"Reports lines, bytes, time span and lines per severity of each base name and of its parts", &StatsCommand{options: options})
+	_, _ = parser.AddCommand("tail", "Print the last lines of a log across its parts",
+		"Prints the last lines of the rotation set of the base name in order, optionally following the live part", &TailCommand{options: options})
+	_, _ = parser.AddCommand("version", "Print version and build information",
+		"Prints the semantic version, git commit, build date and module information of the binary", &VersionCommand{})
+
+	return parser
+}
+
+// ConfigureLogging applies the log format and level requested in the options
+// to the global logger, so the tool's own messages can be machine-parsed
+// when running under another log collector.
+func ConfigureLogging(options *Options) error {
+	switch options.LogFormat {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{})
+	default:
+		return fmt.Errorf("unknown log format: %s", options.LogFormat)
+	}
 
-	os.Exit(MainRoutine(&options))
+	switch {
+	case options.Quiet && options.Verbose:
+		return fmt.Errorf("--quiet and --verbose cannot be used together")
+	case options.Quiet:
+		log.SetLevel(log.ErrorLevel)
+		return nil
+	case options.Verbose:
+		log.SetLevel(log.DebugLevel)
+		return nil
+	case options.LogLevel == "":
+		log.SetLevel(log.InfoLevel)
+		return nil
+	}
+	level, err := log.ParseLevel(options.LogLevel)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+	return nil
 }
 
 func MainRoutine(options *Options) int {
@@ -72,135 +381,777 @@ func MainRoutine(options *Options) int {
 		return 1
 	}
 	log.Println(options)
+	if err := options.Validate(); err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return exitCode(err)
+	}
+	scan, err := newScanOptions(options)
+	if err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return exitCode(err)
+	}
+	names := scan.names
+	// basepath is where the parts are and the outputs are written, a
+	// support bundle is merged from the archive to the directory holding it
+	input, basepath, release, err := openInput(string(scan.input))
+	if err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return 1
+	}
+	defer release()
+	combines, err := parseCombineSpecs(options.Combine)
+	if err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return exitCode(err)
+	}
+	tags, err := newTagInjector(options.Tag, options.TagPrefix)
+	if err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return exitCode(err)
+	}
+	report := newRunReport()
 
 	log.Println("[Begin scan of path]")
-	allFiles, err := ScanFolderForFiles(options.Input)
+	if options.SkipErrors {
+		scan.onError = func(path string, err error) error {
+			name := filepath.Base(path)
+			log.Warnf("Skipping %s: %v\n", name, err)
+			report.update(groupName(name), func(group *GroupReport) {
+				group.Failures = append(group.Failures, FileFailure{Name: name, Err: err.Error()})
+			})
+			return nil
+		}
+	}
+	allFiles, err := scanInput(input, basepath, scan)
+	// a rotation while waiting renamed the parts, the scan is redone
+	if err == nil && input == nil && options.UntilQuiet > 0 && waitUntilQuiet(basepath, allFiles, options.UntilQuiet, options.Timeout) {
+		log.Println("[Scanning the path again after a rotation]")
+		allFiles, err = scanInput(input, basepath, scan)
+	}
 	log.Println("[End scan of path]")
 
 	if err != nil {
 		log.Errorf("ERROR: found during input path traversal: %v\n", err)
 		return 1
 	}
+	if allFiles, err = combineGroups(allFiles, combines); err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return 1
+	}
+	if len(allFiles) == 0 {
+		log.Errorf("ERROR: %v in %s\n", ErrNoFilesFound, basepath)
+		return exitCode(ErrNoFilesFound)
+	}
 
-	for fBase, list := range allFiles {
-		MergeLogList(string(options.Input), fBase, list, options)
+	// the interactive session and the delete confirmations share the input
+	var stdin *bufio.Scanner
+	if options.Interactive || options.InteractiveDelete {
+		stdin = bufio.NewScanner(os.Stdin)
+	}
+	deleteFiles := options.Delete
+	if options.Interactive {
+		var confirmed bool
+		allFiles, deleteFiles, confirmed = RunInteractive(stdin, os.Stdout, allFiles, options.Delete, options.newestFirst())
+		if !confirmed {
+			log.Println("[Interactive session cancelled]")
+			return 0
+		}
+	}
+
+	if options.Preview > 0 {
+		previews := PreviewFilter(input, basepath, allFiles, options, options.Preview)
+		if err := WritePreview(os.Stdout, previews, options.Contains); err != nil {
+			log.Errorf("ERROR: %v\n", err)
+			return 1
+		}
+		return 0
+	}
 
-		if options.Delete {
-			DeleteLogList(string(options.Input), list)
+	if options.Count {
+		counts := CountMatches(input, basepath, allFiles, options)
+		if err := WriteMatchCounts(os.Stdout, counts, options.Contains); err != nil {
+			log.Errorf("ERROR: %v\n", err)
+			return 1
 		}
+		return 0
+	}
+
+	if options.Estimate {
+		throughput := sampleThroughput(input, basepath, allFiles, options.BwLimit)
+		if err := WriteEstimate(os.Stdout, EstimateMerge(allFiles, options, names), throughput); err != nil {
+			log.Errorf("ERROR: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	metadata, err := newOutputMetadata(options.OutputMode, options.OutputOwner, options.PreserveMtime)
+	if err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return 1
+	}
+
+	var signKey crypto.Signer
+	if options.Sign != "" {
+		if signKey, err = loadSigningKey(string(options.Sign)); err != nil {
+			log.Errorf("ERROR: loading signing key: %v\n", err)
+			return 1
+		}
+	}
+
+	var trash *trashBin
+	if (deleteFiles || options.DeleteEmpty) && options.Trash {
+		dir := filepath.Join(basepath, defaultTrashDir)
+		if options.TrashDir != "" {
+			dir = longPath(string(options.TrashDir))
+		}
+		if trash, err = newTrashBin(dir, options.TrashTTL); err != nil {
+			log.Errorf("ERROR: creating trash: %v\n", err)
+			return 1
+		}
+		trash.purge()
+	}
+	pool := newWorkerPool(options.Workers)
+	if options.DeleteEmpty {
+		allFiles = DeleteEmptyParts(basepath, allFiles, trash, pool, report)
+	}
+
+	// the unchanged groups are left out before the disk space is checked,
+	// only the groups merged need room
+	fingerprints := make(map[string]*groupFingerprint)
+	if options.SkipUnchanged {
+		for fBase, list := range allFiles {
+			SortLogList(list, options.newestFirst())
+			fingerprint, err := computeFingerprint(input, basepath, list, outputSettings(options))
+			if err != nil {
+				log.Warnf("Fingerprinting %s: %v\n", fBase, err)
+				continue
+			}
+			if fingerprint.upToDate(basepath, fBase, options.Suffix) {
+				log.Println("[Skipping unchanged ", fBase, "]")
+				report.update(fBase, func(group *GroupReport) {
+					group.Unchanged = true
+				})
+				delete(allFiles, fBase)
+				continue
+			}
+			fingerprints[fBase] = fingerprint
+		}
+	}
+
+	if err := CheckDiskSpace(basepath, allFiles, options.SpaceFactor); err != nil {
+		log.Errorf("ERROR: %v\n", err)
+		return 1
+	}
+
+	var confirmer *deleteConfirmer
+	if deleteFiles && options.InteractiveDelete {
+		confirmer = newDeleteConfirmer(stdin, os.Stdout)
+	}
+
+	tee, err := openTeeSinks(options.Tee, options)
+	if err != nil {
+		log.Errorf("ERROR: opening tee sink: %v\n", err)
+		return 1
+	}
+	if tee != nil {
+		tee.pacer = newReplayPacer(options.ReplayRate, options.ReplayRealtime, options.RecordDelimiter.value())
+		defer tee.Close()
+	}
+
+	run := &mergeRun{
+		memory:      newMemoryBudget(options.MaxMemory),
+		writeBuffer: int(options.WriteBuffer),
+		readBuffer:  int(options.ReadBuffer),
+		readLimit:   newRateLimiter(options.BwLimit),
+		writeLimit:  newRateLimiter(options.BwLimit),
+		buildIndex:  options.Index,
+		timeIndex:   options.TimeIndex,
+		histogram:   options.Histogram,
+		histFormat:  options.HistogramFormat,
+		levels:      newSeverityDetector(options.LevelMap),
+		checkOrder:  options.CheckOrder,
+		report:      report,
+		tee:         tee,
+		pool:        pool,
+		names:       names,
+		metadata:    metadata,
+		fsync:       options.Fsync,
+		preallocate: options.Preallocate,
+		sessionMark: options.SessionMarker,
+		sessionGap:  options.SessionGap,
+		signKey:     signKey,
+		skipErrors:  options.SkipErrors,
+		retry:       newRetryPolicy(options.Retries, options.RetryBackoff),
+		fileTimeout: options.FileTimeout,
+		chunkTime:   options.ChunkTimeout,
+		keepPartial: options.KeepPartial,
+	}
+	if options.Timeout > 0 {
+		run.deadline = time.Now().Add(options.Timeout)
+	}
+	run.setContent(options, tags, time.Now())
+	run.input = input
+	if options.ClusterErrors {
+		run.clusterTop = options.ClusterTop
+		if run.clusterTop < 1 {
+			run.clusterTop = 10
+		}
+	}
+	if run.writeBuffer <= 0 {
+		run.writeBuffer = defaultWriteBuffer
+	}
+	if run.readBuffer <= 0 {
+		run.readBuffer = defaultReadBuffer
+	}
+
+	parallel := options.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	// groups are independent, merge up to parallel of them at a time
+	slots := make(chan struct{}, parallel)
+	wg := &sync.WaitGroup{}
+	for fBase, list := range allFiles {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(fBase string, list []*logFile) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Errorf("[ERROR]: %v\n", err)
+					log.Errorf("%v\n", string(debug.Stack()))
+				}
+				<-slots
+				wg.Done()
+			}()
+
+			if atomic.LoadInt32(&run.aborted) != 0 {
+				log.Println("[Skipping ", fBase, " after a failed merge]")
+				return
+			}
+			failures, err := MergeLogList(basepath, fBase, list, options, run)
+			if err != nil {
+				run.abort(err)
+				return
+			}
+			if fingerprint := fingerprints[fBase]; fingerprint != nil && len(failures) == 0 {
+				var outputs []string
+				run.report.update(fBase, func(group *GroupReport) {
+					outputs = group.Outputs
+				})
+				if err := fingerprint.save(basepath, fBase, options.Suffix, outputs); err != nil {
+					log.Warnf("Saving the fingerprint of %s: %v\n", fBase, err)
+				}
+			}
+
+			if deleteFiles {
+				merged := withoutFailures(list, failures)
+				if confirmer != nil && !confirmer.confirm(fBase, merged) {
+					log.Println("[Delete of ", fBase, " declined]")
+					return
+				}
+				DeleteLogList(basepath, merged, trash, run.pool)
+			}
+		}(fBase, list)
+	}
+	wg.Wait()
+	run.report.logSummary()
+	if atomic.LoadInt32(&run.aborted) != 0 {
+		return exitCode(run.cause)
 	}
 	// correct execution
-	return 0
+	return exitOK
 }
 
 func ScanFolderForFiles(logsPath flags.Filename) (FilesList, error) {
+	return ScanFolder(logsPath, &scanOptions{})
+}
+
+// ScanFolder lists the parts in logsPath like ScanFolderForFiles, with the
+// given scan options
+func ScanFolder(logsPath flags.Filename, scan *scanOptions) (FilesList, error) {
 	// files list by base name
 	filesMap := make(FilesList)
 
 	basepath, _ := filepath.Abs(string(logsPath))
+	outputName := scan.names.filePattern()
+	root := basepath
+	if scan.followSymlinks {
+		var err error
+		if root, err = filepath.EvalSymlinks(basepath); err != nil {
+			return filesMap, err
+		}
+	}
+
+	// symlinked parts are checked once all the regular ones are known, a
+	// link to a file merged anyway is a duplicate
+	type linkedPart struct {
+		part   *logFile
+		target string
+	}
+	var links []linkedPart
+	regular := make(map[string]bool)
+
 	log.Println("[Start analysis of basepath: ", basepath, "]")
-	err := filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			if scan.onError == nil || path == root {
+				return err
+			}
+			return scan.onError(path, err)
 		}
-		if info.IsDir() && path != basepath {
+		if info.IsDir() && path != root {
 			return filepath.SkipDir
 		}
-		if info.IsDir() && path == basepath {
+		if info.IsDir() && path == root {
 			return nil
 		}
 
-		// Do not check the extension, .log might be in the middle
-		// of the name because of the split ".1"
-		// also ignore previous runs as they'll be overwritten later
-		if !strings.Contains(info.Name(), ".log") || strings.Contains(info.Name(), "."+aggregatedLogSuffix) {
+		// before the link is resolved, the date is in the name
+		if !scan.admits(info.Name(), outputName) || isTrackedOutput(filepath.Join(basepath, info.Name())) {
 			return nil
 		}
 
-		parts := strings.Split(info.Name(), ".")
-		if filesMap[parts[0]] == nil {
-			filesMap[parts[0]] = make([]*logFile, 0, 256)
+		var target string
+		if info.Mode()&os.ModeSymlink != 0 && scan.followSymlinks {
+			// os.Stat reports ELOOP for a loop of links
+			if info, err = os.Stat(path); err != nil {
+				if scan.onError == nil {
+					return err
+				}
+				return scan.onError(path, err)
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if target, err = filepath.EvalSymlinks(path); err != nil {
+				if scan.onError == nil {
+					return err
+				}
+				return scan.onError(path, err)
+			}
+			if outputName.MatchString(filepath.Base(target)) || isTrackedOutput(target) {
+				return nil
+			}
 		}
-		log.Println("Found: ", info.Name())
-		def := &logFile{
-			index: 0,
-			name:  info.Name(),
+
+		if !scan.selects(info) {
+			log.Debugln("Skipping by size or age: ", info.Name())
+			return nil
 		}
-		for i := len(parts) - 1; i > 0; i-- {
-			// the last number is relevant for ordering
-			if idx, err := strconv.Atoi(parts[i]); err == nil {
-				def.index = idx
-				break
-			}
+
+		log.Debugln("Found: ", info.Name())
+		def := newLogFile(info)
+		if target != "" {
+			links = append(links, linkedPart{part: def, target: target})
+			return nil
 		}
-		filesMap[parts[0]] = append(filesMap[parts[0]], def)
+		regular[path] = true
+		base := groupName(def.name)
+		filesMap[base] = append(filesMap[base], def)
 
 		return nil
 	})
 
+	for _, link := range links {
+		if regular[link.target] {
+			log.Warnf("Skipping %s, it links to %s already merged\n", link.part.name, filepath.Base(link.target))
+			continue
+		}
+		regular[link.target] = true
+		base := groupName(link.part.name)
+		filesMap[base] = append(filesMap[base], link.part)
+	}
 	return filesMap, err
 }
 
-func MergeLogList(basepath, basename string, list []*logFile, config *Options) {
-	log.Println("[Start output of log: ", basepath, "]")
+// outputPaths are the absolute paths of the files written by this process,
+// never taken as parts even when their name looks like one
+var outputPaths sync.Map
+
+func trackOutput(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		outputPaths.Store(abs, struct{}{})
+	}
+}
+
+func isTrackedOutput(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	_, tracked := outputPaths.Load(abs)
+	return tracked
+}
+
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// newestFirst tells whether the parts are merged from the live file, index
+// 0, to the oldest rotation, with --order desc or --Reverse
+func (o *Options) newestFirst() bool {
+	return o.Order == orderDesc || (o.Order == "" && o.Reverse)
+}
+
+// SortLogList orders the parts of a log in merge order, by default from the
+// highest index (oldest rotation) to the lowest, the live file being last.
+// Parts with the same index are ordered by name, newestFirst gives the exact
+// reverse order.
+func SortLogList(list []*logFile, newestFirst bool) {
 	// alphabetical order is not good here, actual numeric order is required
-	sort.Slice(list, func(i, j int) bool {
-		if config.Reverse {
-			return list[i].index <= list[j].index
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if newestFirst {
+			a, b = b, a
 		}
-		return list[i].index > list[j].index
+		if a.index != b.index {
+			return a.index > b.index
+		}
+		return a.name < b.name
 	})
+}
 
-	var outputFilesPerChunk = len(list)
-	if config.MaxChunks <= 1 {
-		config.MaxChunks = 1
-	} else {
-		outputFilesPerChunk = len(list) / config.MaxChunks
-		if outputFilesPerChunk < 2 {
-			log.Errorf("[ERROR]: Cannot subdivide into the indicated number of outputFilesPerChunk.\n")
-			return
-		}
-		if len(list)%config.MaxChunks > 0 {
-			config.MaxChunks++
-		}
+// MergeLogList merges the parts of a group into its outputs. The parts that
+// could not be read are returned, without --skip-errors the merge stops at
+// the first one and err tells why.
+func MergeLogList(basepath, basename string, list []*logFile, config *Options, run *mergeRun) (failures []FileFailure, err error) {
+	log.Println("[Start output of log: ", basepath, "]")
+	SortLogList(list, config.newestFirst())
+
+	chunks, err := planChunks(list, config.MaxChunks)
+	if err != nil {
+		log.Errorf("[ERROR]: %v\n", err)
+		return nil, err
 	}
 
-	nameOutFile := strings.Join([]string{basename, aggregatedLogSuffix, "log"}, ".")
+	defer func() {
+		run.report.update(basename, func(group *GroupReport) {
+			group.Failures = append(group.Failures, failures...)
+			group.Aborted = err != nil
+		})
+	}()
 
-	for chunkIdx := 0; chunkIdx < config.MaxChunks; chunkIdx++ {
-		if config.MaxChunks > 1 {
-			idxString := strconv.FormatInt(int64(chunkIdx+1), 10)
-			nameOutFile = strings.Join([]string{basename, aggregatedLogSuffix, idxString, "log"}, ".")
-		}
+	// the observers span the chunks, they follow the group as a whole
+	var observers []partObserver
+	if run.checkOrder {
+		order := newOrderChecker()
+		observers = append(observers, order)
+		defer func() {
+			report := order.report()
+			run.report.update(basename, func(group *GroupReport) {
+				group.Order = &report
+			})
+		}()
+	}
+	if run.sessionMark != "" || run.sessionGap > 0 {
+		sessions := newSessionDetector(run.sessionMark, run.sessionGap)
+		observers = append(observers, sessions)
+		defer func() {
+			report := sessions.report()
+			run.report.update(basename, func(group *GroupReport) {
+				group.Sessions = report
+			})
+		}()
+	}
 
-		outFile, _ := filepath.Abs(filepath.Join(basepath, nameOutFile))
-		f, err := os.Create(outFile)
+	for chunkIdx, chunk := range chunks {
+		outFile, _ := filepath.Abs(filepath.Join(basepath, run.names.chunk(basename, chunkIdx, len(chunks))))
+		f, err := createOutput(outFile, config.IfExists)
 		if err != nil {
 			log.Errorf("[End output for ERROR: %v]\n", err)
-			return
+			return failures, err
 		}
+		if run.preallocate {
+			if err := preallocateOutput(f, chunk); err != nil {
+				log.Errorf("[End output for ERROR: preallocating %s: %v]\n", outFile, err)
+				// do not leave behind an empty output
+				info, statErr := f.Stat()
+				_ = f.Close()
+				if statErr == nil && info.Size() == 0 {
+					_ = os.Remove(outFile)
+				}
+				return failures, fmt.Errorf("preallocating %s: %v", outFile, err)
+			}
+		}
+		trackOutput(outFile)
+		run.report.update(basename, func(group *GroupReport) {
+			group.Outputs = append(group.Outputs, outFile)
+		})
 		log.Println("Created output file: ", outFile)
 
-		var currPos = chunkIdx * outputFilesPerChunk
-		var nextPos = (chunkIdx + 1) * outputFilesPerChunk
-		if nextPos >= len(list) {
-			nextPos = len(list)
+		var previousSize int64
+		if info, err := f.Stat(); err == nil {
+			previousSize = info.Size()
+		}
+		var split *markerSplit
+		if config.SplitOnMarker != "" {
+			split = newMarkerSplit(filepath.Dir(outFile), basename, config, run)
+		}
+		ctx, cancel := run.chunkContext()
+		chunkFailures, incomplete := MergeLogChunk(ctx, basepath, f, chunk, run, observers, split)
+		timedOut := ctx.Err() != nil
+		cancel()
+		// an output missing parts that were not skipped on purpose looks
+		// complete, and one ending a part halfway is corrupt, what the chunk
+		// wrote is removed
+		if len(chunkFailures) > 0 && (timedOut || incomplete || !run.skipErrors) {
+			chunkFailures = failAllParts(chunk, chunkFailures, errors.New("chunk failed"))
+			removed := false
+			partial := []string{outFile}
+			if split != nil {
+				partial = split.outputs(outFile)
+			}
+			if !run.keepPartial {
+				if removed, err = discardPartial(outFile, previousSize); err != nil {
+					log.Errorf("[ERROR]: Removing the partial output %s: %v\n", outFile, err)
+				}
+				// the outputs of the next sessions were created by the chunk
+				for _, piece := range partial[1:] {
+					if _, err := discardPartial(piece, 0); err != nil {
+						log.Errorf("[ERROR]: Removing the partial output %s: %v\n", piece, err)
+					}
+				}
+			}
+			run.report.update(basename, func(group *GroupReport) {
+				group.Partial = append(group.Partial, partial...)
+				if removed {
+					group.Outputs = group.Outputs[:len(group.Outputs)-1]
+				}
+			})
+			failures = append(failures, chunkFailures...)
+			if !run.skipErrors {
+				return failures, &PartUnreadableError{File: chunkFailures[0].Name, Err: errors.New(chunkFailures[0].Err)}
+			}
+			continue
+		}
+		failures = append(failures, chunkFailures...)
+		outputs := []string{outFile}
+		if split != nil {
+			outputs = split.outputs(outFile)
+		}
+		for _, output := range outputs {
+			if err := run.metadata.apply(output, chunk); err != nil {
+				log.Errorf("[End output for ERROR: setting metadata: %v]\n", err)
+				return failures, fmt.Errorf("setting metadata of %s: %v", output, err)
+			}
+		}
+
+		if split != nil {
+			pieces, err := split.finish(outFile)
+			run.report.update(basename, func(group *GroupReport) {
+				group.Outputs = append(group.Outputs[:len(group.Outputs)-1], pieces...)
+			})
+			if err != nil {
+				log.Errorf("[End output for ERROR: splitting on marker: %v]\n", err)
+				return failures, fmt.Errorf("splitting %s on marker: %v", outFile, err)
+			}
 		}
+	}
+	return failures, nil
+}
 
-		MergeLogChunk(basepath, f, list[currPos:nextPos])
+// planChunks splits the sorted parts of a group in maxChunks contiguous
+// chunks of about the same size in bytes, one per part when there are fewer
+// parts than chunks. Empty parts weigh as much as a byte so they are spread
+// too.
+func planChunks(list []*logFile, maxChunks int) ([][]*logFile, error) {
+	if len(list) == 0 {
+		return nil, errors.New("no parts to merge")
+	}
+	if maxChunks <= 1 {
+		return [][]*logFile{list}, nil
+	}
+	if maxChunks > len(list) {
+		maxChunks = len(list)
+	}
+
+	weight := func(part *logFile) int64 {
+		if part.size <= 0 {
+			return 1
+		}
+		return part.size
+	}
+	var total int64
+	for _, part := range list {
+		total += weight(part)
 	}
+
+	chunks := make([][]*logFile, 0, maxChunks)
+	var start int
+	var done int64
+	for chunkIdx := 0; chunkIdx < maxChunks-1; chunkIdx++ {
+		// close the chunk at the part boundary nearest to its share of the
+		// total, leaving at least a part for each of the next chunks
+		target := total * int64(chunkIdx+1) / int64(maxChunks)
+		end := start + 1
+		done += weight(list[start])
+		for end < len(list)-(maxChunks-1-chunkIdx) {
+			next := done + weight(list[end])
+			if next-target >= target-done {
+				break
+			}
+			done = next
+			end++
+		}
+		chunks = append(chunks, list[start:end])
+		start = end
+	}
+	return append(chunks, list[start:]), nil
 }
 
-func MergeLogChunk(basepath string, f *os.File, list []*logFile) {
+// chunkOutputName is the name of the output of a chunk, basename.full.log
+// for a single chunk and basename.full.N.log otherwise, N is zero padded to
+// the width of the chunk count so the names sort in merge order
+func chunkOutputName(basename, suffix string, chunkIdx, chunks int) string {
+	suffix = outputSuffix(suffix)
+	if chunks > 1 {
+		width := len(strconv.Itoa(chunks))
+		idxString := fmt.Sprintf("%0*d", width, chunkIdx+1)
+		return strings.Join([]string{basename, suffix, idxString, "log"}, ".")
+	}
+	return strings.Join([]string{basename, suffix, "log"}, ".")
+}
+
+// withoutFailures returns the parts of list that are not among failures
+func withoutFailures(list []*logFile, failures []FileFailure) []*logFile {
+	if len(failures) == 0 {
+		return list
+	}
+	failed := make(map[string]bool, len(failures))
+	for _, failure := range failures {
+		failed[failure.Name] = true
+	}
+	result := make([]*logFile, 0, len(list))
+	for _, part := range list {
+		if !failed[part.name] {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// MergeLogChunk writes the parts of list to f in order and returns the parts
+// that could not be read or whose bytes did not all reach the output. Without
+// --skip-errors the parts after the first failure are not written. The bytes
+// already written cannot be taken back from the sidecars and the tee, so a
+// part failing partway is reported incomplete and ends the chunk even with
+// --skip-errors. A non nil split moves the output on to a new file at every
+// session.
+func MergeLogChunk(ctx context.Context, basepath string, f *os.File, list []*logFile, run *mergeRun, observers []partObserver, split *markerSplit) (failures []FileFailure, incomplete bool) {
+	var sidecars = run.newSidecars()
+	outputWriters := func() []io.Writer {
+		writers := []io.Writer{newRetryWriter(newLimitedWriter(f, run.writeLimit), "writing "+f.Name(), run.retry)}
+		for _, sidecar := range sidecars {
+			writers = append(writers, sidecar)
+		}
+		return writers
+	}
+	writers := outputWriters()
+	if err := primeSidecars(f, sidecars); err != nil {
+		log.Errorf("[ERROR]: Reading the existing content of %s: %v\n", f.Name(), err)
+	}
+	// the header starts the new outputs only, not the ones appended to, and
+	// is not sent to the tee sinks
+	if info, err := f.Stat(); run.header != "" && err == nil && info.Size() == 0 {
+		header := outputHeader(run.header, list)
+		skipHeaders(sidecars, int64(len(header)))
+		if _, err := io.MultiWriter(writers...).Write(header); err != nil {
+			log.Errorf("[ERROR]: Writing the header of %s: %v\n", f.Name(), err)
+		}
+	}
+	if run.tee != nil {
+		// released after the final flush, deferred calls run in reverse
+		run.tee.acquire()
+		defer run.tee.release()
+		writers = append(writers, run.tee)
+	}
+	out := bufio.NewWriterSize(io.MultiWriter(writers...), run.writeBuffer)
+
+	// closeOutput flushes and closes the output, then saves its sidecars
+	closeOutput := func() error {
+		err := out.Flush()
+		if run.fsync != fsyncNever {
+			if syncErr := f.Sync(); syncErr != nil && err == nil {
+				err = syncErr
+			}
+		}
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		for _, sidecar := range sidecars {
+			if err := sidecar.save(f.Name()); err != nil {
+				log.Errorf("[ERROR]: Writing sidecar of %s: %v\n", f.Name(), err)
+			}
+		}
+		return err
+	}
+
+	// the parts are written to merged, the transforms come before the outputs
+	// and the observers, which follow the lines as they are written
+	var merged io.Writer = out
+	// the split sees whole records, the output moves on to the next session
+	// at the ones containing the marker
+	var splitter *transformWriter
+	if split != nil {
+		rotate := func() error {
+			err := closeOutput()
+			f = nil
+			if err != nil {
+				return err
+			}
+			if f, err = split.create(); err != nil {
+				return err
+			}
+			sidecars = run.newSidecars()
+			writers := outputWriters()
+			if run.tee != nil {
+				writers = append(writers, run.tee)
+			}
+			out.Reset(io.MultiWriter(writers...))
+			return nil
+		}
+		splitter = newTransformWriter(newMarkerWriter(out, split.marker, run.delimiter, rotate), nil, run.delimiter)
+		merged = splitter
+	}
+	if len(observers) > 0 {
+		sinks := []io.Writer{merged}
+		for _, observer := range observers {
+			sinks = append(sinks, observer)
+		}
+		merged = io.MultiWriter(sinks...)
+	}
+	var transformer *transformWriter
+	if transforms := run.newTransforms(); len(transforms) > 0 {
+		transformer = newTransformWriter(merged, transforms, run.delimiter)
+		merged = transformer
+	}
+
 	defer func() {
 		if err := recover(); err != nil {
 			log.Errorf("[ERROR]: %v\n", err)
 			log.Errorf("%v\n", string(debug.Stack()))
 		}
 		if f != nil {
-			// flush and close the file
-			_ = f.Sync()
-			_ = f.Close()
+			// flush and close the file, on failure no part is known to be
+			// entirely in the output
+			var outErr error
+			if transformer != nil {
+				outErr = transformer.Flush()
+			}
+			if splitter != nil {
+				if err := splitter.Flush(); err != nil && outErr == nil {
+					outErr = err
+				}
+			}
+			if err := closeOutput(); err != nil && outErr == nil {
+				outErr = err
+			}
+			if outErr != nil {
+				log.Errorf("[ERROR]: Writing output: %v\n", outErr)
+				failures = failAllParts(list, failures, outErr)
+			}
 		}
 		log.Println("[End output of log chunk]")
 	}()
@@ -208,57 +1159,242 @@ func MergeLogChunk(basepath string, f *os.File, list []*logFile) {
 	log.Println("[Start output of log chunk]")
 
 	var currentWriteFileIndex = int32(0)
+	var failed = int32(0)
+	var failuresLock sync.Mutex
 
 	wg := &sync.WaitGroup{}
-	wg.Add(len(list))
-	for idx, _ := range list {
-		go func(listIndex int32) {
+	for idx := range list {
+		listIndex := int32(idx)
+		// the parts start in merge order, so the part whose turn it is
+		// always holds a slot of the pool
+		run.pool.goWait(wg, func() {
+			part := list[listIndex]
+			if run.memory != nil {
+				for listIndex-atomic.LoadInt32(&currentWriteFileIndex) > maxPartsAhead {
+					time.Sleep(10 * time.Microsecond)
+				}
+			}
+
+			// the turn always passes to the next part, even after a failure
+			var failure error
+			var written int64
 			defer func() {
-				if err := recover(); err != nil {
-					log.Errorf("[ERROR]: %v\n", err)
-					log.Errorf("%v\n", string(debug.Stack()))
+				if failure != nil {
+					log.Errorf("[ERROR]: Merging %s: %v\n", part.name, failure)
+					failuresLock.Lock()
+					failures = append(failures, FileFailure{Name: part.name, Err: failure.Error()})
+					if written > 0 {
+						incomplete = true
+					}
+					failuresLock.Unlock()
+					if !run.skipErrors || written > 0 {
+						atomic.StoreInt32(&failed, 1)
+					}
 				}
-				wg.Done()
+				atomic.StoreInt32(&currentWriteFileIndex, listIndex+1)
 			}()
 
-			data, err := ioutil.ReadFile(filepath.Join(basepath, list[listIndex].name))
-			if err != nil {
-				log.Errorf("[ERROR]: End output for %v\n", err)
-				return
+			// parts not fitting the memory budget are streamed when their turn comes
+			buffered := run.memory.tryAcquire(part.size)
+			var data []byte
+			start := time.Now()
+			failure = chunkError(ctx)
+			if buffered && failure == nil && atomic.LoadInt32(&failed) == 0 {
+				defer run.memory.release(part.size)
+
+				deadline := run.partDeadline(ctx)
+				failure = run.retry.do("reading "+part.name, func() error {
+					var err error
+					data, err = readPart(run.input, basepath, part.name, part.size, run.readLimit, deadline)
+					return err
+				})
+			} else if buffered {
+				run.memory.release(part.size)
 			}
+			readTime := time.Since(start)
 
 			for atomic.LoadInt32(&currentWriteFileIndex) != listIndex {
 				time.Sleep(10 * time.Microsecond)
 			}
+			if failure == nil {
+				failure = chunkError(ctx)
+			}
+			if failure != nil || atomic.LoadInt32(&failed) != 0 {
+				return
+			}
 
-			log.Printf("[%d / %d]: %s (Read %d bytes)\n", listIndex+1, len(list), list[listIndex].name, len(data))
-			_, _ = f.Write(data)
+			for _, observer := range observers {
+				observer.startPart(part.name)
+			}
 
-			atomic.StoreInt32(&currentWriteFileIndex, listIndex+1)
-		}(int32(idx))
+			if buffered {
+				log.Debugf("[%d / %d]: %s (Read %d bytes in %v)\n", listIndex+1, len(list), part.name, len(data), readTime)
+				var n int
+				n, failure = merged.Write(data)
+				written = int64(n)
+			} else {
+				start = time.Now()
+				// a retry resumes after the bytes already written
+				deadline := run.partDeadline(ctx)
+				failure = run.retry.do("reading "+part.name, func() error {
+					n, err := streamPartFrom(merged, run.input, basepath, part.name, written, run.readBuffer, run.readLimit, deadline)
+					written += n
+					return err
+				})
+				log.Debugf("[%d / %d]: %s (Streamed %d bytes in %v)\n", listIndex+1, len(list), part.name, written, time.Since(start))
+			}
+			// a part truncated since the scan lost bytes that are not in the
+			// output, a compressed one can just be smaller than its content
+			if failure == nil && written < part.size {
+				if size, err := partSize(run.input, basepath, part.name); err != nil || size < part.size {
+					failure = fmt.Errorf("%d of %d bytes merged, the part shrank", written, part.size)
+				}
+			}
+			// the line left open by the part stays in the transformer
+			if failure == nil && run.fsync == fsyncAlways {
+				if failure = out.Flush(); failure == nil {
+					failure = f.Sync()
+				}
+			}
+		})
 	}
 	wg.Wait()
+
+	// report the failures in merge order
+	sort.Slice(failures, func(i, j int) bool {
+		return partPosition(list, failures[i].Name) < partPosition(list, failures[j].Name)
+	})
+	return failures, incomplete
+}
+
+// failAllParts returns a failure for every part of list, keeping the ones in
+// failures and using err for the others
+func failAllParts(list []*logFile, failures []FileFailure, err error) []FileFailure {
+	result := make([]FileFailure, 0, len(list))
+	for _, part := range list {
+		failure := FileFailure{Name: part.name, Err: "output not written: " + err.Error()}
+		for _, previous := range failures {
+			if previous.Name == part.name {
+				failure = previous
+			}
+		}
+		result = append(result, failure)
+	}
+	return result
 }
 
-func DeleteLogList(basepath string, list []*logFile) {
+func partPosition(list []*logFile, name string) int {
+	for idx, part := range list {
+		if part.name == name {
+			return idx
+		}
+	}
+	return len(list)
+}
+
+// readPart loads the whole content of the part in memory, failing if not
+// done before a non zero deadline
+func readPart(fsys fs.FS, basepath, name string, size int64, limiter *rateLimiter, deadline time.Time) ([]byte, error) {
+	in, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+	_, err = buf.ReadFrom(newLimitedReader(newDeadlineReader(in, deadline), limiter))
+	return buf.Bytes(), err
+}
+
+// streamPart copies the content of the part to the output without
+// buffering it in memory, reading bufferSize bytes at a time.
+func streamPart(w io.Writer, fsys fs.FS, basepath, name string, bufferSize int, limiter *rateLimiter) (int64, error) {
+	return streamPartFrom(w, fsys, basepath, name, 0, bufferSize, limiter, time.Time{})
+}
+
+// streamPartFrom streams the part starting at offset, failing if not done
+// before a non zero deadline. The errors writing to w are permanent as they
+// were already retried by the output.
+func streamPartFrom(w io.Writer, fsys fs.FS, basepath, name string, offset int64, bufferSize int, limiter *rateLimiter, deadline time.Time) (int64, error) {
+	f, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := skipPart(f, offset); err != nil {
+		return 0, err
+	}
+	in := newLimitedReader(newDeadlineReader(f, deadline), limiter)
+
+	var written int64
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return written, permanentError{werr}
+			}
+			written += int64(n)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// DeleteEmptyParts deletes the zero length parts, or moves them to the trash
+// when not nil, records them in the report and returns the groups without
+// them. Groups left without parts are dropped.
+func DeleteEmptyParts(basepath string, allFiles FilesList, trash *trashBin, pool *workerPool, report *runReport) FilesList {
+	result := make(FilesList, len(allFiles))
+	for base, list := range allFiles {
+		var kept, empty []*logFile
+		for _, part := range list {
+			if part.size == 0 {
+				empty = append(empty, part)
+			} else {
+				kept = append(kept, part)
+			}
+		}
+		if len(empty) > 0 {
+			DeleteLogList(basepath, empty, trash, pool)
+			report.update(base, func(group *GroupReport) {
+				for _, part := range empty {
+					group.EmptyDeleted = append(group.EmptyDeleted, part.name)
+				}
+				sort.Strings(group.EmptyDeleted)
+			})
+		}
+		if len(kept) > 0 {
+			result[base] = kept
+		}
+	}
+	return result
+}
+
+// DeleteLogList removes the parts of list, or moves them to the trash when
+// not nil, with at most as many files at a time as the pool allows
+func DeleteLogList(basepath string, list []*logFile, trash *trashBin, pool *workerPool) {
 	log.Println("[Start delete of log: ", basepath, "]")
 	wg := &sync.WaitGroup{}
 	for _, logPart := range list {
-		wg.Add(1)
-		log.Println("[Delete ", logPart.name, "]")
-		go func(deleteFile string) {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Errorf("[ERROR]: %v\n", err)
-					log.Errorf("%v\n", string(debug.Stack()))
+		log.Debugln("[Delete ", logPart.name, "]")
+		deleteFile := filepath.Join(basepath, logPart.name)
+		pool.goWait(wg, func() {
+			if trash != nil {
+				if err := trash.move(deleteFile); err != nil {
+					log.Warningf("Trash file error, file kept: %v\n", err)
 				}
-				wg.Done()
-			}()
+				return
+			}
 			if err := os.Remove(deleteFile); err != nil {
 				log.Warningf("Delete file error: %v\n", err)
 			}
-
-		}(filepath.Join(basepath, logPart.name))
+		})
 	}
 	wg.Wait()
 	log.Println("[End delete of log: ", basepath, "]")
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eachLine calls fn with every line of r, the newline included
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	return eachRecord(r, '\n', fn)
+}
+
+// eachRecord calls fn with every record of r ending with delim, the
+// delimiter included
+func eachRecord(r io.Reader, delim byte, fn func(line []byte) error) error {
+	reader := bufio.NewReaderSize(r, defaultReadBuffer)
+	for {
+		line, err := reader.ReadBytes(delim)
+		if len(line) > 0 {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// markerSplit names the outputs of a chunk split at the records containing
+// the marker. The records before the first marker and the header stay in
+// the output of the chunk, the next outputs are named as if they were the
+// last until finish gives them their names.
+type markerSplit struct {
+	marker   []byte
+	dir      string
+	basename string
+	names    *outputNames
+	ifExists string
+	// pieces are the outputs after the one of the chunk
+	pieces []string
+}
+
+func newMarkerSplit(dir, basename string, config *Options, run *mergeRun) *markerSplit {
+	return &markerSplit{
+		marker:   []byte(config.SplitOnMarker),
+		dir:      dir,
+		basename: basename,
+		names:    run.names,
+		ifExists: config.IfExists,
+	}
+}
+
+// create opens the output of the next session
+func (s *markerSplit) create() (*os.File, error) {
+	idx := len(s.pieces) + 1
+	name := filepath.Join(s.dir, s.names.chunk(s.basename, idx, idx+1))
+	f, err := createOutput(name, s.ifExists)
+	if err != nil {
+		return nil, err
+	}
+	trackOutput(name)
+	s.pieces = append(s.pieces, name)
+	log.Println("Created output file: ", name)
+	return f, nil
+}
+
+// outputs returns the output of the chunk at first followed by the pieces
+func (s *markerSplit) outputs(first string) []string {
+	return append([]string{first}, s.pieces...)
+}
+
+// finish moves the outputs, the one of the chunk at first included, with
+// their sidecars to the names they take now that the sessions are known,
+// and returns where they are. A single session keeps the name of the chunk.
+func (s *markerSplit) finish(first string) ([]string, error) {
+	outputs := s.outputs(first)
+	if len(outputs) == 1 {
+		return outputs, nil
+	}
+	// the names are only padded differently, a name taken is never one
+	// still to move
+	for idx, from := range outputs {
+		to := filepath.Join(s.dir, s.names.chunk(s.basename, idx, len(outputs)))
+		if to == from {
+			continue
+		}
+		if err := moveOutput(from, to, s.ifExists); err != nil {
+			return outputs, err
+		}
+		trackOutput(to)
+		outputs[idx] = to
+	}
+	return outputs, nil
+}
+
+// markerWriter passes the records written to it to dst, calling rotate
+// before each record containing marker so that it starts a new output, the
+// first record of an output never does. It is written whole records.
+type markerWriter struct {
+	dst    io.Writer
+	marker []byte
+	delim  byte
+	rotate func() error
+	// started is set once the output has a record, open while the record
+	// being written is not complete
+	started bool
+	open    bool
+}
+
+func newMarkerWriter(dst io.Writer, marker []byte, delim byte, rotate func() error) *markerWriter {
+	return &markerWriter{dst: dst, marker: marker, delim: delim, rotate: rotate}
+}
+
+func (m *markerWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !m.open && m.started && bytes.Contains(p, m.marker) {
+		if err := m.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	m.started = true
+	m.open = p[len(p)-1] != m.delim
+	return m.dst.Write(p)
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// maxPartsAhead limits how many parts can be loaded ahead of the writer
+// when a memory budget is configured
+const maxPartsAhead = 8
+
+// memoryBudget tracks the bytes of part data buffered in memory across all
+// the chunks being merged, a nil budget is unlimited.
+type memoryBudget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit ByteSize) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &memoryBudget{limit: int64(limit)}
+}
+
+// tryAcquire reserves n bytes if they fit in the remaining budget, it never
+// blocks so that a part which does not fit can be streamed instead.
+func (b *memoryBudget) tryAcquire(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *memoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+}
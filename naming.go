@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// outputNameData are the variables available to --name-template
+type outputNameData struct {
+	// Base is the base name of the group
+	Base string
+	// Chunk is the zero padded index of the chunk, empty for a single chunk
+	Chunk string
+	// Date is the day the run started, as 2006-01-02
+	Date string
+	// Time is the time the run started, as 20060102T150405
+	Time string
+	// Host is the name of this machine
+	Host string
+	// Suffix is the value of --suffix
+	Suffix string
+}
+
+// the markers replaced by patterns when the template is turned into a regexp
+const (
+	nameMarkerBase  = "\x00base\x00"
+	nameMarkerChunk = "\x00chunk\x00"
+	nameMarkerDate  = "\x00date\x00"
+	nameMarkerTime  = "\x00time\x00"
+	nameMarkerHost  = "\x00host\x00"
+)
+
+// runStampFormat is the layout of the run start time in the output names
+const runStampFormat = "20060102T150405"
+
+// outputNames names the outputs, with the basename.<suffix>[.<time>][.N].log
+// scheme or with --name-template. A nil *outputNames uses the default suffix.
+type outputNames struct {
+	suffix string
+	// stamped embeds the run start time in the default scheme
+	stamped bool
+	tmpl    *template.Template
+	date    string
+	time    string
+	host    string
+	// outputName and fileName match the outputs, and the outputs with their
+	// sidecars, of a name template
+	outputName *regexp.Regexp
+	fileName   *regexp.Regexp
+}
+
+// newOutputNames validates the suffix and the name template of options
+func newOutputNames(options *Options) (*outputNames, error) {
+	if err := validateSuffix(options.Suffix); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	names := &outputNames{
+		suffix:  outputSuffix(options.Suffix),
+		stamped: options.TimestampedOutput,
+		date:    start.Format("2006-01-02"),
+		time:    start.Format(runStampFormat),
+	}
+	if options.NameTemplate == "" {
+		return names, nil
+	}
+	if options.TimestampedOutput {
+		return nil, &OptionError{"timestamped-output", errors.New("does not apply to --name-template, use {{.Time}} in the template")}
+	}
+
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(options.NameTemplate)
+	if err != nil {
+		return nil, &OptionError{"name-template", err}
+	}
+	names.tmpl = tmpl
+	if names.host, err = os.Hostname(); err != nil || names.host == "" {
+		names.host = "localhost"
+	}
+
+	first, err := names.render(outputNameData{Base: "app", Chunk: "1"})
+	if err != nil {
+		return nil, &OptionError{"name-template", err}
+	}
+	second, _ := names.render(outputNameData{Base: "app", Chunk: "2"})
+	switch {
+	case first == "" || strings.ContainsAny(first, `/\`):
+		return nil, &OptionError{"name-template", fmt.Errorf("gives %q, not a file name", first)}
+	case (options.MaxChunks > 1 || options.SplitOnMarker != "") && first == second:
+		return nil, &OptionError{"name-template", errors.New("does not use {{.Chunk}}, the chunks would overwrite each other")}
+	}
+
+	// render the markers and turn them into patterns
+	marked, _ := names.render(outputNameData{
+		Base:  nameMarkerBase,
+		Chunk: nameMarkerChunk,
+		Date:  nameMarkerDate,
+		Time:  nameMarkerTime,
+		Host:  nameMarkerHost,
+	})
+	pattern := strings.NewReplacer(
+		nameMarkerBase, `[^.]+`,
+		nameMarkerChunk, `(\d+)?`,
+		nameMarkerDate, `\d{4}-\d{2}-\d{2}`,
+		nameMarkerTime, `\d{8}T\d{6}`,
+		nameMarkerHost, `[^/]+`,
+	).Replace(regexp.QuoteMeta(marked))
+	if names.outputName, err = regexp.Compile("^" + pattern + "$"); err != nil {
+		return nil, fmt.Errorf("invalid name template: %v", err)
+	}
+	names.fileName = regexp.MustCompile("^" + pattern + `(\.[^.]+)*$`)
+
+	// outputs that look like parts would be merged again by the next run
+	for _, part := range []string{"app.log", "app.1.log", "app.log.1"} {
+		if names.outputName.MatchString(part) {
+			return nil, fmt.Errorf("name template gives names like the parts, e.g. %s", part)
+		}
+	}
+	return names, nil
+}
+
+func (n *outputNames) render(data outputNameData) (string, error) {
+	if data.Date == "" {
+		data.Date = n.date
+	}
+	if data.Time == "" {
+		data.Time = n.time
+	}
+	if data.Host == "" {
+		data.Host = n.host
+	}
+	data.Suffix = n.suffix
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (n *outputNames) templated() bool {
+	return n != nil && n.tmpl != nil
+}
+
+func (n *outputNames) outputSuffix() string {
+	if n == nil {
+		return aggregatedLogSuffix
+	}
+	return n.suffix
+}
+
+// chunk is the name of the output of a chunk of the group
+func (n *outputNames) chunk(basename string, chunkIdx, chunks int) string {
+	if !n.templated() {
+		suffix := n.outputSuffix()
+		if n != nil && n.stamped {
+			// the time goes between the suffix and the chunk index
+			suffix += "." + n.time
+		}
+		return chunkOutputName(basename, suffix, chunkIdx, chunks)
+	}
+	data := outputNameData{Base: basename}
+	if chunks > 1 {
+		data.Chunk = fmt.Sprintf("%0*d", len(strconv.Itoa(chunks)), chunkIdx+1)
+	}
+	// the template was checked by newOutputNames
+	name, _ := n.render(data)
+	return name
+}
+
+// outputPattern matches the names of the outputs
+func (n *outputNames) outputPattern() *regexp.Regexp {
+	if !n.templated() {
+		return aggregateOutputName(n.outputSuffix())
+	}
+	return n.outputName
+}
+
+// filePattern matches the outputs and the sidecars written next to them
+func (n *outputNames) filePattern() *regexp.Regexp {
+	if !n.templated() {
+		return aggregateFileName(n.outputSuffix())
+	}
+	return n.fileName
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// groupName is the group of the part: the name up to the first dot, in NFC
+// so that the parts copied between macOS, which writes the names decomposed
+// (NFD), and the other systems stay together
+func groupName(name string) string {
+	return norm.NFC.String(strings.Split(name, ".")[0])
+}
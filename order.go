@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// OrderReport summarizes the backwards timestamp jumps found in a merged group
+type OrderReport struct {
+	Jumps     int64
+	MaxSkew   time.Duration
+	Offenders []string
+}
+
+// orderChecker follows the timestamps of the merged lines and records every
+// line older than the previous timestamped line, along with its part.
+// The parts are written one at a time, so it needs no locking.
+type orderChecker struct {
+	part      string
+	last      time.Time
+	prefix    []byte
+	jumps     int64
+	maxSkew   time.Duration
+	offenders map[string]struct{}
+}
+
+func newOrderChecker() *orderChecker {
+	return &orderChecker{
+		prefix:    make([]byte, 0, timestampScanLimit),
+		offenders: make(map[string]struct{}),
+	}
+}
+
+// startPart attributes the following lines to the part named name
+func (o *orderChecker) startPart(name string) {
+	o.endLine()
+	o.part = name
+}
+
+func (o *orderChecker) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c == '\n' {
+			o.endLine()
+			continue
+		}
+		if len(o.prefix) < timestampScanLimit {
+			o.prefix = append(o.prefix, c)
+		}
+	}
+	return len(p), nil
+}
+
+func (o *orderChecker) endLine() {
+	if len(o.prefix) == 0 {
+		return
+	}
+	if ts, ok := ParseTimestamp(string(o.prefix)); ok {
+		if ts.Before(o.last) {
+			o.jumps++
+			if skew := o.last.Sub(ts); skew > o.maxSkew {
+				o.maxSkew = skew
+			}
+			o.offenders[o.part] = struct{}{}
+		}
+		o.last = ts
+	}
+	o.prefix = o.prefix[:0]
+}
+
+func (o *orderChecker) report() OrderReport {
+	o.endLine()
+	result := OrderReport{Jumps: o.jumps, MaxSkew: o.maxSkew}
+	for name := range o.offenders {
+		result.Offenders = append(result.Offenders, name)
+	}
+	sort.Strings(result.Offenders)
+	return result
+}
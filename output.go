@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the --if-exists policies
+const (
+	ifExistsFail      = "fail"
+	ifExistsOverwrite = "overwrite"
+	ifExistsAppend    = "append"
+	ifExistsRename    = "rename"
+)
+
+// the --fsync policies, an empty policy is fsyncEnd
+const (
+	fsyncAlways = "always"
+	fsyncEnd    = "end"
+	fsyncNever  = "never"
+)
+
+// outputSuffix is the marker of the output names, basename.<suffix>.log,
+// the default one when suffix is empty
+func outputSuffix(suffix string) string {
+	if suffix == "" {
+		return aggregatedLogSuffix
+	}
+	return suffix
+}
+
+// validateSuffix rejects the suffixes that would make the outputs look like
+// parts or land outside the input path
+func validateSuffix(suffix string) error {
+	if strings.ContainsAny(suffix, `/\`) || strings.HasPrefix(suffix, ".") || strings.HasSuffix(suffix, ".") {
+		return &OptionError{"suffix", fmt.Errorf("%q is not valid", suffix)}
+	}
+	for _, part := range strings.Split(suffix, ".") {
+		if _, err := strconv.Atoi(part); err == nil || part == "log" {
+			return &OptionError{"suffix", fmt.Errorf("%q would be taken for a part", suffix)}
+		}
+	}
+	return nil
+}
+
+// sidecarSuffixes are the files that can be written next to an output
+var sidecarSuffixes = []string{indexFileSuffix, timeIndexSuffix, histogramSuffix, histogramSuffix + ".json", clustersSuffix, signatureSuffix}
+
+// outputMetadata is applied to the outputs once they are complete
+type outputMetadata struct {
+	mode os.FileMode
+	// uid and gid are -1 when not changed
+	uid, gid int
+	// mtime stamps the outputs with the mtime of their newest part
+	mtime bool
+}
+
+// newOutputMetadata returns nil when no option changes the outputs metadata
+func newOutputMetadata(mode FileMode, owner string, mtime bool) (*outputMetadata, error) {
+	if mode == 0 && owner == "" && !mtime {
+		return nil, nil
+	}
+	meta := &outputMetadata{mode: os.FileMode(mode), uid: -1, gid: -1, mtime: mtime}
+	if owner != "" {
+		if os.Geteuid() != 0 {
+			return nil, fmt.Errorf("--output-owner needs root")
+		}
+		var err error
+		if meta.uid, meta.gid, err = lookupOwner(owner); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// lookupOwner resolves user[:group], by name or numeric id
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName := owner, ""
+	if idx := strings.Index(owner, ":"); idx >= 0 {
+		userName, groupName = owner[:idx], owner[idx+1:]
+	}
+
+	uid, gid := -1, -1
+	if userName != "" {
+		if id, err := strconv.Atoi(userName); err == nil {
+			uid = id
+		} else {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return -1, -1, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupName != "" {
+		if id, err := strconv.Atoi(groupName); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	if uid < 0 && gid < 0 {
+		return -1, -1, fmt.Errorf("invalid owner %q, expected user[:group]", owner)
+	}
+	return uid, gid, nil
+}
+
+// apply sets the metadata of the output at path, made of the parts in list.
+// The mode and the owner are set on the sidecars too.
+func (m *outputMetadata) apply(path string, list []*logFile) error {
+	if m == nil {
+		return nil
+	}
+	files := []string{path}
+	for _, suffix := range sidecarSuffixes {
+		if _, err := os.Stat(path + suffix); err == nil {
+			files = append(files, path+suffix)
+		}
+	}
+	for _, file := range files {
+		if m.mode != 0 {
+			if err := os.Chmod(file, m.mode); err != nil {
+				return err
+			}
+		}
+		if m.uid >= 0 || m.gid >= 0 {
+			if err := os.Chown(file, m.uid, m.gid); err != nil {
+				return err
+			}
+		}
+	}
+	if m.mtime {
+		var newest time.Time
+		for _, part := range list {
+			if part.modTime.After(newest) {
+				newest = part.modTime
+			}
+		}
+		if !newest.IsZero() {
+			return os.Chtimes(path, newest, newest)
+		}
+	}
+	return nil
+}
+
+// discardPartial removes what a failed chunk wrote to the output at path: an
+// output created by the chunk is removed, one appended to is truncated back
+// to its previous size. The sidecars describe the partial content and are
+// removed either way. It returns whether the output is gone.
+func discardPartial(path string, previousSize int64) (bool, error) {
+	for _, suffix := range sidecarSuffixes {
+		_ = os.Remove(path + suffix)
+	}
+	if previousSize > 0 {
+		return false, os.Truncate(path, previousSize)
+	}
+	return true, os.Remove(path)
+}
+
+// errPreallocateUnsupported is returned by preallocate when the platform or
+// the filesystem cannot reserve space, the merge goes on without
+var errPreallocateUnsupported = errors.New("preallocation is not supported")
+
+// preallocateOutput reserves the space of the parts in list at the end of f,
+// it fails only when the space is not there
+func preallocateOutput(f *os.File, list []*logFile) error {
+	var size int64
+	for _, part := range list {
+		size += part.size
+	}
+	if size == 0 {
+		return nil
+	}
+	err := preallocate(f, size)
+	if err == errPreallocateUnsupported {
+		log.Debugf("Not preallocating %s: %v\n", f.Name(), err)
+		return nil
+	}
+	return err
+}
+
+// createOutput opens the output at path applying the policy when the file
+// already exists
+func createOutput(path, ifExists string) (*os.File, error) {
+	_, err := os.Stat(path)
+	exists := err == nil
+
+	switch {
+	case !exists:
+	case ifExists == ifExistsFail:
+		return nil, fmt.Errorf("%s: %w", path, ErrOutputExists)
+	case ifExists == ifExistsAppend:
+		log.Println("Appending to existing output file: ", path)
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	case ifExists == ifExistsRename:
+		backup, err := renameOutput(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Renamed existing output file to: ", backup)
+	default:
+		log.Warnf("Overwriting existing output file: %s\n", path)
+	}
+	// exclusive creation closes the gap between the check and the creation
+	if !exists || ifExists == ifExistsRename {
+		return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	}
+	return os.Create(path)
+}
+
+// renameOutput moves the output and its sidecars to the first free name
+// made of the output name followed by a number
+func renameOutput(path string) (string, error) {
+	for n := 1; ; n++ {
+		backup := path + "." + strconv.Itoa(n)
+		if _, err := os.Lstat(backup); err == nil {
+			continue
+		}
+		if err := os.Rename(path, backup); err != nil {
+			return "", err
+		}
+		for _, suffix := range sidecarSuffixes {
+			if _, err := os.Stat(path + suffix); err == nil {
+				if err := os.Rename(path+suffix, backup+suffix); err != nil {
+					return "", err
+				}
+			}
+		}
+		return backup, nil
+	}
+}
+
+// moveOutput moves the output at from and its sidecars to to, applying the
+// policy when to already exists; the sidecars left by an output overwritten
+// are removed
+func moveOutput(from, to, ifExists string) error {
+	if _, err := os.Stat(to); err == nil {
+		switch ifExists {
+		case ifExistsFail:
+			return fmt.Errorf("%s: %w", to, ErrOutputExists)
+		case ifExistsRename:
+			backup, err := renameOutput(to)
+			if err != nil {
+				return err
+			}
+			log.Println("Renamed existing output file to: ", backup)
+		default:
+			log.Warnf("Overwriting existing output file: %s\n", to)
+		}
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	for _, suffix := range sidecarSuffixes {
+		if _, err := os.Stat(from + suffix); err == nil {
+			if err := os.Rename(from+suffix, to+suffix); err != nil {
+				return err
+			}
+		} else {
+			_ = os.Remove(to + suffix)
+		}
+	}
+	return nil
+}
+
+// primeSidecars feeds the content already in an output opened for appending
+// to the sidecars, so they describe the whole file
+func primeSidecars(f *os.File, sidecars []sidecarWriter) error {
+	if len(sidecars) == 0 {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	existing, err := os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	defer existing.Close()
+	header, err := headerSize(existing)
+	if err != nil {
+		return err
+	}
+	skipHeaders(sidecars, header)
+	if _, err := existing.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	writers := make([]io.Writer, len(sidecars))
+	for idx, sidecar := range sidecars {
+		writers[idx] = sidecar
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), io.LimitReader(existing, info.Size()))
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"runtime/debug"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultWorkers bounds the files open at the same time when --workers is
+// not set, well below the usual ulimit of 1024
+const defaultWorkers = 64
+
+// workerPool bounds the goroutines reading, writing or deleting parts across
+// all the groups of a run, so directories with many thousands of parts do not
+// exhaust the file descriptors. A nil pool does not bound anything.
+type workerPool struct {
+	slots chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = defaultWorkers
+	}
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+// goWait runs fn in a new goroutine once a slot is free, blocking the caller
+// until then, and marks wg done when fn returns. Panics are logged.
+func (p *workerPool) goWait(wg *sync.WaitGroup, fn func()) {
+	wg.Add(1)
+	if p != nil {
+		p.slots <- struct{}{}
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("[ERROR]: %v\n", err)
+				log.Errorf("%v\n", string(debug.Stack()))
+			}
+			if p != nil {
+				<-p.slots
+			}
+			wg.Done()
+		}()
+		fn()
+	}()
+}
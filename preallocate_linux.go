@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize allocates the blocks without changing the size of the file,
+// an output shorter than its inputs is not left with trailing zeros
+const fallocKeepSize = 0x01
+
+// preallocate reserves size bytes after the current end of f
+func preallocate(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = syscall.Fallocate(int(f.Fd()), fallocKeepSize, info.Size(), size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS || err == syscall.EINVAL {
+		return errPreallocateUnsupported
+	}
+	return err
+}
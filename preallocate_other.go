@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+func preallocate(f *os.File, size int64) error {
+	return errPreallocateUnsupported
+}
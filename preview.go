@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+)
+
+// PreviewLine is a line shown by the preview, with the part it comes from
+type PreviewLine struct {
+	Part string
+	Line string
+}
+
+// GroupPreview are the first lines of a group containing each --contains
+// text, and the first ones the filter drops
+type GroupPreview struct {
+	Name     string
+	Matched  [][]PreviewLine
+	Rejected []PreviewLine
+	Err      error
+}
+
+// PreviewFilter reads the parts of every group in merge order through the
+// --contains filter until it has n lines containing each text and n lines
+// dropped, without writing anything
+func PreviewFilter(fsys fs.FS, basepath string, allFiles FilesList, options *Options, n int) []GroupPreview {
+	filter := newLineMatcher(options.Contains, options.IgnoreCase, options.WordRegexp)
+	matchers := make([]*lineMatcher, len(options.Contains))
+	for idx, text := range options.Contains {
+		matchers[idx] = newLineMatcher([]string{text}, options.IgnoreCase, options.WordRegexp)
+	}
+	delim := options.RecordDelimiter.value()
+
+	bases := make([]string, 0, len(allFiles))
+	for base := range allFiles {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	result := make([]GroupPreview, 0, len(bases))
+	for _, base := range bases {
+		list := allFiles[base]
+		SortLogList(list, options.newestFirst())
+		group := GroupPreview{Name: base, Matched: make([][]PreviewLine, len(matchers))}
+		for _, part := range list {
+			full, err := previewPart(fsys, basepath, part.name, &group, filter, matchers, delim, n)
+			if err != nil {
+				group.Err = fmt.Errorf("%s: %v", part.name, err)
+				break
+			}
+			if full {
+				break
+			}
+		}
+		result = append(result, group)
+	}
+	return result
+}
+
+// errPreviewFull stops the reading of a part once the preview has all its lines
+var errPreviewFull = errors.New("preview full")
+
+// previewPart adds the lines of the part to the preview of the group and
+// returns whether the preview has all its lines
+func previewPart(fsys fs.FS, basepath, name string, group *GroupPreview, filter *lineMatcher, matchers []*lineMatcher, delim byte, n int) (bool, error) {
+	f, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	full := func() bool {
+		if len(group.Rejected) < n {
+			return false
+		}
+		for _, matched := range group.Matched {
+			if len(matched) < n {
+				return false
+			}
+		}
+		return true
+	}
+	err = eachRecord(f, delim, func(line []byte) error {
+		text := string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{delim}), []byte("\r")))
+		if !filter.matches(line) {
+			if len(group.Rejected) < n {
+				group.Rejected = append(group.Rejected, PreviewLine{Part: name, Line: text})
+			}
+		} else {
+			for idx, matcher := range matchers {
+				if len(group.Matched[idx]) < n && len(matcher.texts) > 0 && matcher.matches(line) {
+					group.Matched[idx] = append(group.Matched[idx], PreviewLine{Part: name, Line: text})
+				}
+			}
+		}
+		if full() {
+			return errPreviewFull
+		}
+		return nil
+	})
+	if err == errPreviewFull {
+		return true, nil
+	}
+	return false, err
+}
+
+// WritePreview prints the lines of the preview of every group, under the
+// text they contain
+func WritePreview(w io.Writer, previews []GroupPreview, texts []string) error {
+	for _, group := range previews {
+		if _, err := fmt.Fprintf(w, "[%s]\n", group.Name); err != nil {
+			return err
+		}
+		if group.Err != nil {
+			fmt.Fprintf(w, "  error: %v\n", group.Err)
+			continue
+		}
+		section := func(title string, lines []PreviewLine) {
+			fmt.Fprintf(w, "  %s, %d lines:\n", title, len(lines))
+			for _, line := range lines {
+				fmt.Fprintf(w, "    %s: %s\n", line.Part, line.Line)
+			}
+		}
+		for idx, text := range texts {
+			section("Containing "+strconv.Quote(text), group.Matched[idx])
+		}
+		section("Dropped", group.Rejected)
+	}
+	return nil
+}
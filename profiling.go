@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StartProfiling enables the cpu profile and execution trace requested in the
+// options, the returned function stops them and writes the memory profile.
+func StartProfiling(options *Options) (func(), error) {
+	var cleanups []func()
+	stop := func() {
+		for idx := len(cleanups) - 1; idx >= 0; idx-- {
+			cleanups[idx]()
+		}
+	}
+
+	if options.CPUProfile != "" {
+		f, err := os.Create(string(options.CPUProfile))
+		if err != nil {
+			return stop, fmt.Errorf("cannot create cpu profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			_ = f.Close()
+			return stop, fmt.Errorf("cannot start cpu profile: %v", err)
+		}
+		cleanups = append(cleanups, func() {
+			pprof.StopCPUProfile()
+			_ = f.Close()
+		})
+	}
+
+	if options.Trace != "" {
+		f, err := os.Create(string(options.Trace))
+		if err != nil {
+			stop()
+			return func() {}, fmt.Errorf("cannot create trace: %v", err)
+		}
+		if err := trace.Start(f); err != nil {
+			_ = f.Close()
+			stop()
+			return func() {}, fmt.Errorf("cannot start trace: %v", err)
+		}
+		cleanups = append(cleanups, func() {
+			trace.Stop()
+			_ = f.Close()
+		})
+	}
+
+	if options.MemProfile != "" {
+		cleanups = append(cleanups, func() {
+			f, err := os.Create(string(options.MemProfile))
+			if err != nil {
+				log.Errorf("[ERROR]: Cannot create memory profile: %v\n", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Errorf("[ERROR]: Cannot write memory profile: %v\n", err)
+			}
+		})
+	}
+
+	return stop, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateBurst is the amount of unused throughput that can be spent at once
+// after an idle period
+const rateBurst = 100 * time.Millisecond
+
+// rateLimiter throttles the bytes transferred per second, it is safe for
+// concurrent use and a nil limiter does not throttle.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond Rate) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// wait blocks until n more bytes can be transferred within the rate
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now.Add(-rateBurst)) {
+		l.next = now.Add(-rateBurst)
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.limiter.wait(n)
+	return n, err
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.limiter.wait(len(p))
+	return lw.w.Write(p)
+}
+
+// newLimitedReader wraps r so its throughput respects the limiter
+func newLimitedReader(r io.Reader, limiter *rateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: limiter}
+}
+
+// newLimitedWriter wraps w so its throughput respects the limiter
+func newLimitedWriter(w io.Writer, limiter *rateLimiter) io.Writer {
+	if limiter == nil {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: limiter}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The remote sinks send the stream in batches of remoteBatchSize, keeping
+// at most remoteQueued batches in memory. A failed send reconnects and is
+// retried remoteRetries times, the wait starting at remoteBackoff.
+const (
+	remoteBatchSize = 64 << 10
+	remoteQueued    = 16
+	remoteRetries   = 5
+	remoteBackoff   = 200 * time.Millisecond
+)
+
+// remoteSink sends the merged stream to a tcp address without slowing the
+// merge down: the batches the connection cannot take yet wait in memory and,
+// past remoteQueued, in a spool file, so a slow sink neither blocks the
+// reader nor makes it run out of memory. The batches are sent in order, a
+// batch resent after a reconnection may be received twice.
+type remoteSink struct {
+	addr  string
+	retry *retryPolicy
+	conn  net.Conn
+
+	mu    sync.Mutex
+	ready *sync.Cond
+	batch []byte
+	queue [][]byte
+	// spool holds the batches after the queue, from spoolRead to spoolSize
+	spool     *os.File
+	spoolRead int64
+	spoolSize int64
+	closed    bool
+	err       error
+	done      chan struct{}
+}
+
+func newRemoteSink(addr string) (*remoteSink, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &remoteSink{
+		addr:  addr,
+		retry: newRetryPolicy(remoteRetries, remoteBackoff),
+		conn:  conn,
+		done:  make(chan struct{}),
+	}
+	s.ready = sync.NewCond(&s.mu)
+	go s.send()
+	return s, nil
+}
+
+// Write adds p to the current batch, it fails once the sink gave up
+func (s *remoteSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.batch = append(s.batch, p...)
+	if len(s.batch) >= remoteBatchSize {
+		if err := s.enqueue(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// enqueue passes the current batch to the sender, spooling it when the queue
+// is full or the spool is not drained yet. Called with mu held.
+func (s *remoteSink) enqueue() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	batch := s.batch
+	s.batch = nil
+	if len(s.queue) < remoteQueued && s.spoolSize == s.spoolRead {
+		s.queue = append(s.queue, batch)
+		s.ready.Signal()
+		return nil
+	}
+	if s.spool == nil {
+		spool, err := ioutil.TempFile("", "aggregatelogs-spool-")
+		if err != nil {
+			return err
+		}
+		log.Debugf("Spooling the batches for %s to %s\n", s.addr, spool.Name())
+		s.spool = spool
+	}
+	if _, err := s.spool.WriteAt(batch, s.spoolSize); err != nil {
+		return err
+	}
+	s.spoolSize += int64(len(batch))
+	s.ready.Signal()
+	return nil
+}
+
+// next returns the batch to send, waiting for one, or nil once the sink is
+// closed and everything is sent
+func (s *remoteSink) next() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && s.spoolRead == s.spoolSize && !s.closed {
+		s.ready.Wait()
+	}
+	if len(s.queue) > 0 {
+		batch := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		return batch, nil
+	}
+	if s.spoolRead == s.spoolSize {
+		return nil, nil
+	}
+	size := s.spoolSize - s.spoolRead
+	if size > remoteBatchSize {
+		size = remoteBatchSize
+	}
+	batch := make([]byte, size)
+	if _, err := s.spool.ReadAt(batch, s.spoolRead); err != nil {
+		return nil, err
+	}
+	s.spoolRead += size
+	// a drained spool starts over, the new batches go to the queue again
+	if s.spoolRead == s.spoolSize {
+		s.spoolRead, s.spoolSize = 0, 0
+		if err := s.spool.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+// send writes the batches to the connection until the sink is closed or a
+// batch cannot be sent
+func (s *remoteSink) send() {
+	defer close(s.done)
+	for {
+		batch, err := s.next()
+		if err == nil && batch == nil {
+			return
+		}
+		if err == nil {
+			err = s.retry.do("sending to "+s.addr, func() error {
+				return s.sendBatch(batch)
+			})
+		}
+		if err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// sendBatch writes the batch to the connection, reconnecting first when the
+// previous send failed
+func (s *remoteSink) sendBatch(batch []byte) error {
+	if s.conn == nil {
+		conn, err := net.Dial("tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(batch); err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close sends what is left and closes the connection
+func (s *remoteSink) Close() error {
+	s.mu.Lock()
+	err := s.enqueue()
+	s.closed = true
+	s.ready.Signal()
+	s.mu.Unlock()
+	<-s.done
+
+	if s.err != nil {
+		err = s.err
+	}
+	if s.conn != nil {
+		if closeErr := s.conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if s.spool != nil {
+		_ = s.spool.Close()
+		_ = os.Remove(s.spool.Name())
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"time"
+)
+
+// replayPacer spaces the lines sent to the tee sinks so that a backfill of
+// old logs into a live system does not arrive as a burst: at most a number
+// of lines per second and, in realtime, with the spacing of the timestamps
+// of the lines. The merge waits for it, so the outputs are written at the
+// same pace.
+type replayPacer struct {
+	limiter  *rateLimiter
+	realtime bool
+	delim    byte
+	partial  []byte
+	// first is the first timestamp replayed, at the time start
+	first time.Time
+	start time.Time
+}
+
+func newReplayPacer(rate LineRate, realtime bool, delim byte) *replayPacer {
+	if rate <= 0 && !realtime {
+		return nil
+	}
+	pacer := &replayPacer{realtime: realtime, delim: delim}
+	if rate > 0 {
+		pacer.limiter = &rateLimiter{rate: float64(rate)}
+	}
+	return pacer
+}
+
+// pace calls send with each complete line of p once its time has come, the
+// line left open is kept for the next call
+func (p *replayPacer) pace(data []byte, send func(line []byte)) {
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, p.delim)
+		if idx < 0 {
+			p.partial = append(p.partial, data...)
+			return
+		}
+		line := data[:idx+1]
+		if len(p.partial) > 0 {
+			line = append(p.partial, line...)
+			p.partial = p.partial[:0]
+		}
+		p.wait(line)
+		send(line)
+		data = data[idx+1:]
+	}
+}
+
+// flush sends the line left open without waiting
+func (p *replayPacer) flush(send func(line []byte)) {
+	if len(p.partial) > 0 {
+		send(p.partial)
+		p.partial = p.partial[:0]
+	}
+}
+
+// wait blocks until line can be sent, the lines without a timestamp or
+// going back in time are only held by the rate
+func (p *replayPacer) wait(line []byte) {
+	if p.realtime {
+		if ts, ok := ParseTimestamp(string(line[:minInt(len(line), timestampScanLimit)])); ok {
+			if p.first.IsZero() {
+				p.first, p.start = ts, time.Now()
+			} else if delay := time.Until(p.start.Add(ts.Sub(p.first))); delay > 0 {
+				time.Sleep(delay)
+			}
+		}
+	}
+	p.limiter.wait(1)
+}
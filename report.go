@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FileFailure is a file that could not be scanned or merged
+type FileFailure struct {
+	Name string
+	Err  string
+}
+
+// GroupReport collects what happened while merging a base name group
+type GroupReport struct {
+	Name     string
+	Order    *OrderReport
+	Failures []FileFailure
+	// Aborted is set when the merge stopped at the first failure
+	Aborted bool
+	// EmptyDeleted are the zero length parts deleted by --delete-empty
+	EmptyDeleted []string
+	// Outputs are the paths of the files written by the merge
+	Outputs []string
+	// Partial are the outputs of the failed chunks, what the chunks wrote
+	// is removed unless --keep-partial
+	Partial []string
+	// Unchanged is set when --skip-unchanged found nothing to merge
+	Unchanged bool
+	// Sessions are the application runs found by --session-marker or
+	// --session-gap
+	Sessions []SessionReport
+}
+
+// runReport gathers the group reports of a run, groups can be merged
+// concurrently so the access is synchronized
+type runReport struct {
+	mu     sync.Mutex
+	groups map[string]*GroupReport
+}
+
+func newRunReport() *runReport {
+	return &runReport{groups: make(map[string]*GroupReport)}
+}
+
+// update applies fn to the report of the named group, creating it when missing
+func (r *runReport) update(name string, fn func(*GroupReport)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report, exists := r.groups[name]
+	if !exists {
+		report = &GroupReport{Name: name}
+		r.groups[name] = report
+	}
+	fn(report)
+}
+
+// Groups returns the group reports sorted by name
+func (r *runReport) Groups() []GroupReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]GroupReport, 0, len(r.groups))
+	for _, report := range r.groups {
+		result = append(result, *report)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func formatSessionTime(ts time.Time) string {
+	if ts.IsZero() {
+		return "?"
+	}
+	return ts.Format(time.RFC3339)
+}
+
+// logSummary writes the run summary to the tool's log
+func (r *runReport) logSummary() {
+	groups := r.Groups()
+	if len(groups) == 0 {
+		return
+	}
+	log.Println("[Summary]")
+	for _, group := range groups {
+		if group.Unchanged {
+			log.Printf("%s: unchanged since the last merge, skipped\n", group.Name)
+		}
+		if len(group.EmptyDeleted) > 0 {
+			log.Printf("%s: deleted %d empty parts: %s\n", group.Name, len(group.EmptyDeleted), strings.Join(group.EmptyDeleted, ", "))
+		}
+		for _, failure := range group.Failures {
+			log.Warnf("%s: skipped %s: %s\n", group.Name, failure.Name, failure.Err)
+		}
+		for _, partial := range group.Partial {
+			log.Errorf("%s: chunk of %s failed, its output is incomplete\n", group.Name, partial)
+		}
+		if group.Aborted {
+			log.Errorf("%s: merge aborted, the output is incomplete and no part was deleted\n", group.Name)
+		}
+		if len(group.Sessions) > 0 {
+			log.Printf("%s: %d sessions\n", group.Name, len(group.Sessions))
+			for idx, session := range group.Sessions {
+				log.Printf("  #%d %s - %s, %d lines, from %s\n", idx+1,
+					formatSessionTime(session.Start), formatSessionTime(session.End), session.Lines, session.Part)
+			}
+		}
+		if order := group.Order; order != nil {
+			if order.Jumps == 0 {
+				log.Printf("%s: timestamps in order\n", group.Name)
+			} else {
+				log.Warnf("%s: %d backwards timestamp jumps, largest skew %v, in parts: %s\n",
+					group.Name, order.Jumps, order.MaxSkew, strings.Join(order.Offenders, ", "))
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// retryPolicy repeats the operations failing with a transient error, waiting
+// backoff before the first retry and doubling the wait after each one. A nil
+// policy does not retry.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+func newRetryPolicy(retries int, backoff time.Duration) *retryPolicy {
+	if retries <= 0 {
+		return nil
+	}
+	return &retryPolicy{attempts: retries, backoff: backoff}
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// isTransient tells whether an operation failed with err can succeed later,
+// missing files and denied permissions do not change by waiting
+func isTransient(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	return !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission)
+}
+
+// do runs fn until it succeeds, fails permanently or the retries are over
+func (r *retryPolicy) do(what string, fn func() error) error {
+	err := fn()
+	if r == nil {
+		return err
+	}
+	wait := r.backoff
+	for attempt := 1; attempt <= r.attempts && err != nil && isTransient(err); attempt++ {
+		log.Warnf("Retrying %s in %v (%d / %d): %v\n", what, wait, attempt, r.attempts, err)
+		time.Sleep(wait)
+		wait *= 2
+		err = fn()
+	}
+	return err
+}
+
+// retryWriter retries the writes to w, resuming after the bytes written
+type retryWriter struct {
+	w     io.Writer
+	what  string
+	retry *retryPolicy
+}
+
+func newRetryWriter(w io.Writer, what string, retry *retryPolicy) io.Writer {
+	if retry == nil {
+		return w
+	}
+	return &retryWriter{w: w, what: what, retry: retry}
+}
+
+func (r *retryWriter) Write(p []byte) (int, error) {
+	written := 0
+	err := r.retry.do(r.what, func() error {
+		n, err := r.w.Write(p[written:])
+		written += n
+		return err
+	})
+	return written, err
+}
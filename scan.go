@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	log "github.com/sirupsen/logrus"
+)
+
+// tempFileSuffixes mark editor swap and backup files and unfinished downloads
+var tempFileSuffixes = []string{"~", ".swp", ".swo", ".swx", ".part", ".tmp", ".crdownload"}
+
+// scanOptions select the parts found by ScanFolder
+type scanOptions struct {
+	// input is the directory of the parts, the one of the logrotate stanza
+	// with --logrotate
+	input flags.Filename
+	// names are the outputs, never taken as parts
+	names *outputNames
+	// followSymlinks resolves the input path and the symlinked parts
+	followSymlinks bool
+	// includeHidden keeps the dotfiles and the temporary files
+	includeHidden bool
+	// minSize, maxSize, minAge and maxAge select the parts by size and by
+	// age of their last modification, 0 does not limit
+	minSize, maxSize int64
+	minAge, maxAge   time.Duration
+	now              time.Time
+	// fromDate and toDate select the parts by the date in their name, both
+	// days included, zero does not limit
+	fromDate, toDate time.Time
+	// logrotate, when set, decides which files are parts instead of the
+	// .log in the name, rotatedParts matches them
+	logrotate    *logrotateStanza
+	rotatedParts *regexp.Regexp
+	// onError decides whether the scan stops on an error about a single
+	// entry, nil stops at the first one
+	onError func(path string, err error) error
+}
+
+// admits tells whether the file can be a part by its name: not an output or
+// one of its sidecars, not hidden or temporary unless included, and dated
+// within the selected days
+func (s *scanOptions) admits(name string, outputName *regexp.Regexp) bool {
+	// ignore previous runs as they'll be overwritten later, with their
+	// sidecars
+	if !s.isPart(name) || outputName.MatchString(name) {
+		return false
+	}
+	if !s.includeHidden && isHiddenOrTemp(name) {
+		log.Debugln("Skipping hidden or temporary file: ", name)
+		return false
+	}
+	if !s.selectsDate(name) {
+		log.Debugln("Skipping by date: ", name)
+		return false
+	}
+	return true
+}
+
+// newScanOptions returns the scan options of options. With --logrotate the
+// input path is the one of the stanza.
+func newScanOptions(options *Options) (*scanOptions, error) {
+	names, err := newOutputNames(options)
+	if err != nil {
+		return nil, err
+	}
+	var stanza *logrotateStanza
+	if options.Logrotate != "" {
+		if stanza, err = loadLogrotate(string(options.Logrotate)); err != nil {
+			return nil, &OptionError{"logrotate", err}
+		}
+	}
+	fromDate, err := parseDateFlag("from-date", options.FromDate)
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseDateFlag("to-date", options.ToDate)
+	if err != nil {
+		return nil, err
+	}
+	if !fromDate.IsZero() && !toDate.IsZero() && toDate.Before(fromDate) {
+		return nil, &OptionError{"to-date", fmt.Errorf("%s is before --from-date %s", options.ToDate, options.FromDate)}
+	}
+	scan := &scanOptions{
+		input:          options.Input,
+		names:          names,
+		followSymlinks: options.FollowSymlinks,
+		includeHidden:  options.IncludeHidden,
+		minSize:        int64(options.MinSize),
+		maxSize:        int64(options.MaxSizeInput),
+		minAge:         options.MinAge,
+		maxAge:         options.MaxAge,
+		now:            time.Now(),
+		fromDate:       fromDate,
+		toDate:         toDate,
+	}
+	if stanza != nil {
+		scan.input = flags.Filename(stanza.inputDir())
+		scan.logrotate = stanza
+		scan.rotatedParts = stanza.partPattern()
+	}
+	scan.input = flags.Filename(longPath(string(scan.input)))
+	return scan, nil
+}
+
+// isPart tells whether the file is a part, by default anything with .log in
+// the name, in any case, as .log might be in the middle because of the split
+// ".1"
+func (scan *scanOptions) isPart(name string) bool {
+	if scan.logrotate == nil {
+		return strings.Contains(strings.ToLower(name), ".log")
+	}
+	// the compressed parts are matched too, openPart decompresses them
+	return scan.rotatedParts.MatchString(name)
+}
+
+// parseDateFlag parses a day, e.g. 2021-03-01, the time is ignored
+func parseDateFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return day, nil
+	}
+	ts, err := parseTimeFlag(name, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// filenameDatePattern matches the dates of the rotated file names, like
+// app.log.2021-03-01 or app.20210301.log
+var filenameDatePattern = regexp.MustCompile(`(?:^|\D)(\d{4})[-_]?(\d{2})[-_]?(\d{2})(?:\D|$)`)
+
+// filenameDate extracts the date encoded in the name of a part
+func filenameDate(name string) (time.Time, bool) {
+	match := filenameDatePattern.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false
+	}
+	day, err := time.Parse("20060102", match[1]+match[2]+match[3])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// selectsDate tells whether the date in the name is within the range, the
+// parts without a date are left out once a range is set
+func (scan *scanOptions) selectsDate(name string) bool {
+	if scan.fromDate.IsZero() && scan.toDate.IsZero() {
+		return true
+	}
+	day, ok := filenameDate(name)
+	if !ok {
+		return false
+	}
+	return !day.Before(scan.fromDate) && (scan.toDate.IsZero() || !day.After(scan.toDate))
+}
+
+// selects tells whether the part described by info is within the size and
+// age limits
+func (scan *scanOptions) selects(info os.FileInfo) bool {
+	if scan.minSize > 0 && info.Size() < scan.minSize {
+		return false
+	}
+	if scan.maxSize > 0 && info.Size() > scan.maxSize {
+		return false
+	}
+	now := scan.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	age := now.Sub(info.ModTime())
+	if scan.minAge > 0 && age < scan.minAge {
+		return false
+	}
+	if scan.maxAge > 0 && age > scan.maxAge {
+		return false
+	}
+	return true
+}
+
+// isHiddenOrTemp tells whether name is a dotfile, an editor temporary file or
+// a download in progress, none of them a complete part
+func isHiddenOrTemp(name string) bool {
+	if strings.HasPrefix(name, ".") || (strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#")) {
+		return true
+	}
+	for _, suffix := range tempFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+type SearchCommand struct {
+	Query    string `long:"query" description:"Text to search for" required:"yes"`
+	NoIndex  bool   `long:"no-index" description:"Ignore the index sidecars and scan the whole files"`
+	Parts    bool   `long:"parts" description:"Also search the raw parts found in the input path"`
+	Parallel int    `long:"parallel" description:"Number of files searched concurrently, default 0 means one per CPU" default:"0"`
+	Args     struct {
+		Files []string `positional-arg-name:"file" description:"Files to search, default all the aggregates in the input path"`
+	} `positional-args:"yes"`
+
+	options *Options
+}
+
+func (c *SearchCommand) Execute(args []string) error {
+	if err := ConfigureLogging(c.options); err != nil {
+		return err
+	}
+	scan, err := newScanOptions(c.options)
+	if err != nil {
+		return err
+	}
+	// the aggregates of a support bundle are next to the archive
+	input, basepath, release, err := openInput(string(scan.input))
+	if err != nil {
+		return err
+	}
+	defer release()
+	files := c.Args.Files
+	if len(files) == 0 {
+		if files, err = FindAggregateOutputs(basepath, scan.names); err != nil {
+			return err
+		}
+	}
+	var archiveParts []string
+	if c.Parts {
+		allFiles, err := scanInput(input, basepath, scan)
+		if err != nil {
+			return err
+		}
+		bases := make([]string, 0, len(allFiles))
+		for base := range allFiles {
+			bases = append(bases, base)
+		}
+		sort.Strings(bases)
+		for _, base := range bases {
+			list := allFiles[base]
+			SortLogList(list, c.options.newestFirst())
+			for _, part := range list {
+				if input != nil {
+					archiveParts = append(archiveParts, part.name)
+				} else {
+					files = append(files, filepath.Join(basepath, part.name))
+				}
+			}
+		}
+	}
+
+	emit := func(match SearchMatch) {
+		fmt.Printf("%s:%d:%s\n", match.File, match.Line, match.Text)
+	}
+	delim := c.options.RecordDelimiter.value()
+	if err := SearchFiles(files, c.Query, !c.NoIndex, c.Parallel, delim, emit); err != nil {
+		return err
+	}
+	return SearchArchiveParts(input, string(scan.input), archiveParts, c.Query, delim, emit)
+}
+
+// SearchArchiveParts searches the parts of the archive one after the other,
+// the matches are reported in the archive path
+func SearchArchiveParts(fsys fs.FS, archive string, names []string, query string, delim byte, emit func(SearchMatch)) error {
+	for _, name := range names {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return fmt.Errorf("searching %s: %v", name, err)
+		}
+		err = searchReader(f, filepath.Join(archive, name), query, 0, delim, emit)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("searching %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// SearchFiles searches the files concurrently, parallel at a time, and
+// reports the matches in the order of the files. All the files are searched
+// even when some fail, the first error is returned.
+func SearchFiles(files []string, query string, useIndex bool, parallel int, delim byte, emit func(SearchMatch)) error {
+	if parallel < 1 {
+		parallel = runtime.NumCPU()
+	}
+
+	type fileResult struct {
+		matches []SearchMatch
+		err     error
+		done    chan struct{}
+	}
+	results := make([]*fileResult, len(files))
+	for idx := range results {
+		results[idx] = &fileResult{done: make(chan struct{})}
+	}
+
+	slots := make(chan struct{}, parallel)
+	go func() {
+		for idx, file := range files {
+			slots <- struct{}{}
+			go func(result *fileResult, file string) {
+				defer func() {
+					<-slots
+					close(result.done)
+				}()
+				result.err = SearchFile(file, query, useIndex, delim, func(match SearchMatch) {
+					result.matches = append(result.matches, match)
+				})
+			}(results[idx], file)
+		}
+	}()
+
+	var firstErr error
+	for idx, result := range results {
+		<-result.done
+		for _, match := range result.matches {
+			emit(match)
+		}
+		if result.err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("searching %s: %v", files[idx], result.err)
+		}
+		// release the matches already reported
+		results[idx] = nil
+	}
+	return firstErr
+}
+
+// FindAggregateOutputs lists the aggregates previously produced in basepath
+// with the given names
+func FindAggregateOutputs(basepath string, names *outputNames) ([]string, error) {
+	entries, err := ioutil.ReadDir(basepath)
+	if err != nil {
+		return nil, err
+	}
+	outputName := names.outputPattern()
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && outputName.MatchString(entry.Name()) {
+			files = append(files, filepath.Join(basepath, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
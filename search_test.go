@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	req "github.com/stretchr/testify/require"
+)
+
+func init() {
+	AllTestSuites = append(AllTestSuites, &SearchSuite{})
+}
+
+type SearchSuite struct {
+	BaseSuite
+}
+
+func (s *SearchSuite) BeforeTest(suiteName, testName string) {
+	s.DeleteLogDir()
+}
+
+func (s *SearchSuite) collect(path, query string, useIndex bool) []SearchMatch {
+	var matches []SearchMatch
+	err := SearchFile(path, query, useIndex, '\n', func(match SearchMatch) {
+		matches = append(matches, match)
+	})
+	req.NoError(s.T(), err)
+	return matches
+}
+
+func (s *SearchSuite) TestIndexedSearch() {
+	s.GenerateLog("out", 5)
+
+	result := MainRoutine(&Options{
+		Input: "tempTest",
+		Index: true,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	index, err := loadIndex("tempTest/out.full.log")
+	req.NoError(s.T(), err, "Index was not written")
+	blocks, ok := index.candidateBlocks("[Line 12345]")
+	req.True(s.T(), ok)
+	req.True(s.T(), len(blocks) < len(index.BlockOffset)/2, "Index did not narrow the search")
+
+	matches := s.collect("tempTest/out.full.log", "[Line 12345]", true)
+	req.Equal(s.T(), []SearchMatch{{File: "tempTest/out.full.log", Line: 12346, Text: "[Line 12345]"}}, matches)
+	req.Equal(s.T(), matches, s.collect("tempTest/out.full.log", "[Line 12345]", false), "Indexed and full scan results differ")
+
+	req.Len(s.T(), s.collect("tempTest/out.full.log", "Line 1999", true), 11, "Wrong number of indexed matches")
+	req.Empty(s.T(), s.collect("tempTest/out.full.log", "missing", true))
+}
+
+func (s *SearchSuite) TestIndexedSearchDelimiter() {
+	// the records span two lines, the blocks count records
+	var records strings.Builder
+	for idx := 0; idx < 3*indexBlockLines; idx++ {
+		fmt.Fprintf(&records, "[Record %d]\nmore\x00", idx)
+	}
+	req.NoError(s.T(), os.Mkdir("tempTest", 0777))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log", []byte(records.String()), 0644))
+
+	var delim Delimiter
+	req.NoError(s.T(), delim.UnmarshalFlag(`\0`))
+	result := MainRoutine(&Options{Input: "tempTest", Index: true, RecordDelimiter: delim})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	index, err := loadIndex("tempTest/app.full.log")
+	req.NoError(s.T(), err)
+	req.Equal(s.T(), byte(0), index.Delimiter)
+	req.Len(s.T(), index.BlockOffset, 4)
+	blocks, ok := index.candidateBlocks("[Record 2500]")
+	req.True(s.T(), ok)
+	req.Contains(s.T(), blocks, uint32(2))
+	req.NotContains(s.T(), blocks, uint32(1), "Index did not narrow the search")
+
+	expected := []SearchMatch{{File: "tempTest/app.full.log", Line: 2501, Text: "[Record 2500]\nmore"}}
+	req.Equal(s.T(), expected, s.collect("tempTest/app.full.log", "[Record 2500]", true))
+	var matches []SearchMatch
+	req.NoError(s.T(), SearchFile("tempTest/app.full.log", "[Record 2500]", false, 0, func(match SearchMatch) {
+		matches = append(matches, match)
+	}))
+	req.Equal(s.T(), expected, matches, "Indexed and full scan results differ")
+}
+
+func (s *SearchSuite) TestParallelSearch() {
+	s.GenerateLog("out", 10)
+
+	result := MainRoutine(&Options{
+		Input:     "tempTest",
+		MaxChunks: 5,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	files, err := FindAggregateOutputs("tempTest", nil)
+	req.NoError(s.T(), err)
+	files = append(files, "tempTest/out.1.log", "tempTest/missing.log")
+
+	var matches []SearchMatch
+	err = SearchFiles(files, "[Line 39999]", true, 3, '\n', func(match SearchMatch) {
+		matches = append(matches, match)
+	})
+	req.Error(s.T(), err, "Missing file was not reported")
+
+	// line 39999 is the last of the merge, it is in the last chunk and in part 1
+	req.Equal(s.T(), []SearchMatch{
+		{File: "tempTest/out.full.5.log", Line: 8000, Text: "[Line 39999]"},
+		{File: "tempTest/out.1.log", Line: 4000, Text: "[Line 39999]"},
+	}, matches)
+}
+
+func (s *SearchSuite) TestTimeRangeExtract() {
+	s.GenerateTimedLog("out", 5)
+
+	result := MainRoutine(&Options{
+		Input:     "tempTest",
+		TimeIndex: true,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	index, err := loadTimeIndex("tempTest/out.full.log")
+	req.NoError(s.T(), err, "Time index was not written")
+	req.True(s.T(), len(index.Entries) > 2, "Time index has too few samples")
+
+	var buf bytes.Buffer
+	since := TimedLogStart.Add(10000 * time.Second)
+	err = ExtractTimeRange("tempTest/out.full.log", since, since.Add(100*time.Second), &buf)
+	req.NoError(s.T(), err)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	req.Len(s.T(), lines, 100, "Wrong number of extracted lines")
+	req.Contains(s.T(), lines[0], "[Line 10000]")
+	req.Contains(s.T(), lines[99], "[Line 10099]")
+}
+
+func (s *SearchSuite) TestFindAggregateOutputs() {
+	s.GenerateLog("out", 10)
+
+	result := MainRoutine(&Options{
+		Input:     "tempTest",
+		MaxChunks: 5,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	files, err := FindAggregateOutputs("tempTest", nil)
+	req.NoError(s.T(), err)
+	req.Len(s.T(), files, 5, "Parts or other files were listed as aggregates")
+}
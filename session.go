@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// partObserver follows the merged stream of a group, told which part the
+// following bytes come from. The parts are written one at a time, so the
+// observers need no locking.
+type partObserver interface {
+	io.Writer
+	startPart(name string)
+}
+
+// SessionReport is a run of the application found in a merged group
+type SessionReport struct {
+	// Start and End are the first and last timestamps, zero without any
+	Start, End time.Time
+	Lines      int64
+	// Part is where the session starts
+	Part string
+}
+
+// sessionLineLimit is how much of a line is searched for the marker
+const sessionLineLimit = 64 << 10
+
+// sessionDetector splits the merged lines in sessions, a new one starts at
+// every line containing the marker or after a timestamp gap longer than gap
+type sessionDetector struct {
+	marker   []byte
+	gap      time.Duration
+	part     string
+	line     []byte
+	last     time.Time
+	sessions []SessionReport
+}
+
+func newSessionDetector(marker string, gap time.Duration) *sessionDetector {
+	detector := &sessionDetector{gap: gap}
+	if marker != "" {
+		detector.marker = []byte(marker)
+	}
+	return detector
+}
+
+func (d *sessionDetector) startPart(name string) {
+	d.endLine()
+	d.part = name
+}
+
+func (d *sessionDetector) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		idx := bytes.IndexByte(p, '\n')
+		chunk := p
+		if idx >= 0 {
+			chunk = p[:idx]
+		}
+		if room := sessionLineLimit - len(d.line); room > 0 {
+			if len(chunk) > room {
+				d.line = append(d.line, chunk[:room]...)
+			} else {
+				d.line = append(d.line, chunk...)
+			}
+		}
+		if idx < 0 {
+			break
+		}
+		d.endLine()
+		p = p[idx+1:]
+	}
+	return n, nil
+}
+
+func (d *sessionDetector) endLine() {
+	if len(d.line) == 0 {
+		return
+	}
+	ts, hasTime := ParseTimestamp(string(d.line[:minInt(len(d.line), timestampScanLimit)]))
+
+	newSession := len(d.sessions) == 0
+	if d.marker != nil && bytes.Contains(d.line, d.marker) {
+		newSession = true
+	}
+	if d.gap > 0 && hasTime && !d.last.IsZero() && ts.Sub(d.last) > d.gap {
+		newSession = true
+	}
+	if newSession && (len(d.sessions) == 0 || d.sessions[len(d.sessions)-1].Lines > 0) {
+		d.sessions = append(d.sessions, SessionReport{Part: d.part})
+	}
+
+	session := &d.sessions[len(d.sessions)-1]
+	session.Lines++
+	if hasTime {
+		if session.Start.IsZero() {
+			session.Start = ts
+		}
+		session.End = ts
+		d.last = ts
+	}
+	d.line = d.line[:0]
+}
+
+func (d *sessionDetector) report() []SessionReport {
+	d.endLine()
+	return d.sessions
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// severityScanLimit is how far into a line the severity is looked for
+const severityScanLimit = 128
+
+var severityLevels = []string{"TRACE", "DEBUG", "INFO", "NOTICE", "WARN", "WARNING", "ERROR", "ERR", "FATAL", "CRITICAL", "CRIT", "PANIC", "SEVERE"}
+
+var severityAliases = map[string]string{
+	"WARNING":  "WARN",
+	"ERR":      "ERROR",
+	"CRIT":     "FATAL",
+	"CRITICAL": "FATAL",
+	"PANIC":    "FATAL",
+	"SEVERE":   "ERROR",
+	"EMERG":    "FATAL",
+	"ALERT":    "FATAL",
+}
+
+// syslogSeverities are the names of the syslog numeric severities 0 to 7
+var syslogSeverities = []string{"EMERG", "ALERT", "CRIT", "ERR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+
+// syslogPriority matches the <PRI> header of a syslog line
+var syslogPriority = regexp.MustCompile(`^<(\d{1,3})>`)
+
+// logcatPriority matches the priority letter of an android logcat line, in
+// the threadtime format, optionally with the year, or in the brief one
+var logcatPriority = regexp.MustCompile(`^(?:(?:\d{4}-)?\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}\s+\d+\s+\d+\s+([VDIWEFA])\s|([VDIWEFA])/[^(]*\(\s*\d+\):)`)
+
+// logcatSeverities are the severities of the logcat priority letters
+var logcatSeverities = map[string]string{
+	"V": "TRACE",
+	"D": "DEBUG",
+	"I": "INFO",
+	"W": "WARN",
+	"E": "ERROR",
+	"F": "FATAL",
+	"A": "FATAL",
+}
+
+// LevelMap is a flag mapping severity labels to the ones reported, given as
+// comma separated pairs like WARNING=WARN,SEVERE=ERROR. Repeating the flag
+// adds more pairs.
+type LevelMap map[string]string
+
+func (m *LevelMap) UnmarshalFlag(value string) error {
+	if *m == nil {
+		*m = make(LevelMap)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		from := strings.ToUpper(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || from == "" || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("invalid level mapping %q, expected FROM=TO", pair)
+		}
+		(*m)[from] = strings.ToUpper(strings.TrimSpace(parts[1]))
+	}
+	return nil
+}
+
+func (m LevelMap) MarshalFlag() (string, error) {
+	return m.String(), nil
+}
+
+func (m LevelMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for from, to := range m {
+		pairs = append(pairs, from+"="+to)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// severityDetector finds the severity label of a line and normalizes it
+type severityDetector struct {
+	pattern *regexp.Regexp
+	aliases map[string]string
+}
+
+var defaultSeverityDetector = newSeverityDetector(nil)
+
+// newSeverityDetector builds a detector applying levelMap on top of the
+// built-in aliases, the labels mapped are also looked for in the lines
+func newSeverityDetector(levelMap LevelMap) *severityDetector {
+	aliases := make(map[string]string, len(severityAliases)+len(levelMap))
+	for from, to := range severityAliases {
+		aliases[from] = to
+	}
+	words := make([]string, 0, len(severityLevels)+len(levelMap))
+	for _, level := range severityLevels {
+		words = append(words, regexp.QuoteMeta(level))
+	}
+	for from, to := range levelMap {
+		if _, known := aliases[from]; !known {
+			words = append(words, regexp.QuoteMeta(from))
+		}
+		aliases[from] = to
+	}
+	// longer labels first so that WARNING is not matched as WARN
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	return &severityDetector{
+		pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(words, "|") + `)\b`),
+		aliases: aliases,
+	}
+}
+
+// Detect returns the normalized severity of the line, or an empty string
+// when none is found near its beginning. The numeric severity of a syslog
+// priority header and the priority letter of a logcat line take precedence
+// over the labels in the text.
+func (d *severityDetector) Detect(line string) string {
+	if len(line) > severityScanLimit {
+		line = line[:severityScanLimit]
+	}
+	var level string
+	if header := syslogPriority.FindStringSubmatch(line); header != nil {
+		if priority, err := strconv.Atoi(header[1]); err == nil && priority < 192 {
+			level = syslogSeverities[priority%8]
+		}
+	} else if header := logcatPriority.FindStringSubmatch(line); header != nil {
+		level = logcatSeverities[header[1]+header[2]]
+	}
+	if level == "" {
+		level = strings.ToUpper(d.pattern.FindString(line))
+		if level == "" {
+			return ""
+		}
+	}
+	if alias, ok := d.aliases[level]; ok {
+		return alias
+	}
+	return level
+}
+
+// DetectSeverity returns the severity of the line using the built-in aliases
+func DetectSeverity(line string) string {
+	return defaultSeverityDetector.Detect(line)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+)
+
+const signatureSuffix = ".sig"
+
+// loadSigningKey reads a PEM encoded RSA, ECDSA or Ed25519 private key
+func loadSigningKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return key.(crypto.Signer), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+}
+
+// signatureWriter hashes the bytes written to an output and saves a detached
+// signature of their SHA-256 digest. RSA and ECDSA signatures can be checked
+// with openssl dgst -sha256 -verify, Ed25519 ones sign the digest itself.
+type signatureWriter struct {
+	key    crypto.Signer
+	digest hash.Hash
+}
+
+func newSignatureWriter(key crypto.Signer) *signatureWriter {
+	return &signatureWriter{key: key, digest: sha256.New()}
+}
+
+func (s *signatureWriter) Write(p []byte) (int, error) {
+	return s.digest.Write(p)
+}
+
+func (s *signatureWriter) save(indexedPath string) error {
+	signature, err := signDigest(s.key, s.digest.Sum(nil))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(indexedPath+signatureSuffix, signature, 0644)
+}
+
+func signDigest(key crypto.Signer, digest []byte) ([]byte, error) {
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		opts = crypto.Hash(0)
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
+
+// VerifySignature checks the detached signature of data against a public
+// key of any of the types accepted for signing
+func VerifySignature(public crypto.PublicKey, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	var valid bool
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, digest[:], signature)
+	default:
+		return fmt.Errorf("unsupported key type %T", public)
+	}
+	if !valid {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type SplitCommand struct {
+	Size   ByteSize       `long:"size" description:"Start a new piece before it grows past this size, e.g. 100MB, lines are never cut"`
+	Lines  int            `long:"lines" description:"Number of lines of each piece"`
+	Window time.Duration  `long:"window" description:"Start a new piece at every time window of the line timestamps, e.g. 1h"`
+	Output flags.Filename `short:"o" long:"output" description:"Directory of the pieces, default the one of the aggregate" completion:"directory"`
+	Args   struct {
+		File flags.Filename `positional-arg-name:"file" description:"Aggregate to split"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *SplitCommand) Execute(args []string) error {
+	limits := splitLimits{size: int64(c.Size), lines: int64(c.Lines), window: c.Window}
+	pieces, err := SplitAggregate(string(c.Args.File), string(c.Output), limits)
+	for _, piece := range pieces {
+		fmt.Println(piece)
+	}
+	return err
+}
+
+// splitLimits decide where SplitAggregate starts a new piece, only one of
+// them is set
+type splitLimits struct {
+	size   int64
+	lines  int64
+	window time.Duration
+}
+
+// pieceName is the name of the idx-th piece of the file, the suffix keeps
+// the pieces out of the scan of the parts
+func pieceName(path string, idx int) string {
+	return fmt.Sprintf("%s.%03d", filepath.Base(path), idx+1)
+}
+
+// SplitAggregate splits the aggregate at path in pieces written to outDir,
+// the directory of the aggregate when empty, and returns their paths
+func SplitAggregate(path, outDir string, limits splitLimits) ([]string, error) {
+	set := 0
+	for _, limit := range []bool{limits.size > 0, limits.lines > 0, limits.window > 0} {
+		if limit {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("exactly one of --size, --lines and --window is required")
+	}
+	if outDir == "" {
+		outDir = filepath.Dir(path)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	// the header lines are not log lines, they stay with the first piece
+	header, err := headerSize(in)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var pieces []string
+	var f *os.File
+	var out *bufio.Writer
+	var size, lines, offset int64
+	var window time.Time
+	closePiece := func() error {
+		if f == nil {
+			return nil
+		}
+		err := out.Flush()
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		f = nil
+		return err
+	}
+
+	err = eachLine(in, func(line []byte) error {
+		next := f == nil
+		inHeader := offset < header
+		offset += int64(len(line))
+		switch {
+		case inHeader:
+		case limits.size > 0:
+			next = next || size+int64(len(line)) > limits.size
+		case limits.lines > 0:
+			next = next || lines >= limits.lines
+		case limits.window > 0:
+			// the lines without a timestamp stay with the previous ones
+			if ts, ok := ParseTimestamp(string(line)); ok {
+				start := ts.Truncate(limits.window)
+				next = next || !window.IsZero() && !start.Equal(window)
+				window = start
+			}
+		}
+		if next && (f == nil || size > 0) {
+			if err := closePiece(); err != nil {
+				return err
+			}
+			name := filepath.Join(outDir, pieceName(path, len(pieces)))
+			var err error
+			if f, err = os.Create(name); err != nil {
+				return err
+			}
+			out = bufio.NewWriterSize(f, defaultWriteBuffer)
+			pieces = append(pieces, name)
+			size, lines = 0, 0
+		}
+		size += int64(len(line))
+		if !inHeader {
+			lines++
+		}
+		_, err := out.Write(line)
+		return err
+	})
+	if closeErr := closePiece(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return pieces, err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type StatsCommand struct {
+	JSON bool `long:"json" description:"Print the statistics as JSON"`
+	Args struct {
+		Basename string `positional-arg-name:"basename" description:"Base name of the log to analyze, default all of them"`
+	} `positional-args:"yes"`
+
+	options *Options
+}
+
+func (c *StatsCommand) Execute(args []string) error {
+	if err := ConfigureLogging(c.options); err != nil {
+		return err
+	}
+	scan, err := newScanOptions(c.options)
+	if err != nil {
+		return err
+	}
+	input, basepath, release, err := openInput(string(scan.input))
+	if err != nil {
+		return err
+	}
+	defer release()
+	allFiles, err := scanInput(input, basepath, scan)
+	if err != nil {
+		return err
+	}
+	if c.Args.Basename != "" {
+		list, ok := allFiles[c.Args.Basename]
+		if !ok {
+			return fmt.Errorf("no parts found for %s", c.Args.Basename)
+		}
+		allFiles = FilesList{c.Args.Basename: list}
+	}
+
+	stats, err := CollectStats(input, basepath, allFiles, c.options.newestFirst(), newSeverityDetector(c.options.LevelMap))
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+	return WriteStats(os.Stdout, stats)
+}
+
+// LogStats are the totals of a part, or of a whole group of parts
+type LogStats struct {
+	Name     string           `json:"name"`
+	Lines    int64            `json:"lines"`
+	Bytes    int64            `json:"bytes"`
+	First    *time.Time       `json:"first,omitempty"`
+	Last     *time.Time       `json:"last,omitempty"`
+	Severity map[string]int64 `json:"severity"`
+}
+
+// GroupStats are the statistics of a base name and of each of its parts
+type GroupStats struct {
+	LogStats
+	Parts []LogStats `json:"parts"`
+}
+
+func (s *LogStats) addTime(ts time.Time) {
+	if s.First == nil || ts.Before(*s.First) {
+		first := ts
+		s.First = &first
+	}
+	if s.Last == nil || ts.After(*s.Last) {
+		last := ts
+		s.Last = &last
+	}
+}
+
+func (s *LogStats) add(other LogStats) {
+	s.Lines += other.Lines
+	s.Bytes += other.Bytes
+	if other.First != nil {
+		s.addTime(*other.First)
+	}
+	if other.Last != nil {
+		s.addTime(*other.Last)
+	}
+	for level, count := range other.Severity {
+		s.Severity[level] += count
+	}
+}
+
+// CollectStats reads every part of the groups, from fsys or from the basepath
+// directory when nil, in merge order, without writing any output. The
+// severities are detected by levels.
+func CollectStats(fsys fs.FS, basepath string, allFiles FilesList, newestFirst bool, levels *severityDetector) ([]GroupStats, error) {
+	bases := make([]string, 0, len(allFiles))
+	for base := range allFiles {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	result := make([]GroupStats, 0, len(bases))
+	for _, base := range bases {
+		group := GroupStats{LogStats: LogStats{Name: base, Severity: make(map[string]int64)}}
+		list := allFiles[base]
+		SortLogList(list, newestFirst)
+		for _, part := range list {
+			partStats, err := collectPartStats(fsys, basepath, part.name, levels)
+			if err != nil {
+				return nil, err
+			}
+			partStats.Name = part.name
+			group.add(partStats)
+			group.Parts = append(group.Parts, partStats)
+		}
+		result = append(result, group)
+	}
+	return result, nil
+}
+
+func collectPartStats(fsys fs.FS, basepath, name string, levels *severityDetector) (LogStats, error) {
+	stats := LogStats{Severity: make(map[string]int64)}
+	f, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+
+	err = forEachLinePrefix(f, func(prefix string) {
+		stats.Lines++
+		if ts, ok := ParseTimestamp(prefix); ok {
+			stats.addTime(ts)
+		}
+		if level := levels.Detect(prefix); level != "" {
+			stats.Severity[level]++
+		}
+	}, func(n int) {
+		stats.Bytes += int64(n)
+	})
+	return stats, err
+}
+
+// forEachLinePrefix calls line with the beginning of every line of r, lines
+// of any length are supported, and count with the number of bytes read
+func forEachLinePrefix(r io.Reader, line func(prefix string), count func(n int)) error {
+	reader := bufio.NewReaderSize(r, 64<<10)
+	lineStart := true
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			count(len(chunk))
+			if lineStart {
+				prefix := chunk
+				if len(prefix) > severityScanLimit {
+					prefix = prefix[:severityScanLimit]
+				}
+				line(strings.TrimRight(string(prefix), "\r\n"))
+			}
+			lineStart = chunk[len(chunk)-1] == '\n'
+		}
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+func formatStatsTime(ts *time.Time) string {
+	if ts == nil {
+		return "-"
+	}
+	return ts.Format(time.RFC3339)
+}
+
+func formatSeverity(severity map[string]int64) string {
+	levels := make([]string, 0, len(severity))
+	for level := range severity {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	var parts []string
+	for _, level := range levels {
+		parts = append(parts, fmt.Sprintf("%s=%d", level, severity[level]))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
+// WriteStats prints the statistics as a table
+func WriteStats(w io.Writer, stats []GroupStats) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tLINES\tBYTES\tFIRST\tLAST\tSEVERITY")
+	for _, group := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", group.Name, group.Lines, formatBytes(group.Bytes),
+			formatStatsTime(group.First), formatStatsTime(group.Last), formatSeverity(group.Severity))
+		for _, part := range group.Parts {
+			fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t%s\t%s\n", part.Name, part.Lines, formatBytes(part.Bytes),
+				formatStatsTime(part.First), formatStatsTime(part.Last), formatSeverity(part.Severity))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	req "github.com/stretchr/testify/require"
+)
+
+func init() {
+	AllTestSuites = append(AllTestSuites, &StatsSuite{})
+}
+
+type StatsSuite struct {
+	BaseSuite
+}
+
+func (s *StatsSuite) BeforeTest(suiteName, testName string) {
+	s.DeleteLogDir()
+}
+
+func (s *StatsSuite) TestCollectStats() {
+	s.GenerateTimedLog("out", 3)
+
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	stats, err := CollectStats(nil, "tempTest", allFiles, false, defaultSeverityDetector)
+	req.NoError(s.T(), err)
+
+	req.Len(s.T(), stats, 1)
+	group := stats[0]
+	req.Equal(s.T(), "out", group.Name)
+	req.Equal(s.T(), int64(LinesPerChunk*3), group.Lines)
+	req.Len(s.T(), group.Parts, 3)
+	req.Equal(s.T(), "out.3.log", group.Parts[0].Name, "Parts are not in merge order")
+
+	var partBytes int64
+	for _, part := range group.Parts {
+		partBytes += part.Bytes
+	}
+	req.Equal(s.T(), partBytes, group.Bytes)
+	req.Equal(s.T(), TimedLogStart, *group.First)
+	req.Equal(s.T(), TimedLogStart.Add((LinesPerChunk*3-1)*time.Second), *group.Last)
+}
+
+func (s *StatsSuite) TestHistogram() {
+	s.GenerateTimedLog("out", 3)
+
+	result := MainRoutine(&Options{
+		Input:           "tempTest",
+		Histogram:       time.Hour,
+		HistogramFormat: "json",
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	data, err := ioutil.ReadFile("tempTest/out.full.log.histogram.json")
+	req.NoError(s.T(), err, "Histogram was not written")
+
+	var report struct {
+		Buckets []HistogramBucket `json:"buckets"`
+	}
+	req.NoError(s.T(), json.Unmarshal(data, &report))
+
+	// 12000 lines one second apart
+	req.Len(s.T(), report.Buckets, 4)
+	for idx, lines := range []int64{3600, 3600, 3600, 1200} {
+		req.Equal(s.T(), lines, report.Buckets[idx].Lines, "Wrong line count in bucket %d", idx)
+		req.Equal(s.T(), TimedLogStart.Add(time.Duration(idx)*time.Hour), report.Buckets[idx].Start.UTC())
+	}
+}
+
+func (s *StatsSuite) TestClusterErrors() {
+	_ = os.Mkdir("tempTest", 0777)
+	f, _ := os.Create("tempTest/app.log")
+	for idx := 0; idx < 30; idx++ {
+		ts := TimedLogStart.Add(time.Duration(idx) * time.Second).Format(time.RFC3339)
+		_, _ = f.WriteString(fmt.Sprintf("%s ERROR request %d from 10.0.0.%d timed out\n", ts, idx, idx))
+		_, _ = f.WriteString(fmt.Sprintf("%s INFO request %d done\n", ts, idx))
+		if idx%3 == 0 {
+			_, _ = f.WriteString(fmt.Sprintf("%s ERROR cache miss for key 0x%x\n", ts, idx*4096))
+		}
+	}
+	_ = f.Close()
+
+	result := MainRoutine(&Options{
+		Input:         "tempTest",
+		ClusterErrors: true,
+		ClusterTop:    5,
+	})
+	req.Equalf(s.T(), 0, result, "Failed check correct method result")
+
+	data, err := ioutil.ReadFile("tempTest/app.full.log.clusters")
+	req.NoError(s.T(), err, "Clusters report was not written")
+	report := string(data)
+	req.Contains(s.T(), report, "#1 count=30\n  template: ERROR request <NUM> from <IP> timed out")
+	req.Contains(s.T(), report, "#2 count=10\n  template: ERROR cache miss for key <HEX>")
+	req.NotContains(s.T(), report, "INFO", "Non error lines were clustered")
+}
+
+func (s *StatsSuite) TestDetectSeverity() {
+	for line, expected := range map[string]string{
+		"2021-03-01 10:00:00 INFO started":        "INFO",
+		"[warning] disk almost full":              "WARN",
+		`{"level":"error","msg":"failed"}`:        "ERROR",
+		"level=crit msg=boom":                     "FATAL",
+		"2021-03-01 10:00:00 nothing to see here": "",
+		"informational message without a keyword": "",
+	} {
+		req.Equalf(s.T(), expected, DetectSeverity(line), "Wrong severity for %q", line)
+	}
+}
+
+func (s *StatsSuite) TestLogcat() {
+	for line, expected := range map[string]string{
+		"03-01 10:00:00.123  1234  5678 E ActivityManager: ANR in com.app": "ERROR",
+		"03-01 10:00:00.123  1234  5678 W Info: low memory":                "WARN",
+		"2021-03-01 10:00:00.123  1234  5678 V Tag: verbose":               "TRACE",
+		"I/ActivityManager( 1234): Start proc":                             "INFO",
+		"F/libc    ( 4321): Fatal signal 11":                               "FATAL",
+	} {
+		req.Equalf(s.T(), expected, DetectSeverity(line), "Wrong severity for %q", line)
+	}
+
+	ts, ok := ParseTimestamp("03-01 10:00:00.123  1234  5678 I Tag: msg")
+	req.True(s.T(), ok, "Logcat timestamp not found")
+	req.Equal(s.T(), "03-01 10:00:00.123", ts.Format("01-02 15:04:05.000"))
+	req.NotZero(s.T(), ts.Year(), "Logcat timestamp kept year 0")
+
+	now := time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC)
+	req.Equal(s.T(), 2021, withLastYear(time.Date(0, 1, 4, 0, 0, 0, 0, time.UTC), now).Year())
+	req.Equal(s.T(), 2020, withLastYear(time.Date(0, 12, 31, 0, 0, 0, 0, time.UTC), now).Year())
+}
+
+func (s *StatsSuite) TestLevelMap() {
+	var levelMap LevelMap
+	req.NoError(s.T(), levelMap.UnmarshalFlag("notice=INFO, SEVERE=FATAL"))
+	req.NoError(s.T(), levelMap.UnmarshalFlag("FINE=DEBUG"))
+	req.Error(s.T(), levelMap.UnmarshalFlag("WARN"), "Mapping without target was accepted")
+
+	levels := newSeverityDetector(levelMap)
+	for line, expected := range map[string]string{
+		"2021-03-01 10:00:00 NOTICE started":     "INFO",
+		"SEVERE: connection lost":                "FATAL",
+		"FINE: entering method":                  "DEBUG",
+		"[warning] disk almost full":             "WARN",
+		"<11>Mar  1 10:00:00 host app: failed":   "ERROR",
+		"<14>Mar  1 10:00:00 host app: ERROR ok": "INFO",
+		"<8>Mar  1 10:00:00 host kernel: panic":  "FATAL",
+	} {
+		req.Equalf(s.T(), expected, levels.Detect(line), "Wrong severity for %q", line)
+	}
+	// the built-in detection is not affected by the mappings
+	req.Equal(s.T(), "NOTICE", DetectSeverity("NOTICE started"))
+}
+
+func (s *StatsSuite) TestCheckOrder() {
+	s.GenerateTimedLog("out", 3)
+
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+
+	// merging in reverse puts every part before the older one
+	run := &mergeRun{writeBuffer: defaultWriteBuffer, readBuffer: defaultReadBuffer, checkOrder: true, report: newRunReport()}
+	MergeLogList("tempTest", "out", allFiles["out"], &Options{Reverse: true}, run)
+
+	groups := run.report.Groups()
+	req.Len(s.T(), groups, 1)
+	order := groups[0].Order
+	req.NotNil(s.T(), order, "Order was not checked")
+	req.Equal(s.T(), int64(2), order.Jumps)
+	req.Equal(s.T(), (LinesPerChunk*2-1)*time.Second, order.MaxSkew)
+	req.Len(s.T(), order.Offenders, 2)
+
+	run.report = newRunReport()
+	MergeLogList("tempTest", "out", allFiles["out"], &Options{}, run)
+	req.Equal(s.T(), int64(0), run.report.Groups()[0].Order.Jumps)
+}
+
+func (s *StatsSuite) TestSessions() {
+	_ = os.Mkdir("tempTest", 0777)
+	line := func(offset time.Duration, text string) string {
+		return TimedLogStart.Add(offset).Format(time.RFC3339) + " " + text + "\n"
+	}
+	older := line(0, "Server started") + line(time.Second, "working") + line(2*time.Hour, "after a long pause")
+	newer := line(3*time.Hour, "still working") + line(3*time.Hour+time.Second, "Server started") + line(3*time.Hour+2*time.Second, "done")
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.2.log", []byte(older), 0644))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log", []byte(newer), 0644))
+
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	run := &mergeRun{writeBuffer: defaultWriteBuffer, readBuffer: defaultReadBuffer, report: newRunReport(),
+		sessionMark: "Server started", sessionGap: time.Hour}
+	_, err = MergeLogList("tempTest", "app", allFiles["app"], &Options{}, run)
+	req.NoError(s.T(), err)
+
+	sessions := run.report.Groups()[0].Sessions
+	req.Len(s.T(), sessions, 3)
+	for idx, expected := range []SessionReport{
+		{Start: TimedLogStart, End: TimedLogStart.Add(time.Second), Lines: 2, Part: "app.2.log"},
+		{Start: TimedLogStart.Add(2 * time.Hour), End: TimedLogStart.Add(3 * time.Hour), Lines: 2, Part: "app.2.log"},
+		{Start: TimedLogStart.Add(3*time.Hour + time.Second), End: TimedLogStart.Add(3*time.Hour + 2*time.Second), Lines: 2, Part: "app.1.log"},
+	} {
+		req.Equal(s.T(), expected.Lines, sessions[idx].Lines, "Wrong lines in session %d", idx)
+		req.Equal(s.T(), expected.Part, sessions[idx].Part, "Wrong start part of session %d", idx)
+		req.True(s.T(), expected.Start.Equal(sessions[idx].Start), "Wrong start of session %d: %v", idx, sessions[idx].Start)
+		req.True(s.T(), expected.End.Equal(sessions[idx].End), "Wrong end of session %d: %v", idx, sessions[idx].End)
+	}
+}
+
+func (s *StatsSuite) TestSessionsAfterTransforms() {
+	_ = os.Mkdir("tempTest", 0777)
+	line := func(offset time.Duration, text string) string {
+		return TimedLogStart.Add(offset).Format(time.RFC3339) + " " + text + "\n"
+	}
+	content := line(0, "kept") + line(time.Second, "Server started") + line(2*time.Second, "kept")
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.1.log", []byte(content), 0644))
+
+	allFiles, err := ScanFolderForFiles("tempTest")
+	req.NoError(s.T(), err)
+	// the marker is on a line the filter drops, the observers only see the
+	// lines written to the output
+	run := &mergeRun{writeBuffer: defaultWriteBuffer, readBuffer: defaultReadBuffer, report: newRunReport(),
+		contains: newLineMatcher([]string{"kept"}, false, false), delimiter: '\n', sessionMark: "Server started"}
+	_, err = MergeLogList("tempTest", "app", allFiles["app"], &Options{}, run)
+	req.NoError(s.T(), err)
+
+	sessions := run.report.Groups()[0].Sessions
+	req.Len(s.T(), sessions, 1, "A filtered out line started a session")
+	req.Equal(s.T(), int64(2), sessions[0].Lines)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tagInjector adds key=value tags to the lines of the outputs: as the first
+// fields of the JSON object lines and, with a prefix, in front of the other
+// lines, so that the aggregates of several machines stay distinguishable
+type tagInjector struct {
+	fields []byte
+	prefix []byte
+	delim  byte
+	// partial is set while the pieces of a line longer than
+	// transformLineLimit are seen, only the first one is tagged
+	partial bool
+}
+
+// newTagInjector parses the key=value tags, the text lines are prefixed
+// only when prefix is set
+func newTagInjector(tags []string, prefix bool) (*tagInjector, error) {
+	t := &tagInjector{delim: '\n'}
+	var fields, words []string
+	for _, tag := range tags {
+		idx := strings.Index(tag, "=")
+		if idx <= 0 {
+			return nil, &OptionError{"tag", fmt.Errorf("%q: expected key=value", tag)}
+		}
+		key, value := tag[:idx], tag[idx+1:]
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, _ := json.Marshal(value)
+		fields = append(fields, string(encodedKey)+":"+string(encodedValue))
+		if strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		words = append(words, key+"="+value)
+	}
+	t.fields = []byte(strings.Join(fields, ","))
+	if prefix && len(words) > 0 {
+		t.prefix = []byte(strings.Join(words, " ") + " ")
+	}
+	return t, nil
+}
+
+// forOutput returns a copy of the injector for one output, whose lines end
+// with delim
+func (t *tagInjector) forOutput(delim byte) *tagInjector {
+	injector := *t
+	injector.delim, injector.partial = delim, false
+	return &injector
+}
+
+func (t *tagInjector) inject(line []byte) []byte {
+	first := !t.partial
+	t.partial = len(line) > 0 && line[len(line)-1] != t.delim
+	if !first || len(t.fields) == 0 {
+		return line
+	}
+
+	if trimmed := bytes.TrimLeft(line, " \t"); len(trimmed) > 0 && trimmed[0] == '{' {
+		start := len(line) - len(trimmed) + 1
+		result := make([]byte, 0, len(line)+len(t.fields)+1)
+		result = append(result, line[:start]...)
+		result = append(result, t.fields...)
+		// an empty object takes no separator
+		if rest := bytes.TrimLeft(line[start:], " \t"); len(rest) > 0 && rest[0] != '}' {
+			result = append(result, ',')
+		}
+		return append(result, line[start:]...)
+	}
+	if t.prefix == nil {
+		return line
+	}
+	return append(append(make([]byte, 0, len(t.prefix)+len(line)), t.prefix...), line...)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+// tailPollInterval is how often the live part is checked for new content
+// when following
+const tailPollInterval = 500 * time.Millisecond
+
+type TailCommand struct {
+	Lines  int  `short:"n" long:"lines" description:"Number of lines to print" default:"10"`
+	Follow bool `short:"f" long:"follow" description:"Keep printing the lines appended to the live part, across rotations"`
+	Args   struct {
+		Basename string `positional-arg-name:"basename" description:"Base name of the log to print"`
+	} `positional-args:"yes" required:"yes"`
+
+	options *Options
+}
+
+func (c *TailCommand) Execute(args []string) error {
+	if err := ConfigureLogging(c.options); err != nil {
+		return err
+	}
+	scan, err := newScanOptions(c.options)
+	if err != nil {
+		return err
+	}
+	input, basepath, release, err := openInput(string(scan.input))
+	if err != nil {
+		return err
+	}
+	defer release()
+	if c.Follow && input != nil {
+		return errors.New("the parts of an archive cannot be followed")
+	}
+	names, err := tailParts(input, basepath, scan, c.Args.Basename)
+	if err != nil {
+		return err
+	}
+	if err := TailLines(os.Stdout, input, basepath, names, c.Lines); err != nil {
+		return err
+	}
+	if !c.Follow {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+
+	live := filepath.Join(basepath, names[len(names)-1])
+	info, err := os.Stat(live)
+	if err != nil {
+		return err
+	}
+	return FollowFile(os.Stdout, live, info.Size(), tailPollInterval, stop)
+}
+
+// tailParts lists the parts of basename in fsys, or in the basepath
+// directory when nil, from the oldest rotation to the live file whatever the
+// merge order of the options: the tail is the end of the log and the live
+// file is the one followed
+func tailParts(fsys fs.FS, basepath string, scan *scanOptions, basename string) ([]string, error) {
+	allFiles, err := scanInput(fsys, basepath, scan)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := allFiles[basename]
+	if !ok {
+		return nil, fmt.Errorf("no parts found for %s", basename)
+	}
+	SortLogList(list, false)
+
+	names := make([]string, 0, len(list))
+	for _, part := range list {
+		names = append(names, part.name)
+	}
+	return names, nil
+}
+
+// TailLines writes the last n lines of the parts of fsys, or of the basepath
+// directory when nil, given in merge order
+func TailLines(w io.Writer, fsys fs.FS, basepath string, names []string, n int) error {
+	var chunks [][]byte
+	remaining := n
+	for idx := len(names) - 1; idx >= 0 && remaining > 0; idx-- {
+		data, count, err := lastLines(fsys, basepath, names[idx], remaining)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, data)
+		remaining -= count
+	}
+	for idx := len(chunks) - 1; idx >= 0; idx-- {
+		if _, err := w.Write(chunks[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastLines reads the part backwards returning at most its last n lines and
+// their count, a missing trailing newline is added. The parts that cannot be
+// read at random, as in the zip archives, are read from the start.
+func lastLines(fsys fs.FS, basepath, name string, n int) ([]byte, int, error) {
+	part, err := openPart(fsys, basepath, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer part.Close()
+
+	info, err := part.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	f, ok := part.(io.ReaderAt)
+	if !ok {
+		return lastLinesForward(part, n)
+	}
+
+	const blockSize = 64 << 10
+	var data []byte
+	pos := info.Size()
+	for pos > 0 && bytes.Count(data, []byte{'\n'}) <= n {
+		size := int64(blockSize)
+		if pos < size {
+			size = pos
+		}
+		pos -= size
+		block := make([]byte, size)
+		if _, err := f.ReadAt(block, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		data = append(block, data...)
+	}
+	if len(data) == 0 {
+		return nil, 0, nil
+	}
+	if data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+
+	// keep only the last n lines, enough blocks were read for them to be whole
+	end := len(data) - 1
+	start, count := 0, 0
+	for count < n {
+		prev := bytes.LastIndexByte(data[:end], '\n')
+		count++
+		if prev < 0 {
+			start = 0
+			break
+		}
+		start = prev + 1
+		end = prev
+	}
+	return data[start:], count, nil
+}
+
+// lastLinesForward reads r to its end keeping its last n lines, returning
+// them and their count with a missing trailing newline added
+func lastLinesForward(r io.Reader, n int) ([]byte, int, error) {
+	// the lines are kept in a ring, the oldest one is overwritten
+	lines := make([][]byte, n)
+	count := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lines[count%n] = line
+			count++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if count > n {
+		lines = append(lines[count%n:], lines[:count%n]...)
+		count = n
+	}
+	var data []byte
+	for _, line := range lines[:count] {
+		data = append(data, line...)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	return data, count, nil
+}
+
+// FollowFile writes what is appended to the file from offset onwards until
+// stop is closed. When the file is rotated (replaced or truncated) it is
+// reopened and followed from its beginning.
+func FollowFile(w io.Writer, path string, offset int64, poll time.Duration, stop <-chan struct{}) error {
+	var current *os.File
+	var currentInfo os.FileInfo
+	defer func() {
+		if current != nil {
+			_ = current.Close()
+		}
+	}()
+
+	reader := bufio.NewReader(nil)
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil && os.IsNotExist(err):
+			// rotation in progress, the live part will be recreated
+		case err != nil:
+			return err
+		case current == nil || !os.SameFile(info, currentInfo) || info.Size() < offset:
+			if current != nil {
+				// drain what was written to the rotated file before switching
+				if _, err := io.Copy(w, reader); err != nil {
+					return err
+				}
+				_ = current.Close()
+				offset = 0
+			}
+			if current, err = os.Open(path); err != nil {
+				return err
+			}
+			if currentInfo, err = current.Stat(); err != nil {
+				return err
+			}
+			if _, err := current.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(current)
+		}
+
+		if current != nil {
+			if _, err := io.Copy(w, reader); err != nil {
+				return err
+			}
+			if pos, err := current.Seek(0, io.SeekCurrent); err == nil {
+				offset = pos - int64(reader.Buffered())
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(poll):
+		}
+	}
+}
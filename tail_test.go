@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	req "github.com/stretchr/testify/require"
+)
+
+func init() {
+	AllTestSuites = append(AllTestSuites, &TailSuite{})
+}
+
+type TailSuite struct {
+	BaseSuite
+}
+
+func (s *TailSuite) BeforeTest(suiteName, testName string) {
+	s.DeleteLogDir()
+}
+
+func (s *TailSuite) TestTailAcrossParts() {
+	s.GenerateLog("out", 3)
+	names := []string{"out.3.log", "out.2.log", "out.1.log"}
+
+	var buf bytes.Buffer
+	req.NoError(s.T(), TailLines(&buf, nil, "tempTest", names, LinesPerChunk+2))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	req.Len(s.T(), lines, LinesPerChunk+2)
+	first := LinesPerChunk*2 - 2
+	for idx, line := range lines {
+		req.Equal(s.T(), fmt.Sprintf("[Line %d]", first+idx), line, "Lines printed out of order")
+	}
+
+	buf.Reset()
+	req.NoError(s.T(), TailLines(&buf, nil, "tempTest", names, LinesPerChunk*10))
+	req.Equal(s.T(), LinesPerChunk*3, strings.Count(buf.String(), "\n"), "Short rotation set was not printed whole")
+}
+
+func (s *TailSuite) TestTailIgnoresMergeOrder() {
+	s.GenerateLog("out", 2)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/out.log", []byte("[Live]\n"), 0644))
+
+	for _, options := range []*Options{{Input: "tempTest", Order: orderDesc}, {Input: "tempTest", Reverse: true}} {
+		scan, err := newScanOptions(options)
+		req.NoError(s.T(), err)
+		names, err := tailParts(nil, "tempTest", scan, "out")
+		req.NoError(s.T(), err)
+		req.Equal(s.T(), []string{"out.2.log", "out.1.log", "out.log"}, names,
+			"Tail does not end with the live file")
+
+		var buf bytes.Buffer
+		req.NoError(s.T(), TailLines(&buf, nil, "tempTest", names, 1))
+		req.Equal(s.T(), "[Live]\n", buf.String())
+	}
+}
+
+// syncBuffer is a buffer safe to read while FollowFile writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (s *TailSuite) TestFollowRotation() {
+	_ = os.Mkdir("tempTest", 0777)
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.log", []byte("old\n"), 0666))
+
+	out := &syncBuffer{}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- FollowFile(out, "tempTest/app.log", 4, 10*time.Millisecond, stop)
+	}()
+
+	waitFor := func(text string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(out.String(), text) && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		req.Contains(s.T(), out.String(), text)
+	}
+
+	f, _ := os.OpenFile("tempTest/app.log", os.O_APPEND|os.O_WRONLY, 0666)
+	_, _ = f.WriteString("appended\n")
+	_ = f.Close()
+	waitFor("appended\n")
+
+	req.NoError(s.T(), os.Rename("tempTest/app.log", "tempTest/app.log.1"))
+	req.NoError(s.T(), ioutil.WriteFile("tempTest/app.log", []byte("rotated\n"), 0666))
+	waitFor("rotated\n")
+
+	close(stop)
+	req.NoError(s.T(), <-done)
+	req.Equal(s.T(), "appended\nrotated\n", out.String(), "Followed content has gaps or duplicates")
+}
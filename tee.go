@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const teeStdout = "-"
+
+// teeSink is an extra destination of the merged stream
+type teeSink struct {
+	name string
+	w    io.WriteCloser
+}
+
+// teeSinks writes the merged stream to every sink in one pass. The chunks
+// of concurrent groups hold the sinks in turn so their content is not
+// interleaved, and a failing sink is dropped without stopping the merge.
+type teeSinks struct {
+	turn  sync.Mutex
+	sinks []*teeSink
+	pacer *replayPacer
+}
+
+// stdoutSink keeps the standard output open when the sinks are closed
+type stdoutSink struct {
+	io.Writer
+}
+
+func (stdoutSink) Close() error { return nil }
+
+// colorSink highlights the lines sent to the standard output
+type colorSink struct {
+	*transformWriter
+}
+
+// Close writes the last line, the standard output stays open
+func (c colorSink) Close() error { return c.Flush() }
+
+// openTeeSinks opens the sinks described by specs: "-" for the standard
+// output, tcp://host:port for a remote sink, batched and spooled to disk
+// when slower than the merge, a file path otherwise. With --color the lines
+// sent to a terminal are highlighted.
+func openTeeSinks(specs []string, options *Options) (*teeSinks, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	tee := &teeSinks{}
+	for _, spec := range specs {
+		var w io.WriteCloser
+		var err error
+		switch {
+		case spec == teeStdout && options.Color && isTerminal(os.Stdout):
+			w = colorSink{newColorWriter(os.Stdout, options)}
+		case spec == teeStdout:
+			w = stdoutSink{os.Stdout}
+		case strings.HasPrefix(spec, "tcp://"):
+			w, err = newRemoteSink(strings.TrimPrefix(spec, "tcp://"))
+		default:
+			w, err = os.Create(spec)
+			trackOutput(spec)
+		}
+		if err != nil {
+			tee.Close()
+			return nil, err
+		}
+		tee.sinks = append(tee.sinks, &teeSink{name: spec, w: w})
+	}
+	return tee, nil
+}
+
+// acquire reserves the sinks for a chunk until release
+func (t *teeSinks) acquire() {
+	t.turn.Lock()
+}
+
+func (t *teeSinks) release() {
+	if t.pacer != nil {
+		t.pacer.flush(t.send)
+	}
+	t.turn.Unlock()
+}
+
+func (t *teeSinks) Write(p []byte) (int, error) {
+	if t.pacer != nil {
+		t.pacer.pace(p, t.send)
+	} else {
+		t.send(p)
+	}
+	return len(p), nil
+}
+
+// send writes p to every sink, dropping the ones failing
+func (t *teeSinks) send(p []byte) {
+	for _, sink := range t.sinks {
+		if sink.w == nil {
+			continue
+		}
+		if _, err := sink.w.Write(p); err != nil {
+			log.Errorf("[ERROR]: Writing to %s, sink dropped: %v\n", sink.name, err)
+			_ = sink.w.Close()
+			sink.w = nil
+		}
+	}
+}
+
+func (t *teeSinks) Close() {
+	for _, sink := range t.sinks {
+		if sink.w != nil {
+			if err := sink.w.Close(); err != nil {
+				log.Errorf("[ERROR]: Closing %s: %v\n", sink.name, err)
+			}
+			sink.w = nil
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// timeIndexInterval is the distance in bytes between two samples of the
+	// sparse time index
+	timeIndexInterval = 256 << 10
+	timeIndexSuffix   = ".tidx"
+)
+
+type timeIndexEntry struct {
+	Offset int64
+	Time   time.Time
+}
+
+// timeIndex maps timestamps to the offset of the line carrying them, sampled
+// every timeIndexInterval bytes of the indexed file
+type timeIndex struct {
+	Version int
+	Size    int64
+	Entries []timeIndexEntry
+}
+
+// timeIndexer builds a timeIndex from the bytes written to it
+type timeIndexer struct {
+	index       *timeIndex
+	offset      int64
+	lineStart   int64
+	atLineStart bool
+	sampling    bool
+	prefix      []byte
+	next        int64
+}
+
+func newTimeIndexer() *timeIndexer {
+	return &timeIndexer{
+		index:       &timeIndex{Version: indexVersion},
+		atLineStart: true,
+		prefix:      make([]byte, 0, timestampScanLimit),
+	}
+}
+
+func (t *timeIndexer) Write(p []byte) (int, error) {
+	for i, c := range p {
+		if t.atLineStart {
+			t.lineStart = t.offset + int64(i)
+			t.sampling = t.lineStart >= t.next
+			t.prefix = t.prefix[:0]
+			t.atLineStart = false
+		}
+		if c == '\n' {
+			if t.sampling {
+				t.sample()
+			}
+			t.atLineStart = true
+			continue
+		}
+		if t.sampling {
+			t.prefix = append(t.prefix, c)
+			if len(t.prefix) == timestampScanLimit {
+				t.sample()
+			}
+		}
+	}
+	t.offset += int64(len(p))
+	return len(p), nil
+}
+
+// skipHeader leaves the header lines out of the samples
+func (t *timeIndexer) skipHeader(size int64) {
+	t.next = size
+}
+
+// sample records the timestamp of the current line, when it has one
+func (t *timeIndexer) sample() {
+	t.sampling = false
+	if ts, ok := ParseTimestamp(string(t.prefix)); ok {
+		t.index.Entries = append(t.index.Entries, timeIndexEntry{Offset: t.lineStart, Time: ts})
+		t.next = t.lineStart + timeIndexInterval
+	}
+}
+
+func (t *timeIndexer) save(indexedPath string) error {
+	if t.sampling {
+		t.sample()
+	}
+	t.index.Size = t.offset
+	f, err := os.Create(indexedPath + timeIndexSuffix)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := gob.NewEncoder(w).Encode(t.index); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func loadTimeIndex(indexedPath string) (*timeIndex, error) {
+	info, err := os.Stat(indexedPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(indexedPath + timeIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	index := &timeIndex{}
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(index); err != nil {
+		return nil, err
+	}
+	if index.Version != indexVersion || index.Size != info.Size() {
+		return nil, errStaleIndex
+	}
+	return index, nil
+}
+
+// ExtractTimeRange writes the lines of the aggregate with a timestamp in
+// [since, until) to w, lines without a timestamp follow the previous line.
+// A zero since or until leaves that end of the range open. The aggregate is
+// expected to be in chronological order, the time index sidecar is used to
+// skip directly to the start of the range when available.
+func ExtractTimeRange(path string, since, until time.Time, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the header lines are not log lines, the range starts after them
+	start, err := headerSize(f)
+	if err != nil {
+		return err
+	}
+	if index, err := loadTimeIndex(path); err == nil && !since.IsZero() {
+		// the last sample before since, its line is before the range
+		pos := sort.Search(len(index.Entries), func(i int) bool {
+			return !index.Entries[i].Time.Before(since)
+		})
+		if pos > 0 && index.Entries[pos-1].Offset > start {
+			start = index.Entries[pos-1].Offset
+		}
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	include := false
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if ts, ok := ParseTimestamp(line); ok {
+				if !until.IsZero() && !ts.Before(until) {
+					return nil
+				}
+				include = since.IsZero() || !ts.Before(since)
+			}
+			if include {
+				if _, werr := io.WriteString(w, line); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// errTimeout is returned by the reads past their deadline, the reads cannot
+// be interrupted so retrying them would only pile up stuck goroutines
+var errTimeout = permanentError{errors.New("read timed out")}
+
+// errChunkTimeout fails the parts of a chunk not merged before --chunk-timeout
+var errChunkTimeout = permanentError{errors.New("chunk timed out")}
+
+// deadlineReadSize is the most a deadlineReader reads at a time
+const deadlineReadSize = 64 << 10
+
+// deadlineReader fails the reads not completed before the deadline. The reads
+// run in a goroutine on a buffer of the reader, so that a read stuck on a hung
+// filesystem can be abandoned without touching the caller's buffer; the
+// buffer is reused until a read is abandoned and left to it.
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+	buf      []byte
+	done     chan readResult
+	failed   bool
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// fileDeadlineReader reads from a file that supports deadlines, as the pipes
+// do, reporting the expired reads with errTimeout
+type fileDeadlineReader struct {
+	r io.Reader
+}
+
+func (f fileDeadlineReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = errTimeout
+	}
+	return n, err
+}
+
+// newDeadlineReader fails the reads of r past a non zero deadline, using the
+// deadline of the file itself when it has one
+func newDeadlineReader(r io.Reader, deadline time.Time) io.Reader {
+	if deadline.IsZero() {
+		return r
+	}
+	if file, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok && file.SetReadDeadline(deadline) == nil {
+		return fileDeadlineReader{r}
+	}
+	return &deadlineReader{r: r, deadline: deadline, done: make(chan readResult, 1)}
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	// the reader is abandoned after a timeout, a stuck read may still own it
+	if d.failed {
+		return 0, errTimeout
+	}
+	wait := time.Until(d.deadline)
+	if wait <= 0 {
+		d.failed = true
+		return 0, errTimeout
+	}
+
+	if d.buf == nil {
+		d.buf = make([]byte, deadlineReadSize)
+	}
+	buf := d.buf
+	if len(p) < len(buf) {
+		buf = buf[:len(p)]
+	}
+	go func() {
+		n, err := d.r.Read(buf)
+		d.done <- readResult{n, err}
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case result := <-d.done:
+		copy(p, buf[:result.n])
+		return result.n, result.err
+	case <-timer.C:
+		d.failed = true
+		return 0, errTimeout
+	}
+}
+
+// chunkContext is the context of the merge of a chunk, ending after
+// --chunk-timeout when set
+func (run *mergeRun) chunkContext() (context.Context, context.CancelFunc) {
+	if run.chunkTime > 0 {
+		return context.WithTimeout(context.Background(), run.chunkTime)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// chunkError is why the parts of the chunk of ctx can no longer be merged,
+// nil while it is running
+func chunkError(ctx context.Context) error {
+	switch err := ctx.Err(); err {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return errChunkTimeout
+	default:
+		return permanentError{err}
+	}
+}
+
+// partDeadline is when the read of a part started now must be complete, the
+// earliest of the per part, of the chunk and of the whole run deadlines
+func (run *mergeRun) partDeadline(ctx context.Context) time.Time {
+	deadline := run.deadline
+	if chunkDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || chunkDeadline.Before(deadline)) {
+		deadline = chunkDeadline
+	}
+	if run.fileTimeout > 0 {
+		partDeadline := time.Now().Add(run.fileTimeout)
+		if deadline.IsZero() || partDeadline.Before(deadline) {
+			deadline = partDeadline
+		}
+	}
+	return deadline
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timestampScanLimit is how far into a line a timestamp is looked for
+const timestampScanLimit = 64
+
+var timestampPatterns = []struct {
+	pattern *regexp.Regexp
+	layouts []string
+	iso     bool
+	// noYear layouts lack the year, the one of the last year is taken
+	noYear bool
+}{
+	{
+		// ISO 8601 / RFC 3339 and the common variants with a space or slashes
+		iso:     true,
+		pattern: regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`),
+		layouts: []string{
+			"2006-01-02T15:04:05.999999999Z07:00",
+			"2006-01-02T15:04:05.999999999Z0700",
+			"2006-01-02T15:04:05.999999999",
+		},
+	},
+	{
+		// apache/nginx access logs
+		pattern: regexp.MustCompile(`\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`),
+		layouts: []string{"02/Jan/2006:15:04:05 -0700"},
+	},
+	{
+		// android logcat, the default threadtime and the time formats
+		noYear:  true,
+		pattern: regexp.MustCompile(`^\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}`),
+		layouts: []string{"01-02 15:04:05.000"},
+	},
+}
+
+// ParseTimestamp extracts the first timestamp found at the beginning of the
+// line, timestamps without a zone are taken as UTC
+func ParseTimestamp(line string) (time.Time, bool) {
+	if len(line) > timestampScanLimit {
+		line = line[:timestampScanLimit]
+	}
+	for _, candidate := range timestampPatterns {
+		text := candidate.pattern.FindString(line)
+		if text == "" {
+			continue
+		}
+		if candidate.iso {
+			// normalize the separators of the variants
+			text = strings.Replace(text, "/", "-", 2)
+			text = strings.Replace(text, " ", "T", 1)
+			text = strings.Replace(text, ",", ".", 1)
+		}
+		for _, layout := range candidate.layouts {
+			if ts, err := time.Parse(layout, text); err == nil {
+				if candidate.noYear {
+					ts = withLastYear(ts, time.Now().UTC())
+				}
+				return ts, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// withLastYear sets the year of ts to the one of now, or to the previous one
+// if that would put ts more than a day in the future
+func withLastYear(ts, now time.Time) time.Time {
+	ts = ts.AddDate(now.Year()-ts.Year(), 0, 0)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts
+}
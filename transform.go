@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// transformLineLimit is the longest line kept waiting for its end, longer
+// lines are transformed in pieces
+const transformLineLimit = 64 << 10
+
+// lineTransform rewrites a line, its delimiter included, and returns the
+// result
+type lineTransform func(line []byte) []byte
+
+// transformWriter applies the transforms to each line written to it before
+// passing it to the destination, the lines end with delim
+type transformWriter struct {
+	dst        io.Writer
+	transforms []lineTransform
+	delim      byte
+	pending    []byte
+}
+
+func newTransformWriter(dst io.Writer, transforms []lineTransform, delim byte) *transformWriter {
+	return &transformWriter{dst: dst, transforms: transforms, delim: delim}
+}
+
+func (t *transformWriter) Write(p []byte) (int, error) {
+	data := p
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, t.delim)
+		if end < 0 {
+			t.pending = append(t.pending, data...)
+			if len(t.pending) >= transformLineLimit {
+				if err := t.Flush(); err != nil {
+					return 0, err
+				}
+			}
+			break
+		}
+		t.pending = append(t.pending, data[:end+1]...)
+		data = data[end+1:]
+		if err := t.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the line waiting for its end, even if incomplete
+func (t *transformWriter) Flush() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	line := t.pending
+	for _, transform := range t.transforms {
+		line = transform(line)
+	}
+	_, err := t.dst.Write(line)
+	t.pending = t.pending[:0]
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultTrashDir is the trash directory, relative to the input path, used
+// when --trash-dir is not given. The scan never descends into it.
+const defaultTrashDir = ".trash"
+
+// errNotSameDevice is ERROR_NOT_SAME_DEVICE, returned by the renames across
+// volumes on Windows
+const errNotSameDevice = syscall.Errno(17)
+
+// trashBin keeps the deleted parts of a run in a directory of its own inside
+// dir, the run directories older than ttl are purged
+type trashBin struct {
+	dir string
+	ttl time.Duration
+	run string
+	// rename moves the files, os.Rename
+	rename func(from, to string) error
+}
+
+func newTrashBin(dir string, ttl time.Duration) (*trashBin, error) {
+	run := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.MkdirAll(run, 0755); err != nil {
+		return nil, err
+	}
+	return &trashBin{dir: dir, ttl: ttl, run: run, rename: os.Rename}, nil
+}
+
+// move puts the file in the trash in place of deleting it. A trash on
+// another filesystem than the file gets a copy, then the file is removed.
+func (t *trashBin) move(path string) error {
+	target := filepath.Join(t.run, filepath.Base(path))
+	err := t.rename(path, target)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	log.Debugf("Copying %s to the trash on another filesystem\n", path)
+	if err := copyFile(path, target); err != nil {
+		_ = os.Remove(target)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// isCrossDevice tells the errors of the renames between filesystems
+func isCrossDevice(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EXDEV || runtime.GOOS == "windows" && errno == errNotSameDevice
+}
+
+// copyFile copies the content, the permissions and the mtime of the file at
+// from to a new file at to, synced before it is reported done
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+// purge removes the run directories older than the ttl, a zero ttl keeps
+// them forever
+func (t *trashBin) purge() {
+	if t.ttl <= 0 {
+		return
+	}
+	entries, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		log.Warningf("Reading trash %s: %v\n", t.dir, err)
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(t.dir, entry.Name())
+		if !entry.IsDir() || path == t.run || time.Since(entry.ModTime()) < t.ttl {
+			continue
+		}
+		log.Debugln("[Purge ", path, "]")
+		if err := os.RemoveAll(path); err != nil {
+			log.Warningf("Purge trash error: %v\n", err)
+		}
+	}
+}
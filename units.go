@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a size flag accepting human readable values like 512MB or 1.5G,
+// the units are binary multiples.
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	value  int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size with an optional unit suffix
+func ParseByteSize(value string) (ByteSize, error) {
+	text := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(text, unit.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix))
+			multiplier = unit.value
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return ByteSize(number * float64(multiplier)), nil
+}
+
+func (b *ByteSize) UnmarshalFlag(value string) error {
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func (b ByteSize) MarshalFlag() (string, error) {
+	return strconv.FormatInt(int64(b), 10), nil
+}
+
+func (b ByteSize) String() string {
+	return formatBytes(int64(b))
+}
+
+// Rate is a throughput flag in bytes per second, accepting values like
+// 50MB/s or 512K.
+type Rate int64
+
+func (r *Rate) UnmarshalFlag(value string) error {
+	size, err := ParseByteSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil {
+		return err
+	}
+	*r = Rate(size)
+	return nil
+}
+
+func (r Rate) MarshalFlag() (string, error) {
+	return strconv.FormatInt(int64(r), 10), nil
+}
+
+func (r Rate) String() string {
+	if r <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(int64(r)) + "/s"
+}
+
+// LineRate is a pace flag in lines per second, accepting values like 1000/s,
+// 600/m or 3600/h, a bare number is per second.
+type LineRate float64
+
+var lineRateUnits = []struct {
+	suffix string
+	per    time.Duration
+}{
+	{"/s", time.Second}, {"/m", time.Minute}, {"/h", time.Hour},
+}
+
+func (r *LineRate) UnmarshalFlag(value string) error {
+	text := strings.TrimSpace(value)
+	per := time.Second
+	for _, unit := range lineRateUnits {
+		if strings.HasSuffix(text, unit.suffix) {
+			text = strings.TrimSuffix(text, unit.suffix)
+			per = unit.per
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("invalid rate %q, expected lines like 1000/s", value)
+	}
+	*r = LineRate(number * float64(time.Second) / float64(per))
+	return nil
+}
+
+func (r LineRate) MarshalFlag() (string, error) {
+	return r.String(), nil
+}
+
+func (r LineRate) String() string {
+	if r <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatFloat(float64(r), 'g', -1, 64) + "/s"
+}
+
+// FileMode is a permissions flag in octal, like 0640, 0 means unset
+type FileMode uint32
+
+func (m *FileMode) UnmarshalFlag(value string) error {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid file mode %q, expected octal permissions like 0640", value)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+func (m FileMode) MarshalFlag() (string, error) {
+	return fmt.Sprintf("%04o", uint32(m)), nil
+}
+
+func (m FileMode) String() string {
+	if m == 0 {
+		return "default"
+	}
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+// Delimiter is a flag holding the byte ending the records of the parts,
+// given as a character or as one of the escapes \0, \n, \r and \t. The zero
+// value is the newline.
+type Delimiter string
+
+var delimiterEscapes = map[string]string{`\0`: "\x00", `\n`: "\n", `\r`: "\r", `\t`: "\t"}
+
+func (d *Delimiter) UnmarshalFlag(value string) error {
+	if escaped, ok := delimiterEscapes[value]; ok {
+		value = escaped
+	}
+	if len(value) != 1 {
+		return fmt.Errorf("invalid delimiter %q, expected a single character or \\0, \\n, \\r, \\t", value)
+	}
+	*d = Delimiter(value)
+	return nil
+}
+
+func (d Delimiter) MarshalFlag() (string, error) {
+	return d.String(), nil
+}
+
+func (d Delimiter) String() string {
+	for escape, value := range delimiterEscapes {
+		if string(d.value()) == value {
+			return escape
+		}
+	}
+	return string(d)
+}
+
+// value returns the delimiter byte, the newline when unset
+func (d Delimiter) value() byte {
+	if d == "" {
+		return '\n'
+	}
+	return d[0]
+}
+
+// formatBytes renders a byte count with a binary unit suffix, e.g. 1.5 MB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
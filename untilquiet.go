@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// quietPolls is how many times the growing parts are checked during the
+// quiet time, at most every maxQuietPoll. Without --timeout the wait lasts
+// at most quietWaitFactor times the quiet time.
+const (
+	quietPolls      = 10
+	maxQuietPoll    = time.Second
+	quietWaitFactor = 10
+)
+
+// quietPart is the newest part of a group while it may still be growing
+type quietPart struct {
+	part    *logFile
+	path    string
+	info    os.FileInfo
+	changed time.Time
+}
+
+// waitUntilQuiet waits for the newest part of every group to be left
+// unchanged for quiet, the sizes of the parts are updated as they grow. A
+// part that was rotated, or removed, is no longer waited for and true is
+// returned as the names of the group moved. After maxWait the parts still
+// growing are merged as they are.
+func waitUntilQuiet(basepath string, allFiles FilesList, quiet, maxWait time.Duration) (rotated bool) {
+	if maxWait <= 0 {
+		maxWait = quietWaitFactor * quiet
+	}
+	deadline := time.Now().Add(maxWait)
+	var watched []*quietPart
+	for _, list := range allFiles {
+		var newest *logFile
+		for _, part := range list {
+			if newest == nil || part.modTime.After(newest.modTime) {
+				newest = part
+			}
+		}
+		path := filepath.Join(basepath, newest.name)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		watched = append(watched, &quietPart{part: newest, path: path, info: info, changed: newest.modTime})
+	}
+
+	poll := quiet / quietPolls
+	if poll > maxQuietPoll {
+		poll = maxQuietPoll
+	}
+	for len(watched) > 0 {
+		now := time.Now()
+		growing := watched[:0]
+		for _, w := range watched {
+			info, err := os.Stat(w.path)
+			switch {
+			case err != nil || !os.SameFile(w.info, info):
+				log.Printf("[%s was rotated while waiting for it to go quiet]\n", w.part.name)
+				rotated = true
+				continue
+			case info.Size() != w.info.Size() || !info.ModTime().Equal(w.info.ModTime()):
+				w.info, w.changed = info, now
+				w.part.size, w.part.modTime = info.Size(), info.ModTime()
+			}
+			if now.Sub(w.changed) < quiet {
+				growing = append(growing, w)
+			}
+		}
+		watched = growing
+		if len(watched) > 0 && now.After(deadline) {
+			log.Warnf("Gave up waiting after %v for %d growing parts to go quiet\n", maxWait, len(watched))
+			break
+		}
+		if len(watched) > 0 {
+			log.Debugf("Waiting for %d growing parts to go quiet\n", len(watched))
+			time.Sleep(poll)
+		}
+	}
+	return rotated
+}
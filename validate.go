@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConflictError reports two options that cannot be used together
+type ConflictError struct {
+	First, Second string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("--%s and --%s cannot be used together", e.First, e.Second)
+}
+
+// OptionError reports an invalid value of an option
+type OptionError struct {
+	Option string
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("--%s %v", e.Option, e.Err)
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks the options of a merge before it starts. The options are
+// only read by the merge, the state derived from them is kept by the run,
+// so the same Options can be validated once and used for several runs.
+func (o *Options) Validate() error {
+	if o.SkipErrors && o.Strict {
+		return &ConflictError{"skip-errors", "strict"}
+	}
+	if o.Reverse && o.Order == orderAsc {
+		return &OptionError{"order", errors.New("asc contradicts --Reverse")}
+	}
+	if len(o.Tee) == 0 {
+		if o.ReplayRate > 0 {
+			return &OptionError{"replay-rate", errors.New("paces the tee sinks, --tee is missing")}
+		}
+		if o.ReplayRealtime {
+			return &OptionError{"replay-realtime", errors.New("paces the tee sinks, --tee is missing")}
+		}
+	}
+	if o.Preview < 0 {
+		return &OptionError{"preview", errors.New("must be a positive number of lines")}
+	}
+	if o.Preview > 0 && len(o.Contains) == 0 {
+		return &OptionError{"preview", errors.New("shows the lines matching --contains, which is missing")}
+	}
+	if o.Preview > 0 && o.Count {
+		return &ConflictError{"preview", "count"}
+	}
+	if o.Count && len(o.Contains) == 0 {
+		return &OptionError{"count", errors.New("counts the lines matching --contains, which is missing")}
+	}
+	if o.SplitOnMarker != "" && o.MaxChunks > 1 {
+		return &ConflictError{"split-on-marker", "max-chunks"}
+	}
+	// the sessions of a run do not line up with the outputs of the last one
+	if o.SplitOnMarker != "" && o.IfExists == ifExistsAppend {
+		return &OptionError{"if-exists", errors.New("cannot append to the outputs of --split-on-marker")}
+	}
+	if o.Logrotate == "" && isArchive(string(o.Input)) {
+		if o.Delete {
+			return &OptionError{"delete", errors.New("cannot delete the parts of an archive")}
+		}
+		if o.DeleteEmpty {
+			return &OptionError{"delete-empty", errors.New("cannot delete the parts of an archive")}
+		}
+	}
+	// the names, the logrotate stanza and the dates
+	if _, err := newScanOptions(o); err != nil {
+		return err
+	}
+	if _, err := parseCombineSpecs(o.Combine); err != nil {
+		return err
+	}
+	if _, err := newTagInjector(o.Tag, o.TagPrefix); err != nil {
+		return err
+	}
+	return nil
+}
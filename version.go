@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, injected at build time with:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=abc123 -X main.buildDate=2021-01-01T00:00:00Z"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+type VersionCommand struct{}
+
+func (c *VersionCommand) Execute(args []string) error {
+	return WriteVersion(os.Stdout)
+}
+
+// WriteVersion prints the version, commit and build date of the binary
+// together with the module information embedded by the go toolchain
+func WriteVersion(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "aggregatelogs %s (commit %s, built %s)\n", version, commit, buildDate); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH); err != nil {
+		return err
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "module: %s %s\n", info.Main.Path, info.Main.Version); err != nil {
+		return err
+	}
+	for _, dep := range info.Deps {
+		depVersion := dep.Version
+		if dep.Replace != nil {
+			depVersion = fmt.Sprintf("%s => %s %s", dep.Version, dep.Replace.Path, dep.Replace.Version)
+		}
+		if _, err := fmt.Fprintf(w, "dep: %s %s\n", dep.Path, depVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}